@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+)
+
+// patterns collects -ignore flags, which can be given more than once
+type patterns []*regexp.Regexp
+
+func (p *patterns) String() string {
+	strs := make([]string, len(*p))
+	for i, re := range *p {
+		strs[i] = re.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+func (p *patterns) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, re)
+	return nil
+}
+
+func main() {
+	var ignore patterns
+	addr := flag.String("addr", "localhost:9993", "address of the deck to replay against")
+	timeout := flag.Duration("timeout", 5*time.Second, "how long to wait for each response")
+	settle := flag.Duration("settle", 300*time.Millisecond, "how long to wait for notifications after each response")
+	notifications := flag.Bool("notifications", false, "compare notifications as well as responses")
+	flag.Var(&ignore, "ignore", "regexp matching parts of messages to ignore, e.g. `timecode: .*` (can be repeated)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [flags] transcript.jsonl\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	entries, err := deck.ReadTranscript(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	conn, err := net.Dial("tcp", *addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer conn.Close()
+
+	mismatches, err := deck.Replay(conn, entries, deck.ReplayOptions{
+		Timeout:       *timeout,
+		Settle:        *settle,
+		Notifications: *notifications,
+		Ignore:        ignore,
+	})
+	for _, m := range mismatches {
+		fmt.Println(m)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if len(mismatches) > 0 {
+		fmt.Printf("%v mismatches\n", len(mismatches))
+		os.Exit(1)
+	}
+	fmt.Println("no mismatches")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/josh23french/fakedeck/pkg/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// processAdmin runs an admin command that came in on the admin port
+func (d *VLCDeck) processAdmin(cmd *protocol.Command) string {
+	d.commandLock.Lock()
+	defer d.commandLock.Unlock()
+	return d.admin.Process(cmd)
+}
+
+// registerWorldCommands registers the admin commands that change the world around the deck, for test harnesses
+func (d *VLCDeck) registerWorldCommands() {
+	slotID := protocol.IntRange(1, int64(len(d.slots)))
+
+	d.admin.Register(&deck.CommandSpec{
+		Name:        "slot eject",
+		Description: "eject the virtual disk from slot {n}, or the current slot",
+		Parameters:  []protocol.Parameter{{Name: "slot id", Type: slotID}},
+		Handler: func(params map[string]string) string {
+			return d.adminSetMounted(params, false)
+		},
+	})
+	d.admin.Register(&deck.CommandSpec{
+		Name:        "slot insert",
+		Description: "insert the virtual disk back into slot {n}, or the current slot",
+		Parameters:  []protocol.Parameter{{Name: "slot id", Type: slotID}},
+		Handler: func(params map[string]string) string {
+			return d.adminSetMounted(params, true)
+		},
+	})
+	d.admin.Register(&deck.CommandSpec{
+		Name:        "slot fill",
+		Description: "pretend slot {n}'s disk only has {MB} free, or reset it to the real free space",
+		Parameters: []protocol.Parameter{
+			{Name: "slot id", Type: slotID},
+			{Name: "free", Type: protocol.IntRange(0, math.MaxInt32)},
+			{Name: "reset", Type: protocol.Bool},
+		},
+		Handler: d.adminFill,
+	})
+	d.admin.Register(&deck.CommandSpec{
+		Name:        "input",
+		Description: `set the input signal to "bars", a file, a URL, or none`,
+		Parameters: []protocol.Parameter{
+			{Name: "source"},
+			{Name: "format"},
+		},
+		Handler: d.adminInput,
+	})
+	d.admin.Register(&deck.CommandSpec{
+		Name:        "clock advance",
+		Description: "move the deck's clock forward {ms} milliseconds, when it's only moved by being told to",
+		Parameters:  []protocol.Parameter{{Name: "ms", Type: protocol.IntRange(0, math.MaxInt32)}},
+		Handler:     d.adminClockAdvance,
+	})
+	d.admin.Register(&deck.CommandSpec{
+		Name:        "state",
+		Description: "query the deck's internal state",
+		Handler:     d.adminState,
+	})
+}
+
+// adminSlot returns the slot named by a slot id parameter, or the current slot
+func (d *VLCDeck) adminSlot(params map[string]string) (uint, *Slot) {
+	slotID := d.state.slotID
+	if slotStr, ok := params["slot id"]; ok {
+		slot, _ := strconv.ParseUint(slotStr, 10, 0)
+		slotID = uint(slot)
+	}
+	if slotID == 0 {
+		return 0, nil
+	}
+	return slotID, d.slots[slotID-1]
+}
+
+func (d *VLCDeck) adminSetMounted(params map[string]string, mounted bool) string {
+	slotID, slot := d.adminSlot(params)
+	if slot == nil {
+		return protocol.ErrNoDisk
+	}
+	if slot.Mounted() == mounted {
+		return "200 ok"
+	}
+
+	if slotID == d.state.slotID {
+		if mounted {
+			// the timeline comes back from the disk, like it does at power on
+			for _, diskClip := range slot.Clips() {
+				err := d.timeline.AddClip(diskClip)
+				if err != nil {
+					log.Error().Err(err).Msg("error adding clip from reinserted disk to timeline")
+				}
+			}
+		} else {
+			// the timeline was made of clips from the disk that's gone
+			d.timeline.ClearPlayRange()
+			err := d.timeline.ClearClips()
+			if err != nil {
+				log.Error().Err(err).Msg("error clearing timeline after eject")
+			}
+			err = d.timeline.StopOnBlack()
+			if err != nil {
+				log.Error().Err(err).Msg("error stopping timeline after eject")
+			}
+		}
+	}
+	slot.SetMounted(mounted) // which notifies the slot info
+	return "200 ok"
+}
+
+func (d *VLCDeck) adminFill(params map[string]string) string {
+	_, slot := d.adminSlot(params)
+	if slot == nil {
+		return protocol.ErrNoDisk
+	}
+	if params["reset"] == "true" {
+		slot.SetFreeSpace(-1)
+	} else if freeStr, ok := params["free"]; ok {
+		free, _ := strconv.ParseInt(freeStr, 10, 64)
+		slot.SetFreeSpace(free * 1e6)
+	} else {
+		return protocol.ErrSyntax
+	}
+	d.checkCache() // the recording time remaining has changed
+	return "200 ok"
+}
+
+func (d *VLCDeck) adminInput(params map[string]string) string {
+	source, ok := params["source"]
+	if !ok {
+		return protocol.ErrSyntax
+	}
+	if source == "none" {
+		d.input = nil
+		return "200 ok"
+	}
+	input, err := NewInputSource(source, params["format"])
+	if err != nil {
+		log.Warn().Err(err).Msgf("error setting input to %v", source)
+		return protocol.ErrInvalidValue
+	}
+	d.input = input
+	return "200 ok"
+}
+
+func (d *VLCDeck) adminClockAdvance(params map[string]string) string {
+	if d.fake == nil {
+		return protocol.ErrUnsupported
+	}
+	msStr, ok := params["ms"]
+	if !ok {
+		return protocol.ErrSyntax
+	}
+	ms, _ := strconv.ParseInt(msStr, 10, 64)
+	// what's due runs on this goroutine, and the timeline takes the command lock to move the transport, so let go of
+	// it until the clock's caught up
+	d.commandLock.Unlock()
+	d.fake.Advance(time.Duration(ms) * time.Millisecond)
+	d.commandLock.Lock()
+	return "200 ok"
+}
+
+func (d *VLCDeck) adminState(params map[string]string) string {
+	lines := make([]string, 0)
+	lines = append(lines, fmt.Sprintf("status: %v", d.timeline.TransportStatus()))
+	lines = append(lines, fmt.Sprintf("slot id: %v", d.state.slotID))
+	lines = append(lines, fmt.Sprintf("loop: %v", d.timeline.loop))
+	lines = append(lines, fmt.Sprintf("single clip: %v", d.timeline.singleClip))
+	lines = append(lines, fmt.Sprintf("timecode: %v", d.timeline.Timecode()))
+	lines = append(lines, fmt.Sprintf("clock: %v", d.clock.Now().Format(time.RFC3339Nano)))
+	for idx, slot := range d.slots {
+		status := "empty"
+		if slot.Mounted() {
+			status = "mounted"
+		}
+		free := "unknown"
+		if bytes, err := slot.FreeSpace(); err == nil {
+			free = strconv.FormatUint(bytes/1e6, 10)
+		}
+		lines = append(lines, fmt.Sprintf("slot %v: %v, %v clips, %v MB free", idx+1, status, len(slot.Clips()), free))
+	}
+	input := "none"
+	if d.input != nil {
+		input = fmt.Sprintf("%v (%v)", d.input.Name, d.input.Format)
+	}
+	lines = append(lines, fmt.Sprintf("input: %v", input))
+	lines = append(lines, fmt.Sprintf("remote enabled: %v", d.remote.Enabled))
+	lines = append(lines, fmt.Sprintf("remote override: %v", d.remote.Override))
+	lines = append(lines, fmt.Sprintf("notify: %+v", d.notify))
+	lines = append(lines, fmt.Sprintf("identifying: %v", d.identifying()))
+	return "293 state:\r\n" + strings.Join(lines, "\r\n") + "\r\n"
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	meterInterval   = 100 * time.Millisecond // how often levels are measured (and streamed)
+	meterSampleRate = 48000
+	meterMaxDrift   = 500 * time.Millisecond // how far the player can get from the decoder before it's restarted
+)
+
+// vlcChannels are libVLC's audio channel positions, in the order its remap filter numbers them
+var vlcChannels = []string{"left", "center", "right", "rearleft", "rearcenter", "rearright", "middleleft", "middleright", "lfe"}
+
+// audioRouting returns the media options that route playback onto channels channels, the same way
+// deck.RouteChannels does for the meter: channels beyond the configured count are folded back onto it.
+func audioRouting(channels int) []string {
+	options := []string{":audio-filter=remap"}
+	for n, name := range vlcChannels {
+		options = append(options, fmt.Sprintf(":aout-remap-channel-%v=%v", name, n%channels))
+	}
+	return options
+}
+
+// audioMeter measures the peak levels of the audio being played, by decoding the current clip with ffmpeg
+// alongside libVLC (which won't hand over its decoded audio without taking over the output) and keeping pace with
+// the player.
+type audioMeter struct {
+	channels int // channels to route the audio onto
+
+	path       string        // clip being decoded; empty if nothing is
+	inChannels int           // channels in the clip
+	position   time.Duration // how far into the clip the decoder has got
+	decoder    *exec.Cmd
+	pcm        io.ReadCloser
+}
+
+func newAudioMeter(channels int) *audioMeter {
+	return &audioMeter{
+		channels: channels,
+	}
+}
+
+// Measure returns the peak levels of the audio from where the meter last got to up to position in path. It's
+// silence if there's nothing playing.
+func (m *audioMeter) Measure(path string, position time.Duration, playing bool) deck.AudioLevels {
+	if !playing || path == "" {
+		m.stop()
+		return deck.Silence(m.channels)
+	}
+
+	if path != m.path || position < m.position || position-m.position > meterMaxDrift {
+		err := m.start(path, position)
+		if err != nil {
+			log.Warn().Err(err).Msgf("error decoding audio of %v", path)
+			m.stop()
+			return deck.Silence(m.channels)
+		}
+		return deck.Silence(m.channels)
+	}
+
+	frames := int((position - m.position) * meterSampleRate / time.Second)
+	samples := make([]int16, frames*m.inChannels)
+	err := binary.Read(m.pcm, binary.LittleEndian, samples)
+	if err != nil {
+		// end of the clip, or it has no audio
+		m.stop()
+		return deck.Silence(m.channels)
+	}
+	m.position = position
+	return deck.PeakLevels(deck.RouteChannels(samples, m.inChannels, m.channels), m.channels)
+}
+
+// SetChannels changes the channels the audio is routed onto
+func (m *audioMeter) SetChannels(channels int) {
+	m.channels = channels
+}
+
+func (m *audioMeter) start(path string, position time.Duration) error {
+	m.stop()
+
+	inChannels, err := probeAudioChannels(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := exec.Command("ffmpeg", "-v", "error", "-ss", strconv.FormatFloat(position.Seconds(), 'f', 3, 64),
+		"-i", path, "-map", "0:a:0", "-f", "s16le", "-acodec", "pcm_s16le", "-ar", strconv.Itoa(meterSampleRate), "-")
+	pcm, err := decoder.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error getting decoder output: %w", err)
+	}
+	err = decoder.Start()
+	if err != nil {
+		return fmt.Errorf("error starting decoder: %w", err)
+	}
+
+	m.path = path
+	m.inChannels = inChannels
+	m.position = position
+	m.decoder = decoder
+	m.pcm = pcm
+	return nil
+}
+
+func (m *audioMeter) stop() {
+	if m.decoder != nil {
+		m.pcm.Close()
+		m.decoder.Process.Kill()
+		m.decoder.Wait()
+	}
+	m.path = ""
+	m.decoder = nil
+	m.pcm = nil
+}
+
+// probeAudioChannels asks ffprobe how many channels the first audio stream of path has
+func probeAudioChannels(path string) (int, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=channels", "-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("error probing audio channels: %w", err)
+	}
+	channels, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || channels < 1 {
+		return 0, fmt.Errorf("no audio in %v", path)
+	}
+	return channels, nil
+}
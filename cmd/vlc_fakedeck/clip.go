@@ -3,20 +3,26 @@ package main
 import (
 	"errors"
 	"fmt"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	vlc "github.com/adrg/libvlc-go/v3"
+	"github.com/josh23french/fakedeck/pkg/deck"
 	"github.com/rs/zerolog/log"
 	"trimmer.io/go-timecode/timecode"
 )
 
-// Clip is a representation of a clip along with vlc.Media
+// Clip is a representation of a clip on the timeline, along with the Media to play it
 type Clip struct {
-	Name     string // this is the key
-	Duration timecode.Timecode
-	path     string // full path to file
-	media    *vlc.Media
-	Start    timecode.Timecode
+	Name         string // this is the key
+	Duration     timecode.Timecode
+	DynamicRange string
+	path         string // full path to file
+	media        *Media
+	Start        timecode.Timecode
 
 	cIn  uint // Inpoint of the clip
 	cOut uint // Outpoint of clip
@@ -28,21 +34,63 @@ type Clip struct {
 }
 
 type DiskClip struct {
-	Name     string // this is the key
-	Duration timecode.Timecode
-	path     string // full path to file
-	media    *vlc.Media
+	Name         string // this is the key
+	Duration     timecode.Timecode
+	DynamicRange string
+	path         string // full path to file
+	media        *Media
 }
 
-func NewDiskClip(path string) (*DiskClip, error) {
+// ClipProbe finds out what NewDiskClip needs to know about a clip from its file
+type ClipProbe struct {
+	Duration func(path string) (time.Duration, error)                  // how long it plays for
+	Color    func(path string) (transfer, primaries string, err error) // its video's color, named as ffprobe names it
+}
+
+// NewClipProbe makes the probe for engine: libVLC's parser for the vlc engine, which has it loaded anyway, and
+// ffprobe for the others. Without ffprobe, their probe fails for every clip, so that's an error here too.
+func NewClipProbe(engine string) (ClipProbe, error) {
+	probe := ClipProbe{
+		Duration: ffprobeDuration,
+		Color:    ffprobeColor,
+	}
+	if engine == "vlc" {
+		probe.Duration = vlcDuration
+		return probe, nil
+	}
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return probe, fmt.Errorf("the %v engine needs ffprobe to probe clips: %w", engine, err)
+	}
+	return probe, nil
+}
+
+// NewDiskClip probes the clip at path for how long it is and its dynamic range
+func NewDiskClip(path string, probe ClipProbe) (*DiskClip, error) {
+	dur, err := probe.Duration(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiskClip{
+		Name:         filepath.Base(path),
+		Duration:     timecode.New(dur, timecode.Rate60DF),
+		DynamicRange: probe.dynamicRange(path),
+		path:         path,
+		media:        &Media{Location: path, Duration: dur},
+	}, nil
+}
+
+// vlcDuration has libVLC parse the media at path to find out how long it is; libVLC has to have been initialized
+func vlcDuration(path string) (time.Duration, error) {
 	media, err := vlc.NewMediaFromPath(path)
 	if err != nil {
-		return nil, fmt.Errorf("error creating new media: %v", err)
+		return 0, fmt.Errorf("error creating new media: %v", err)
 	}
+	defer media.Release() // only needed to probe the clip; the engine makes its own to play it
 
 	em, err := media.EventManager()
 	if err != nil {
-		return nil, fmt.Errorf("error getting media EventManager: %v", err)
+		return 0, fmt.Errorf("error getting media EventManager: %v", err)
 	}
 
 	cancelParseHandler := false
@@ -69,12 +117,12 @@ func NewDiskClip(path string) (*DiskClip, error) {
 		parseErr <- errors.New("MediaParsedChanged handler called, but parsing wasn't done!")
 	}, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error attaching MediaParsedChanged handler: %v", err)
+		return 0, fmt.Errorf("error attaching MediaParsedChanged handler: %v", err)
 	}
 
 	err = media.ParseWithOptions(-1)
 	if err != nil {
-		return nil, fmt.Errorf("error starting media parse: %v", err)
+		return 0, fmt.Errorf("error starting media parse: %v", err)
 	}
 
 	// wait for parse to finish... :(
@@ -118,18 +166,68 @@ loop:
 	log.Debug().Msg("getting duration...")
 	dur, err := media.Duration()
 	if err != nil {
-		return nil, fmt.Errorf("error getting media duration: %v", err)
+		return 0, fmt.Errorf("error getting media duration: %v", err)
 	}
+	return dur, nil
+}
 
-	log.Debug().Msg("returning new DiskClip!")
-	return &DiskClip{
-		Name:     filepath.Base(path),
-		Duration: timecode.New(dur, timecode.Rate60DF),
-		path:     path,
-		media:    media,
-	}, nil
+// ffprobeDuration asks ffprobe how long the media at path is
+func ffprobeDuration(path string) (time.Duration, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("error probing duration: %w", err)
+	}
+	secs, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || secs <= 0 {
+		return 0, fmt.Errorf("no duration for %v", path)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// dynamicRange works out a clip's dynamic range from the transfer characteristics and color primaries of its video.
+// If they can't be found, like without ffprobe, it's assumed to be Rec709.
+func (p ClipProbe) dynamicRange(path string) string {
+	transfer, primaries, err := p.Color(path)
+	if err != nil {
+		log.Debug().Err(err).Msgf("error probing dynamic range of %v; assuming Rec709", path)
+		return deck.DynamicRangeRec709
+	}
+
+	switch {
+	case transfer == "arib-std-b67":
+		return deck.DynamicRangeHLG
+	case transfer == "smpte2084":
+		return deck.DynamicRangePQ1000 // mastering luminance isn't in the stream info; 1000 nits is the common case
+	case primaries == "bt2020":
+		return deck.DynamicRangeRec2020SDR
+	}
+	return deck.DynamicRangeRec709
+}
+
+// ffprobeColor asks ffprobe for the transfer characteristics and color primaries of the first video stream at path
+func ffprobeColor(path string) (transfer, primaries string, err error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=color_transfer,color_primaries", "-of", "default=noprint_wrappers=1", path).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("error probing color: %w", err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "color_transfer":
+			transfer = kv[1]
+		case "color_primaries":
+			primaries = kv[1]
+		}
+	}
+	return transfer, primaries, nil
 }
 
-func (c *DiskClip) Media() *vlc.Media {
+func (c *DiskClip) Media() *Media {
 	return c.media
 }
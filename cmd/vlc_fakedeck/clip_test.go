@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClipDynamicRange(t *testing.T) {
+	for _, c := range []struct {
+		transfer, primaries string
+		err                 error
+		want                string
+	}{
+		{"bt709", "bt709", nil, deck.DynamicRangeRec709},
+		{"", "", nil, deck.DynamicRangeRec709},
+		{"bt2020-10", "bt2020", nil, deck.DynamicRangeRec2020SDR},
+		{"arib-std-b67", "bt2020", nil, deck.DynamicRangeHLG},
+		{"smpte2084", "bt2020", nil, deck.DynamicRangePQ1000},
+		{"", "", errors.New("no ffprobe"), deck.DynamicRangeRec709},
+	} {
+		probe := ClipProbe{
+			Duration: func(path string) (time.Duration, error) { return 10 * time.Second, nil },
+			Color:    func(path string) (string, string, error) { return c.transfer, c.primaries, c.err },
+		}
+		clip, err := NewDiskClip("a.mov", probe)
+		require.NoError(t, err)
+		assert.Equal(t, c.want, clip.DynamicRange, "transfer %q, primaries %q, error %v", c.transfer, c.primaries, c.err)
+	}
+}
+
+func TestFFProbeColorOfNonVideo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakedeck-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.mov")
+	require.NoError(t, ioutil.WriteFile(path, []byte("not a video"), 0644))
+
+	_, _, err = ffprobeColor(path)
+	assert.Error(t, err, "should fail whether or not ffprobe is installed")
+	probe := ClipProbe{Color: ffprobeColor}
+	assert.Equal(t, deck.DynamicRangeRec709, probe.dynamicRange(path), "should fall back to Rec709")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"net"
+)
+
+// Config is how the deck is set up when it's created
+type Config struct {
+	Name        string // what the deck calls itself, e.g. when identifying
+	UniqueID    string // advertised over mDNS; a MAC address as hex, like real decks, if empty
+	MDNS        bool   // advertise the deck over mDNS/DNS-SD, under names made unique with the end of UniqueID
+	SlotsPath   string // directory containing a directory for each slot
+	Input       string // simulated input: "bars", a file, or a URL like v4l2:///dev/video0; empty for no input
+	InputFormat string // video format to report for the input; detected from the input if empty
+
+	AudioChannels int // audio input channels the deck starts configured for: 2, 4, 8 or 16
+
+	Addr     string // address for the HyperDeck protocol to listen on, e.g. ":9993"
+	FTPAddr  string // address for the FTP server to listen on, e.g. ":21"; empty disables it
+	HTTPAddr string // address for the REST API to listen on, e.g. "127.0.0.1:8080"; local by default; empty disables it
+
+	AdminAddr     string // address for admin commands to listen on, e.g. "127.0.0.1:9994"; local by default; empty disables it
+	ProtocolAdmin bool   // also take admin commands over the HyperDeck protocol, behind an "admin " prefix
+
+	TranscriptsPath string // directory to record a transcript of each session into; empty disables recording
+
+	Engine      string // what plays the media: "vlc", "mpv", or "sim" to just keep time
+	MPVPath     string // mpv to run for the mpv engine
+	Output      string // where the picture goes: "window", "headless" or "frames"
+	FramesPath  string // directory the frames output writes frames into
+	FramesRatio int    // the frames output writes every FramesRatio-th frame
+
+	FakeClock bool // only move time forward when told to with the admin clock advance command, for deterministic tests
+
+	FormatSandbox string // directory format empties a folder in for each slot it formats and points the slot at, so real media is never wiped; empty disables format
+
+	CacheSize      float64 // simulated record cache size in MB
+	CacheFillRate  float64 // MB/s going into the cache while recording
+	CacheDrainRate float64 // MB/s going from the cache to the disk
+}
+
+// ConfigFromFlags builds a Config from the command line
+func ConfigFromFlags() Config {
+	c := Config{}
+	flag.StringVar(&c.Name, "name", "fakedeck", "name of the deck")
+	flag.StringVar(&c.UniqueID, "unique-id", "", "unique ID to advertise (defaults to a MAC address)")
+	flag.BoolVar(&c.MDNS, "mdns", false, "advertise the deck over mDNS, named after its name and the end of its unique ID")
+	flag.StringVar(&c.SlotsPath, "slots", "/home/playout/slots/", "directory containing a directory for each slot")
+	flag.StringVar(&c.Input, "input", "", `simulated input source: "bars", a file, or a URL like v4l2:///dev/video0`)
+	flag.StringVar(&c.InputFormat, "input-format", "", "video format to report for the input (detected if not set)")
+	flag.IntVar(&c.AudioChannels, "audio-channels", 2, "audio input channels to start with: 2, 4, 8 or 16")
+	flag.StringVar(&c.Addr, "addr", ":9993", "address to serve the HyperDeck protocol on")
+	flag.StringVar(&c.FTPAddr, "ftp", "", `address to serve slot media over FTP on, e.g. ":21" (off unless set)`)
+	flag.StringVar(&c.HTTPAddr, "http", "127.0.0.1:8080", `address to serve the REST API on, only locally by default ("" to disable)`)
+	flag.StringVar(&c.AdminAddr, "admin", "127.0.0.1:9994", `address to serve admin commands on, only locally by default ("" to disable)`)
+	flag.BoolVar(&c.ProtocolAdmin, "protocol-admin", false, `also take admin commands on the HyperDeck protocol port, prefixed with "admin "`)
+	flag.StringVar(&c.TranscriptsPath, "transcripts", "", `directory to record session transcripts into ("" to disable)`)
+	flag.StringVar(&c.Engine, "engine", "vlc", `what plays the media: "vlc", "mpv" (which can play backwards), or "sim" to play nothing and just keep time`)
+	flag.StringVar(&c.MPVPath, "mpv", "mpv", "mpv to run for the mpv engine")
+	flag.StringVar(&c.Output, "output", "window", `where the picture goes: "window", "headless" (no display) or "frames" (PNGs)`)
+	flag.StringVar(&c.FramesPath, "frames", "frames", "directory the frames output writes frames into")
+	flag.IntVar(&c.FramesRatio, "frames-ratio", 1, "write every nth frame with the frames output")
+	flag.BoolVar(&c.FakeClock, "fake-clock", false, "only move time forward when told to with admin clock advance")
+	flag.StringVar(&c.FormatSandbox, "format-sandbox", "", "directory to hold formatted slots (format is refused if not set)")
+	flag.Float64Var(&c.CacheSize, "cache-size", 1024, "simulated record cache size in MB")
+	flag.Float64Var(&c.CacheFillRate, "cache-fill-rate", 30, "MB/s going into the record cache while recording")
+	flag.Float64Var(&c.CacheDrainRate, "cache-drain-rate", 100, "MB/s going from the record cache to the disk")
+	flag.Parse()
+	if c.UniqueID == "" {
+		c.UniqueID = defaultUniqueID()
+	}
+	return c
+}
+
+// defaultUniqueID is the first network interface's MAC address, which is what real decks use
+func defaultUniqueID() string {
+	ifaces, err := net.Interfaces()
+	if err == nil {
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagLoopback == 0 && len(iface.HardwareAddr) > 0 {
+				return hex.EncodeToString(iface.HardwareAddr)
+			}
+		}
+	}
+	return "000000000000"
+}
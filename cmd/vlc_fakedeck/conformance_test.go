@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/conformance"
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/stretchr/testify/require"
+)
+
+// Where the transport is depends on how long the captures take to replay, and the timecodes of clips on how the clock
+// rate's counted
+const (
+	ignoreTimecodes = `\d\d:\d\d:\d\d[:;]\d\d`
+	ignoreFrames    = `timeline( in| out)?: \d+`
+)
+
+// conformanceCases are pkg/conformance's captures, with where VLCDeck is known to differ from them. Their order
+// matters, as they share the deck: notify.jsonl expects remote notifications to be off, which remote.jsonl turns on.
+var conformanceCases = []conformance.Case{
+	{Capture: "connection.jsonl"},
+	{Capture: "watchdog.jsonl"},
+	{Capture: "notify.jsonl", Notifications: true},
+	{Capture: "remote.jsonl", Notifications: true},
+	{Capture: "multiline.jsonl"},
+	{Capture: "transport.jsonl", Notifications: true, Ignore: []string{ignoreTimecodes, ignoreFrames}},
+	{Capture: "clips.jsonl", Ignore: []string{ignoreTimecodes}},
+	{Capture: "slot.jsonl"},
+	{Capture: "playrange.jsonl", Ignore: []string{ignoreTimecodes, ignoreFrames}},
+	{
+		Capture:  "goto.jsonl",
+		Ignore:   []string{ignoreTimecodes, ignoreFrames},
+		Diverges: "goto plays the clip it goes to, rather than leaving the transport stopped",
+	},
+}
+
+func TestConformance(t *testing.T) {
+	config := testConfig(t)
+	config.FakeClock = false // notifications wait on the clock, and nothing's here to advance it
+	d := VLCDeckNew(config)
+	for _, clip := range []*DiskClip{testClip("Clip0001.mov", 10*time.Second), testClip("Clip0002.mov", 5*time.Second)} {
+		clip.DynamicRange = deck.DynamicRangeRec709
+		require.NoError(t, d.timeline.AddClip(clip))
+	}
+	d.server.Serve() // just the protocol port; PowerOn would block showing the output
+	defer d.server.Close()
+
+	conformance.Run(t, d.server.Addr().String(), "../../pkg/conformance/testdata", conformanceCases)
+}
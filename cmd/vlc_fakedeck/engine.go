@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"sync"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+)
+
+// Media is something a PlaybackEngine can load: a file or URL, or a still picture the deck makes up
+type Media struct {
+	Location string        // path or URL; empty if Image is set
+	Image    []byte        // PNG to show as a still, for pictures the deck makes up, like bars and black
+	Repeat   bool          // play it over and over, like a live input
+	Duration time.Duration // how long it plays for, if it's known; 0 for stills and live inputs
+}
+
+// ImageMedia makes Media that shows img forever
+func ImageMedia(img image.Image) (*Media, error) {
+	buf := bytes.NewBuffer(nil)
+	err := png.Encode(buf, img)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding image: %w", err)
+	}
+	return &Media{Image: buf.Bytes()}, nil
+}
+
+// PlaybackEngine plays media for a TimelinePlayer, which keeps track of the timeline and the transport itself and
+// tells the engine what to show
+type PlaybackEngine interface {
+	// Load replaces whatever's loaded with media, stopped at its start
+	Load(media *Media) error
+	Play() error
+	Pause() error
+	Seek(pos time.Duration) error
+	// SetRate changes how fast it plays; 1 is normal speed
+	SetRate(rate float64) error
+	// Position returns how far into the loaded media it is
+	Position() (time.Duration, error)
+	// SetAudioChannels routes the audio of media loaded from now on onto channels channels
+	SetAudioChannels(channels int) error
+	// OnEnd calls fn with the media that ended whenever the loaded media plays to its end, never on a goroutine that's
+	// calling into the engine. It can be called after other media has been loaded, so fn should check the media is the
+	// one it expects.
+	OnEnd(fn func(media *Media))
+}
+
+// ReversibleEngine is a PlaybackEngine that can step a frame at a time and play backwards, given a negative rate
+type ReversibleEngine interface {
+	PlaybackEngine
+	// StepFrame shows the next frame, or the previous one if forward is false, leaving it paused
+	StepFrame(forward bool) error
+}
+
+// NewPlaybackEngine creates the engine named by config.Engine
+func NewPlaybackEngine(config Config, clock deck.Clock) (PlaybackEngine, error) {
+	switch config.Engine {
+	case "vlc":
+		return newVLCEngine()
+	case "mpv":
+		return newMPVEngine(config.MPVPath, mpvOutputOptions(config))
+	case "sim":
+		return newSimEngine(clock), nil
+	}
+	return nil, fmt.Errorf(`unknown engine %q; must be "vlc", "mpv" or "sim"`, config.Engine)
+}
+
+// simEngine plays nothing, and just keeps time with the clock, so the timeline can be tested without decoding any
+// media. It's exact on a fake clock, and calls end handlers on the clock's goroutine, so on a fake one they're done by
+// the time it's advanced. Given a negative rate it plays backwards to the start, which counts as the end.
+type simEngine struct {
+	sync.Mutex
+	clock      deck.Clock
+	media      *Media
+	playing    bool
+	rate       float64
+	anchorPos  time.Duration // position as of anchorTime
+	anchorTime time.Time
+	end        deck.Timer // fires when the media plays to its end; nil unless playing media with a duration
+	onEnd      []func(media *Media)
+}
+
+func newSimEngine(clock deck.Clock) *simEngine {
+	return &simEngine{
+		clock: clock,
+		rate:  1,
+	}
+}
+
+// position returns how far into the media it is; the lock must be held
+func (e *simEngine) position() time.Duration {
+	pos := e.anchorPos
+	if e.playing {
+		pos += time.Duration(float64(e.clock.Now().Sub(e.anchorTime)) * e.rate)
+	}
+	if e.media != nil && e.media.Duration > 0 && pos > e.media.Duration {
+		return e.media.Duration
+	}
+	if pos < 0 {
+		return 0
+	}
+	return pos
+}
+
+// set puts it at pos, moving if playing, and works out when it'll reach the end; the lock must be held
+func (e *simEngine) set(pos time.Duration, playing bool) {
+	e.anchorPos = pos
+	e.anchorTime = e.clock.Now()
+	e.playing = playing
+	if e.end != nil {
+		e.end.Stop()
+		e.end = nil
+	}
+	if !playing || e.media == nil || e.media.Duration == 0 || e.rate == 0 {
+		return
+	}
+	remaining := time.Duration(float64(e.media.Duration-pos) / e.rate)
+	if e.rate < 0 {
+		remaining = time.Duration(float64(pos) / -e.rate)
+	}
+	e.end = e.clock.AfterFunc(remaining, e.ended)
+}
+
+func (e *simEngine) ended() {
+	e.Lock()
+	if e.rate < 0 {
+		e.set(0, false)
+	} else {
+		e.set(e.media.Duration, false)
+	}
+	media, onEnd := e.media, e.onEnd
+	e.Unlock()
+	for _, fn := range onEnd {
+		fn(media)
+	}
+}
+
+func (e *simEngine) Load(media *Media) error {
+	e.Lock()
+	defer e.Unlock()
+	e.media = media
+	e.set(0, false)
+	return nil
+}
+
+func (e *simEngine) Play() error {
+	e.Lock()
+	defer e.Unlock()
+	e.set(e.position(), true)
+	return nil
+}
+
+func (e *simEngine) Pause() error {
+	e.Lock()
+	defer e.Unlock()
+	e.set(e.position(), false)
+	return nil
+}
+
+func (e *simEngine) Seek(pos time.Duration) error {
+	e.Lock()
+	defer e.Unlock()
+	e.set(pos, e.playing)
+	return nil
+}
+
+func (e *simEngine) SetRate(rate float64) error {
+	e.Lock()
+	defer e.Unlock()
+	pos := e.position()
+	e.rate = rate
+	e.set(pos, e.playing)
+	return nil
+}
+
+func (e *simEngine) Position() (time.Duration, error) {
+	e.Lock()
+	defer e.Unlock()
+	return e.position(), nil
+}
+
+func (e *simEngine) SetAudioChannels(channels int) error {
+	return nil
+}
+
+func (e *simEngine) OnEnd(fn func(media *Media)) {
+	e.Lock()
+	defer e.Unlock()
+	e.onEnd = append(e.onEnd, fn)
+}
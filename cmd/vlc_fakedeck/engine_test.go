@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func simPosition(t *testing.T, e *simEngine) time.Duration {
+	pos, err := e.Position()
+	require.NoError(t, err)
+	return pos
+}
+
+func TestSimEngine(t *testing.T) {
+	clock := deck.NewFakeClock(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	e := newSimEngine(clock)
+	ended := make(chan *Media, 1)
+	e.OnEnd(func(media *Media) {
+		ended <- media
+	})
+	media := &Media{Location: "a.mov", Duration: 10 * time.Second}
+	require.NoError(t, e.Load(media))
+
+	clock.Advance(time.Second)
+	assert.Equal(t, time.Duration(0), simPosition(t, e), "shouldn't move until it's played")
+
+	require.NoError(t, e.Play())
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, 2*time.Second, simPosition(t, e), "should keep time with the clock")
+
+	require.NoError(t, e.SetRate(2))
+	clock.Advance(time.Second)
+	assert.Equal(t, 4*time.Second, simPosition(t, e), "should move twice as fast at rate 2")
+
+	require.NoError(t, e.Pause())
+	clock.Advance(time.Second)
+	assert.Equal(t, 4*time.Second, simPosition(t, e), "shouldn't move while paused")
+
+	require.NoError(t, e.Seek(9*time.Second))
+	require.NoError(t, e.Play())
+	clock.Advance(time.Second)
+	assert.Equal(t, media, <-ended, "should say which media ended")
+	assert.Equal(t, 10*time.Second, simPosition(t, e), "should stop at the end")
+}
+
+func TestSimEngineBackwards(t *testing.T) {
+	clock := deck.NewFakeClock(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	e := newSimEngine(clock)
+	ended := make(chan *Media, 1)
+	e.OnEnd(func(media *Media) {
+		ended <- media
+	})
+	media := &Media{Location: "a.mov", Duration: 10 * time.Second}
+	require.NoError(t, e.Load(media))
+	require.NoError(t, e.Seek(3*time.Second))
+	require.NoError(t, e.SetRate(-1))
+	require.NoError(t, e.Play())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, 2*time.Second, simPosition(t, e), "should play backwards")
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, media, <-ended, "reaching the start should count as the end")
+	assert.Equal(t, time.Duration(0), simPosition(t, e), "should stop at the start")
+}
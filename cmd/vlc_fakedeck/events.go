@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// notificationProperties are the REST properties each asynchronous notification changes, by its code
+var notificationProperties = map[string][]string{
+	"502": {"/media/workingset", "/media/active", "/timelines/0"},
+	"508": {"/transports/0", "/transports/0/playback", "/transports/0/record", "/transports/0/clipIndex"},
+	"513": {"/transports/0/timecode"},
+	"514": {"/transports/0/playback"},
+}
+
+// notifications are the asynchronous notifications the deck sends, which can be subscribed to as they are under
+// /notifications/{name}
+var notifications = []string{
+	"slot info", "transport info", "remote info", "configuration", "display timecode", "timeline position",
+	"playrange info", "cache info", "dynamic range", "audio levels",
+}
+
+// coalescedNotifications are the notifications that come so often only the latest matters to a subscriber, like REST
+// properties, whose whole value is sent every time
+var coalescedNotifications = map[string]bool{
+	"/notifications/display timecode":  true,
+	"/notifications/timeline position": true,
+	"/notifications/audio levels":      true,
+}
+
+// eventWriteTimeout is how long a subscriber gets to take each message before they're disconnected
+const eventWriteTimeout = 5 * time.Second
+
+// maxQueuedEvents is how many messages can wait to be written to a subscriber before they're disconnected for not
+// keeping up
+const maxQueuedEvents = 256
+
+// eventMessage is what goes back and forth over the event WebSocket
+type eventMessage struct {
+	Type string    `json:"type"` // request, response or event
+	Data eventData `json:"data"`
+}
+
+type eventData struct {
+	Action     string                 `json:"action"` // subscribe, unsubscribe, listProperties or propertyValueChanged
+	Properties []string               `json:"properties,omitempty"`
+	Property   string                 `json:"property,omitempty"`
+	Value      interface{}            `json:"value,omitempty"`
+	Values     map[string]interface{} `json:"values,omitempty"`
+	Success    *bool                  `json:"success,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// eventHub pushes property changes to WebSocket subscribers: every asynchronous notification the deck makes is
+// published as-is under /notifications/{name}, and as new values of the REST properties it changes
+type eventHub struct {
+	sync.Mutex
+	properties  map[string]func() interface{} // REST properties
+	propertyMu  *sync.Mutex                   // held while reading REST properties, so commands can't change them
+	subscribers map[*websocket.Conn]*eventSubscriber
+	published   chan string
+}
+
+// eventSubscriber is someone connected to the event WebSocket. Messages to them are queued and written by a goroutine
+// of their own, so someone slow to read can't hold up everyone else.
+type eventSubscriber struct {
+	conn       *websocket.Conn
+	properties map[string]bool // what they're subscribed to; guarded by the hub's lock
+
+	lock  sync.Mutex
+	queue []queuedEvent
+	slow  bool          // has the queue overflowed?
+	ready chan struct{} // signalled when something's queued
+}
+
+// queuedEvent is a message waiting to be written to a subscriber
+type queuedEvent struct {
+	property string // what it's about; empty for responses
+	msg      string
+}
+
+func newEventSubscriber(conn *websocket.Conn) *eventSubscriber {
+	return &eventSubscriber{
+		conn:       conn,
+		properties: make(map[string]bool),
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// enqueue queues msg to be written. If coalesce is set, any message about the same property that's still waiting is
+// dropped in favour of it. It never blocks: if too much is waiting, the subscriber is disconnected instead.
+func (s *eventSubscriber) enqueue(property string, msg string, coalesce bool) {
+	s.lock.Lock()
+	if coalesce {
+		for idx, event := range s.queue {
+			if event.property == property {
+				s.queue = append(s.queue[:idx], s.queue[idx+1:]...)
+				break
+			}
+		}
+	}
+	if len(s.queue) < maxQueuedEvents {
+		s.queue = append(s.queue, queuedEvent{property: property, msg: msg})
+	} else {
+		s.slow = true
+	}
+	s.lock.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// write writes what's queued until done is closed, disconnecting the subscriber if a write fails or takes too long,
+// or if they've fallen too far behind
+func (s *eventSubscriber) write(done <-chan struct{}) {
+	for {
+		select {
+		case <-s.ready:
+		case <-done:
+			return
+		}
+		s.lock.Lock()
+		queue, slow := s.queue, s.slow
+		s.queue = nil
+		s.lock.Unlock()
+		if slow {
+			log.Warn().Msgf("event subscriber %v isn't keeping up; disconnecting", s.conn.RemoteAddr())
+			s.conn.Close()
+			return
+		}
+
+		for _, event := range queue {
+			s.conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			err := s.conn.WriteMessage(event.msg)
+			if err != nil {
+				log.Warn().Err(err).Msgf("error sending event to %v; disconnecting", s.conn.RemoteAddr())
+				s.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func newEventHub(properties map[string]func() interface{}, propertyMu *sync.Mutex) *eventHub {
+	h := &eventHub{
+		properties:  properties,
+		propertyMu:  propertyMu,
+		subscribers: make(map[*websocket.Conn]*eventSubscriber),
+		published:   make(chan string, 64),
+	}
+	go h.run()
+	return h
+}
+
+// Publish queues a notification to be published. It never blocks: if the hub can't keep up, it's dropped.
+func (h *eventHub) Publish(msg string) {
+	select {
+	case h.published <- msg:
+	default:
+		log.Warn().Msg("events aren't being published fast enough; dropping event")
+	}
+}
+
+// run publishes notifications as they're queued. The REST properties they change are only read once for everything
+// queued by then, so a burst of notifications, like position updates while the hub waits for the command lock,
+// doesn't read the same property over and over.
+func (h *eventHub) run() {
+	for msg := range h.published {
+		changed := h.publish(msg, nil)
+		for more := true; more; {
+			select {
+			case msg, ok := <-h.published:
+				if ok {
+					changed = h.publish(msg, changed)
+				}
+				more = ok
+			default:
+				more = false
+			}
+		}
+		for _, property := range changed {
+			h.send(property, nil)
+		}
+	}
+}
+
+// publish sends a notification to its subscribers, returning changed with the REST properties it changes added
+func (h *eventHub) publish(msg string, changed []string) []string {
+	code, name, fields := parseNotification(msg)
+	h.send("/notifications/"+name, fields)
+next:
+	for _, property := range notificationProperties[code] {
+		for _, existing := range changed {
+			if existing == property {
+				continue next
+			}
+		}
+		changed = append(changed, property)
+	}
+	return changed
+}
+
+// send queues a property's value for everyone subscribed to it; REST properties are read if value is nil
+func (h *eventHub) send(property string, value interface{}) {
+	h.Lock()
+	subscribers := make([]*eventSubscriber, 0)
+	for _, s := range h.subscribers {
+		if s.properties[property] {
+			subscribers = append(subscribers, s)
+		}
+	}
+	h.Unlock()
+	if len(subscribers) == 0 {
+		return
+	}
+
+	if value == nil {
+		value = h.value(property)
+	}
+	msg, err := json.Marshal(eventMessage{
+		Type: "event",
+		Data: eventData{Action: "propertyValueChanged", Property: property, Value: value},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("error marshalling event")
+		return
+	}
+	_, rest := h.properties[property]
+	for _, s := range subscribers {
+		s.enqueue(property, string(msg), rest || coalescedNotifications[property])
+	}
+}
+
+// value reads a REST property
+func (h *eventHub) value(property string) interface{} {
+	h.propertyMu.Lock()
+	defer h.propertyMu.Unlock()
+	return h.properties[property]()
+}
+
+// parseNotification splits a notification like "508 transport info:\r\nstatus: play\r\n" into its code, its name
+// and its fields
+func parseNotification(msg string) (code string, name string, fields map[string]string) {
+	lines := strings.Split(strings.TrimRight(msg, "\r\n"), "\r\n")
+	first := strings.SplitN(strings.TrimSuffix(lines[0], ":"), " ", 2)
+	code = first[0]
+	if len(first) == 2 {
+		name = first[1]
+	}
+	fields = make(map[string]string)
+	for _, line := range lines[1:] {
+		kv := strings.SplitN(line, ": ", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	return code, name, fields
+}
+
+// propertyNames lists everything that can be subscribed to
+func (h *eventHub) propertyNames() []string {
+	names := make([]string, 0)
+	for property := range h.properties {
+		names = append(names, property)
+	}
+	for _, name := range notifications {
+		names = append(names, "/notifications/"+name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (h *eventHub) known(property string) bool {
+	if _, ok := h.properties[property]; ok {
+		return true
+	}
+	for _, name := range notifications {
+		if property == "/notifications/"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP upgrades to a WebSocket and handles the subscriber's requests until it goes away
+func (h *eventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		log.Warn().Err(err).Msg("error upgrading event websocket")
+		return
+	}
+	log.Info().Msgf("event subscriber connected: %v", conn.RemoteAddr())
+	s := newEventSubscriber(conn)
+	h.Lock()
+	h.subscribers[conn] = s
+	h.Unlock()
+	done := make(chan struct{})
+	go s.write(done)
+	defer func() {
+		h.Lock()
+		delete(h.subscribers, conn)
+		h.Unlock()
+		close(done)
+		conn.Close()
+		log.Info().Msgf("event subscriber disconnected: %v", conn.RemoteAddr())
+	}()
+
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req eventMessage
+		err = json.Unmarshal([]byte(msg), &req)
+		if err != nil || req.Type != "request" {
+			h.reply(s, eventData{Action: req.Data.Action, Error: "expected a JSON request"})
+			continue
+		}
+		h.reply(s, h.handle(s, req.Data))
+	}
+}
+
+func (h *eventHub) handle(s *eventSubscriber, req eventData) eventData {
+	res := eventData{Action: req.Action, Properties: req.Properties}
+	switch req.Action {
+	case "listProperties":
+		res.Properties = h.propertyNames()
+		return res
+	case "subscribe", "unsubscribe":
+	default:
+		res.Error = "unknown action: " + req.Action
+		return res
+	}
+
+	for _, property := range req.Properties {
+		if !h.known(property) {
+			res.Error = "unknown property: " + property
+			return res
+		}
+	}
+
+	h.Lock()
+	for _, property := range req.Properties {
+		s.properties[property] = req.Action == "subscribe"
+	}
+	h.Unlock()
+
+	if req.Action == "subscribe" {
+		// start subscribers off with the current values of REST properties; notifications only have values as they happen
+		res.Values = make(map[string]interface{})
+		for _, property := range req.Properties {
+			if _, ok := h.properties[property]; ok {
+				res.Values[property] = h.value(property)
+			}
+		}
+	}
+	return res
+}
+
+// reply queues a response behind any events already queued for the subscriber
+func (h *eventHub) reply(s *eventSubscriber, res eventData) {
+	success := res.Error == ""
+	res.Success = &success
+	msg, err := json.Marshal(eventMessage{Type: "response", Data: res})
+	if err != nil {
+		log.Error().Err(err).Msg("error marshalling event response")
+		return
+	}
+	s.enqueue("", string(msg), false)
+}
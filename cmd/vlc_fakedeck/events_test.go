@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/josh23french/fakedeck/pkg/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventSubscriberQueue(t *testing.T) {
+	s := newEventSubscriber(nil)
+	s.enqueue("/transports/0/playback", "stopped", true)
+	s.enqueue("/notifications/transport info", "transport info", false)
+	s.enqueue("/transports/0/playback", "playing", true)
+	s.enqueue("", "response", false)
+	assert.Equal(t, []queuedEvent{
+		{property: "/notifications/transport info", msg: "transport info"},
+		{property: "/transports/0/playback", msg: "playing"},
+		{property: "", msg: "response"},
+	}, s.queue, "should only keep the latest value of coalesced properties, after what came before it")
+	assert.False(t, s.slow)
+
+	for i := len(s.queue); i < maxQueuedEvents; i++ {
+		s.enqueue("/notifications/slot info", fmt.Sprint(i), false)
+	}
+	assert.False(t, s.slow, "should queue up to maxQueuedEvents")
+	s.enqueue("/notifications/slot info", "one too many", false)
+	assert.True(t, s.slow, "should give up on subscribers that fall too far behind")
+	assert.Len(t, s.queue, maxQueuedEvents)
+}
+
+func TestEventHubBatchesPropertyReads(t *testing.T) {
+	reads := 0
+	h := &eventHub{
+		properties: map[string]func() interface{}{
+			"/transports/0/playback": func() interface{} {
+				reads++
+				return reads
+			},
+		},
+		propertyMu:  &sync.Mutex{},
+		subscribers: make(map[*websocket.Conn]*eventSubscriber),
+		published:   make(chan string, 64),
+	}
+	s := newEventSubscriber(nil)
+	s.properties["/transports/0/playback"] = true
+	s.properties["/notifications/timeline position"] = true
+	h.subscribers[nil] = s
+
+	for i := 1; i <= 3; i++ {
+		h.Publish(fmt.Sprintf("514 timeline position:\r\ntimeline: %v\r\n", i))
+	}
+	close(h.published)
+	h.run()
+
+	assert.Equal(t, 1, reads, "should read the property once for everything published by then")
+	if assert.Len(t, s.queue, 2) {
+		assert.True(t, strings.Contains(s.queue[0].msg, `"timeline":"3"`), "should only keep the latest position")
+		assert.Equal(t, "/transports/0/playback", s.queue[1].property)
+	}
+}
@@ -0,0 +1,133 @@
+//go:build !nogtk
+// +build !nogtk
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gotk3/gotk3/cairo"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+const (
+	identifyBorder = 24                     // width of the flashing border in pixels
+	identifyFlash  = 500 * time.Millisecond // how long the border stays on (and off) each flash
+)
+
+// identifyOverlay flashes a border and the deck's name and addresses over the video, so someone looking at a wall
+// of fakedecks can tell which one they're controlling. Everything has to happen on the GTK main thread.
+type identifyOverlay struct {
+	widgets    []*gtk.Widget
+	until      time.Time // when to stop flashing
+	generation int       // bumped every time flashing starts or stops, so old timeouts know to give up
+}
+
+// newIdentifyOverlay adds the (hidden) identify widgets to overlay
+func newIdentifyOverlay(overlay *gtk.Overlay, text string) (*identifyOverlay, error) {
+	i := &identifyOverlay{
+		widgets: make([]*gtk.Widget, 0),
+	}
+
+	// One bar per edge; each overlay child gets its own window above the video, so they can't cover it all
+	edges := []struct {
+		halign, valign gtk.Align
+		width, height  int
+	}{
+		{gtk.ALIGN_FILL, gtk.ALIGN_START, -1, identifyBorder},
+		{gtk.ALIGN_FILL, gtk.ALIGN_END, -1, identifyBorder},
+		{gtk.ALIGN_START, gtk.ALIGN_FILL, identifyBorder, -1},
+		{gtk.ALIGN_END, gtk.ALIGN_FILL, identifyBorder, -1},
+	}
+	for _, edge := range edges {
+		bar, err := gtk.DrawingAreaNew()
+		if err != nil {
+			return nil, fmt.Errorf("error creating identify border: %w", err)
+		}
+		bar.SetHAlign(edge.halign)
+		bar.SetVAlign(edge.valign)
+		bar.SetSizeRequest(edge.width, edge.height)
+		bar.Connect("draw", func(da *gtk.DrawingArea, cr *cairo.Context) bool {
+			cr.SetSourceRGB(1, 0, 0)
+			cr.Paint()
+			return true
+		})
+		overlay.AddOverlay(bar)
+		i.widgets = append(i.widgets, bar.ToWidget())
+	}
+
+	label, err := gtk.LabelNew("")
+	if err != nil {
+		return nil, fmt.Errorf("error creating identify label: %w", err)
+	}
+	label.SetMarkup(fmt.Sprintf(`<span background="red" foreground="white" size="xx-large" weight="bold"> %v </span>`, html.EscapeString(text)))
+	label.SetHAlign(gtk.ALIGN_CENTER)
+	label.SetVAlign(gtk.ALIGN_CENTER)
+	overlay.AddOverlay(label)
+	i.widgets = append(i.widgets, label.ToWidget())
+
+	for _, widget := range i.widgets {
+		widget.SetNoShowAll(true) // stay hidden when the window is shown
+	}
+	return i, nil
+}
+
+// Start flashes for duration, or extends the flashing if it's already going
+func (i *identifyOverlay) Start(duration time.Duration) {
+	glib.IdleAdd(func() {
+		i.until = time.Now().Add(duration)
+		i.generation++
+		generation := i.generation
+		on := true
+		i.show(on)
+		glib.TimeoutAdd(uint(identifyFlash/time.Millisecond), func() bool {
+			if generation != i.generation {
+				return false // superseded by a newer Start or Stop
+			}
+			if time.Now().After(i.until) {
+				i.show(false)
+				return false
+			}
+			on = !on
+			i.show(on)
+			return true
+		})
+	})
+}
+
+// Stop stops flashing right away
+func (i *identifyOverlay) Stop() {
+	glib.IdleAdd(func() {
+		i.generation++
+		i.show(false)
+	})
+}
+
+func (i *identifyOverlay) show(visible bool) {
+	for _, widget := range i.widgets {
+		widget.SetVisible(visible)
+	}
+}
+
+// identifyText is what's shown while identifying: the deck's name and the addresses it can be reached at
+func identifyText(name string) string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return name
+	}
+	ips := make([]string, 0)
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP.String())
+		}
+	}
+	if len(ips) == 0 {
+		return name
+	}
+	return name + " — " + strings.Join(ips, ", ")
+}
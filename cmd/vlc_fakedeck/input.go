@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/rs/zerolog/log"
+)
+
+// InputSource is the simulated video input shown while the deck is in preview
+type InputSource struct {
+	Name   string // what it was configured as
+	Format string // video format reported as the input video format
+	media  *Media
+}
+
+// NewInputSource creates an InputSource from "bars", a path to a file, or a URL like v4l2:///dev/video0.
+// format overrides the reported video format; if it's empty, the format is detected where possible.
+func NewInputSource(source string, format string) (*InputSource, error) {
+	var media *Media
+	var err error
+
+	switch {
+	case source == "bars":
+		media, err = colorBarsMedia()
+		if err != nil {
+			return nil, fmt.Errorf("error creating input media: %w", err)
+		}
+		if format == "" {
+			format = deck.VideoFormat720p5994 // generated to match the output
+		}
+	case strings.Contains(source, "://"):
+		media = &Media{Location: source}
+	default:
+		if _, err := os.Stat(source); err != nil {
+			return nil, fmt.Errorf("error finding input file: %w", err)
+		}
+		media = &Media{Location: source, Repeat: true} // keep the "signal" up
+	}
+
+	if format == "" {
+		format = detectVideoFormat(source)
+	}
+
+	return &InputSource{
+		Name:   source,
+		Format: format,
+		media:  media,
+	}, nil
+}
+
+func (i *InputSource) Media() *Media {
+	return i.media
+}
+
+// colorBarsMedia generates 75% color bars as a still image
+func colorBarsMedia() (*Media, error) {
+	bars := []color.RGBA{
+		{191, 191, 191, 255}, // white
+		{191, 191, 0, 255},   // yellow
+		{0, 191, 191, 255},   // cyan
+		{0, 191, 0, 255},     // green
+		{191, 0, 191, 255},   // magenta
+		{191, 0, 0, 255},     // red
+		{0, 0, 191, 255},     // blue
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 1280, 720))
+	for x := 0; x < 1280; x++ {
+		c := bars[x*len(bars)/1280]
+		for y := 0; y < 720; y++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	return ImageMedia(img)
+}
+
+// detectVideoFormat asks ffprobe about the source's first video stream and works out the closest video format, or
+// "none"
+func detectVideoFormat(source string) string {
+	args := []string{"-v", "error", "-select_streams", "v:0", "-show_entries", "stream=height,r_frame_rate",
+		"-of", "default=noprint_wrappers=1"}
+	if strings.HasPrefix(source, "v4l2://") {
+		args = append(args, "-f", "v4l2", strings.TrimPrefix(source, "v4l2://")) // ffprobe has no v4l2 URLs
+	} else {
+		args = append(args, source)
+	}
+	out, err := exec.Command("ffprobe", args...).Output()
+	if err != nil {
+		log.Warn().Err(err).Msg("error probing input media to detect its format")
+		return "none"
+	}
+
+	var height uint64
+	var fps float64
+	for _, line := range strings.Split(string(out), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "height":
+			height, _ = strconv.ParseUint(kv[1], 10, 32)
+		case "r_frame_rate": // a fraction, like 60000/1001
+			rate := strings.SplitN(kv[1], "/", 2)
+			num, _ := strconv.ParseFloat(rate[0], 64)
+			den := 1.0
+			if len(rate) == 2 {
+				den, _ = strconv.ParseFloat(rate[1], 64)
+			}
+			if den != 0 {
+				fps = num / den
+			}
+		}
+	}
+	if height == 0 || fps == 0 {
+		return "none"
+	}
+	return videoFormat(uint(height), fps)
+}
+
+// videoFormat maps a frame height and rate onto one of the deck's video formats, assuming progressive video
+func videoFormat(height uint, fps float64) string {
+	rates := []struct {
+		fps    float64
+		suffix string
+	}{
+		{23.976, "23976"}, {24, "24"}, {25, "25"}, {29.97, "2997"}, {30, "30"}, {50, "50"}, {59.94, "5994"}, {60, "60"},
+	}
+	suffix := ""
+	for _, rate := range rates {
+		if fps > rate.fps-0.01 && fps < rate.fps+0.01 {
+			suffix = rate.suffix
+			break
+		}
+	}
+
+	var format string
+	switch {
+	case height <= 486:
+		return deck.VideoFormatNTSCp
+	case height <= 576:
+		return deck.VideoFormatPALp
+	case height <= 720:
+		format = "720p" + suffix
+	case height <= 1080:
+		format = "1080p" + suffix
+	default:
+		format = "4Kp" + suffix
+	}
+
+	switch format {
+	case deck.VideoFormat720p50, deck.VideoFormat720p5994, deck.VideoFormat720p60,
+		deck.VideoFormat1080p23976, deck.VideoFormat1080p24, deck.VideoFormat1080p25, deck.VideoFormat1080p2997, deck.VideoFormat1080p30,
+		deck.VideoFormat4Kp23976, deck.VideoFormat4Kp24, deck.VideoFormat4Kp25, deck.VideoFormat4Kp2997, deck.VideoFormat4Kp30:
+		return format
+	}
+	return "none"
+}
@@ -1,6 +1,6 @@
 package main
 
 func main() {
-	d := VLCDeckNew()
+	d := VLCDeckNew(ConfigFromFlags())
 	d.PowerOn()
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/mpv"
+	"github.com/rs/zerolog/log"
+)
+
+// mpvStartTimeout is how long mpv gets to start listening on its IPC socket
+const mpvStartTimeout = 5 * time.Second
+
+// eofObserver is the ID mpvEngine observes eof-reached with
+const eofObserver = 1
+
+// mpvEngine plays media with an mpv process it runs and controls over mpv's IPC socket. Unlike libVLC, mpv can step
+// and play backwards, so it's a ReversibleEngine.
+type mpvEngine struct {
+	sync.Mutex
+	cmd      *exec.Cmd
+	client   *mpv.Client
+	dir      string     // holds the IPC socket and images written for mpv to load
+	loaded   chan error // the result of the last loadfile, once mpv has loaded it or given up
+	hasMedia bool       // has anything been loaded yet?
+	backward bool       // is play-dir backward?
+
+	// watchEvents needs these while Load holds the lock waiting on it, so they have their own
+	endLock sync.Mutex
+	media   *Media // what's loaded
+	onEnd   []func(media *Media)
+}
+
+// mpvOutputOptions are the mpv options for the output named by config.Output. The window output doesn't need any, as
+// it sets wid once its window is up.
+func mpvOutputOptions(config Config) []string {
+	switch config.Output {
+	case "headless":
+		return []string{"--vo=null"}
+	case "frames":
+		options := []string{"--vo=image", "--vo-image-format=png", "--vo-image-outdir=" + config.FramesPath}
+		if config.FramesRatio > 1 {
+			options = append(options, fmt.Sprintf("--vf=lavfi=[framestep=%v]", config.FramesRatio))
+		}
+		return options
+	}
+	return nil
+}
+
+func newMPVEngine(path string, options []string) (*mpvEngine, error) {
+	dir, err := ioutil.TempDir("", "fakedeck-mpv")
+	if err != nil {
+		return nil, fmt.Errorf("error creating mpv directory: %w", err)
+	}
+	socket := filepath.Join(dir, "mpv.sock")
+
+	args := append([]string{
+		"--idle=yes",
+		"--input-ipc-server=" + socket,
+		"--no-config",
+		"--no-terminal",
+		"--pause",
+		"--keep-open=yes", // stay on the last frame at the end, with eof-reached set, rather than unloading
+		"--hr-seek=yes",   // seek to exact frames
+		"--image-display-duration=inf",
+	}, options...)
+	cmd := exec.Command(path, args...)
+	err = cmd.Start()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("error starting mpv: %w", err)
+	}
+	e := &mpvEngine{
+		cmd:    cmd,
+		dir:    dir,
+		loaded: make(chan error, 1),
+	}
+
+	// mpv takes a moment to start listening
+	deadline := time.Now().Add(mpvStartTimeout)
+	for {
+		e.client, err = mpv.Dial(socket)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			e.Close()
+			return nil, fmt.Errorf("error connecting to mpv: %w", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	err = e.watch()
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// watch starts hearing about mpv's events, once the client's connected to it
+func (e *mpvEngine) watch() error {
+	go e.watchEvents()
+	err := e.client.ObserveProperty(eofObserver, "eof-reached")
+	if err != nil {
+		return fmt.Errorf("error observing mpv eof-reached: %w", err)
+	}
+	return nil
+}
+
+// watchEvents hears about files loading and reaching their ends until mpv goes away
+func (e *mpvEngine) watchEvents() {
+	for event := range e.client.Events() {
+		switch event.Event {
+		case "file-loaded":
+			e.loadResult(nil)
+		case "end-file":
+			if event.Reason == "error" {
+				e.loadResult(errors.New("mpv couldn't load the file"))
+			}
+		case "property-change":
+			var eof bool
+			if event.ID != eofObserver || json.Unmarshal(event.Data, &eof) != nil || !eof {
+				continue
+			}
+			e.endLock.Lock()
+			media, onEnd := e.media, e.onEnd
+			e.endLock.Unlock()
+			for _, fn := range onEnd {
+				go fn(media)
+			}
+		}
+	}
+	log.Debug().Msg("mpv connection closed")
+}
+
+// loadResult lets Load know how loading went, unless nothing's waiting to hear
+func (e *mpvEngine) loadResult(err error) {
+	select {
+	case e.loaded <- err:
+	default:
+	}
+}
+
+// location returns what mpv should load for media, writing images into dir so mpv can open them
+func (e *mpvEngine) location(media *Media) (string, error) {
+	if media.Image == nil {
+		return media.Location, nil
+	}
+	path := filepath.Join(e.dir, fmt.Sprintf("%x.png", sha1.Sum(media.Image)))
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	err := ioutil.WriteFile(path, media.Image, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error writing image for mpv: %w", err)
+	}
+	return path, nil
+}
+
+func (e *mpvEngine) Load(media *Media) error {
+	e.Lock()
+	defer e.Unlock()
+	location, err := e.location(media)
+	if err != nil {
+		return err
+	}
+	err = e.client.SetProperty("pause", true)
+	if err != nil {
+		return err
+	}
+	loop := "no"
+	if media.Repeat {
+		loop = "inf"
+	}
+	err = e.client.SetProperty("loop-file", loop)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-e.loaded: // forget about anything that loaded before
+	default:
+	}
+	_, err = e.client.Command("loadfile", location, "replace")
+	if err != nil {
+		return fmt.Errorf("error loading %v: %w", location, err)
+	}
+	select {
+	case err = <-e.loaded:
+		if err != nil {
+			return fmt.Errorf("error loading %v: %w", location, err)
+		}
+	case <-time.After(mpv.Timeout):
+		return fmt.Errorf("error loading %v: %w", location, mpv.ErrTimeout)
+	}
+	e.hasMedia = true
+	e.endLock.Lock()
+	e.media = media
+	e.endLock.Unlock()
+	return nil
+}
+
+func (e *mpvEngine) Play() error {
+	return e.client.SetProperty("pause", false)
+}
+
+func (e *mpvEngine) Pause() error {
+	return e.client.SetProperty("pause", true)
+}
+
+func (e *mpvEngine) Seek(pos time.Duration) error {
+	_, err := e.client.Command("seek", pos.Seconds(), "absolute+exact")
+	return err
+}
+
+// SetRate changes how fast it plays, playing backwards if rate is negative
+func (e *mpvEngine) SetRate(rate float64) error {
+	e.Lock()
+	defer e.Unlock()
+	backward := rate < 0
+	if backward != e.backward {
+		dir := "forward"
+		if backward {
+			dir = "backward"
+		}
+		err := e.client.SetProperty("play-dir", dir)
+		if err != nil {
+			return fmt.Errorf("error changing direction: %w", err)
+		}
+		e.backward = backward
+	}
+	if backward {
+		rate = -rate
+	}
+	return e.client.SetProperty("speed", rate)
+}
+
+func (e *mpvEngine) Position() (time.Duration, error) {
+	var seconds float64
+	err := e.client.GetProperty("time-pos", &seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (e *mpvEngine) SetAudioChannels(channels int) error {
+	return e.client.SetProperty("audio-channels", fmt.Sprint(channels))
+}
+
+func (e *mpvEngine) OnEnd(fn func(media *Media)) {
+	e.endLock.Lock()
+	defer e.endLock.Unlock()
+	e.onEnd = append(e.onEnd, fn)
+}
+
+// StepFrame shows the next or previous frame, leaving it paused
+func (e *mpvEngine) StepFrame(forward bool) error {
+	command := "frame-back-step"
+	if forward {
+		command = "frame-step"
+	}
+	_, err := e.client.Command(command)
+	return err
+}
+
+// SetXWindow plays into an X window, for the window output. mpv only picks up wid when it creates its video output,
+// so if something's already playing the video is turned off and on again to make it.
+func (e *mpvEngine) SetXWindow(id uint32) error {
+	e.Lock()
+	defer e.Unlock()
+	err := e.client.SetProperty("wid", id)
+	if err != nil || !e.hasMedia {
+		return err
+	}
+	err = e.client.SetProperty("vid", "no")
+	if err != nil {
+		return err
+	}
+	return e.client.SetProperty("vid", "auto")
+}
+
+// Close quits mpv and cleans up after it
+func (e *mpvEngine) Close() error {
+	if e.client != nil {
+		e.client.Command("quit")
+		e.client.Close()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- e.cmd.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(mpv.Timeout):
+		log.Warn().Msg("mpv didn't quit; killing it")
+		e.cmd.Process.Kill()
+		<-done
+	}
+	return os.RemoveAll(e.dir)
+}
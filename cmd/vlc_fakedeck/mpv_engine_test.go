@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/josh23french/fakedeck/pkg/mpv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trimmer.io/go-timecode/timecode"
+)
+
+// fakeMPV answers an mpvEngine on the other end of a pipe like mpv would, keeping track of what it's been told to do
+type fakeMPV struct {
+	sync.Mutex
+	conn   net.Conn
+	loaded string    // the file last loaded
+	paused bool      // is pause set?
+	pos    float64   // time-pos, which only moves when it's seeked
+	seeks  []float64 // every position it's been seeked to
+}
+
+// newTestMPVEngine makes an mpvEngine that talks to a fakeMPV instead of running mpv
+func newTestMPVEngine(t *testing.T) (*mpvEngine, *fakeMPV) {
+	client, server := net.Pipe()
+	f := &fakeMPV{conn: server, paused: true}
+	go f.serve()
+	e := &mpvEngine{
+		client: mpv.NewClient(client),
+		loaded: make(chan error, 1),
+	}
+	t.Cleanup(func() {
+		e.client.Close()
+		server.Close()
+	})
+	require.NoError(t, e.watch())
+	return e, f
+}
+
+func (f *fakeMPV) serve() {
+	scanner := bufio.NewScanner(f.conn)
+	for scanner.Scan() {
+		var req struct {
+			Command   []interface{} `json:"command"`
+			RequestID int           `json:"request_id"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &req) != nil {
+			return
+		}
+		var data interface{}
+		f.Lock()
+		switch req.Command[0] {
+		case "loadfile":
+			f.loaded = req.Command[1].(string)
+			f.pos = 0
+		case "seek":
+			f.pos = req.Command[1].(float64)
+			f.seeks = append(f.seeks, f.pos)
+		case "set_property":
+			if req.Command[1] == "pause" {
+				f.paused = req.Command[2].(bool)
+			}
+		case "get_property":
+			data = f.pos
+		}
+		f.Unlock()
+		f.send(map[string]interface{}{"request_id": req.RequestID, "error": "success", "data": data})
+		if req.Command[0] == "loadfile" {
+			f.send(map[string]interface{}{"event": "file-loaded"})
+		}
+	}
+}
+
+func (f *fakeMPV) send(msg interface{}) {
+	line, _ := json.Marshal(msg)
+	f.conn.Write(append(line, '\n'))
+}
+
+// state is what the fake's been told to play, and whether it's paused
+func (f *fakeMPV) state() (string, bool) {
+	f.Lock()
+	defer f.Unlock()
+	return f.loaded, f.paused
+}
+
+func TestTimelineWithMPV(t *testing.T) {
+	e, f := newTestMPVEngine(t)
+	clock := deck.NewFakeClock(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	tl := NewTimelinePlayer(e, timecode.Rate60DF, clock)
+	tl.server = deck.NewServer(nil)
+	require.NoError(t, tl.AddClip(testClip("1.mov", 10*time.Second)))
+	require.NoError(t, tl.AddClip(testClip("2.mov", 10*time.Second)))
+
+	require.NoError(t, tl.Play())
+	loaded, paused := f.state()
+	assert.Equal(t, "1.mov", loaded, "should load the first clip into mpv")
+	assert.False(t, paused, "should unpause mpv")
+
+	clock.Advance(time.Second)
+	f.Lock()
+	assert.NotEmpty(t, f.seeks, "should seek mpv back into step when it falls behind the transport")
+	f.Unlock()
+
+	// mpv gets to the end of the clip before the transport does
+	f.send(map[string]interface{}{"event": "property-change", "id": eofObserver, "name": "eof-reached", "data": true})
+	require.Eventually(t, func() bool {
+		tl.lock.Lock()
+		defer tl.lock.Unlock()
+		return tl.transportPosition() > 9*time.Second
+	}, time.Second, time.Millisecond, "should jump the transport to the end when it hears mpv reach it")
+	clock.Advance(20 * time.Millisecond)
+	assert.Equal(t, uint(2), tl.clipID, "should move on to the next clip")
+	assert.Equal(t, "play", tl.TransportStatus())
+	loaded, paused = f.state()
+	assert.Equal(t, "2.mov", loaded, "should load the next clip into mpv")
+	assert.False(t, paused, "should carry on playing")
+
+	require.NoError(t, tl.Stop())
+	_, paused = f.state()
+	assert.True(t, paused, "should pause mpv")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errCantIdentify is returned by outputs with nothing to flash an identify overlay on
+var errCantIdentify = errors.New("output can't identify the deck")
+
+// Output is where the deck's picture goes
+type Output interface {
+	// Options are the libVLC options the output needs, for vlc.Init
+	Options() []string
+	// Run shows the engine's picture until Stop, calling powerOff if the output is closed some other way, like its
+	// window being closed. It blocks, so it's the last thing the deck does when it powers on.
+	Run(engine PlaybackEngine, powerOff func())
+	Stop()
+	// Identify flashes the deck's name over the picture for duration; a duration of 0 stops flashing
+	Identify(duration time.Duration) error
+}
+
+// NewOutput creates the output named by config.Output
+func NewOutput(config Config) (Output, error) {
+	switch config.Output {
+	case "window":
+		return newWindowOutput(config.Name)
+	case "headless":
+		return newHeadlessOutput(), nil
+	case "frames":
+		return newFramesOutput(config.FramesPath, config.FramesRatio)
+	}
+	return nil, fmt.Errorf(`unknown output %q; must be "window", "headless" or "frames"`, config.Output)
+}
+
+// headlessOutput plays into libVLC's null video output, so the deck runs without a display, like on a server or in
+// CI. It runs until it's stopped or the process is interrupted.
+type headlessOutput struct {
+	done chan interface{}
+	once sync.Once
+}
+
+func newHeadlessOutput() *headlessOutput {
+	return &headlessOutput{
+		done: make(chan interface{}),
+	}
+}
+
+func (o *headlessOutput) Options() []string {
+	return []string{"--no-xlib", "--vout=dummy"}
+}
+
+func (o *headlessOutput) Run(engine PlaybackEngine, powerOff func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case <-signals:
+		powerOff()
+	case <-o.done:
+	}
+}
+
+func (o *headlessOutput) Stop() {
+	o.once.Do(func() {
+		close(o.done)
+	})
+}
+
+func (o *headlessOutput) Identify(duration time.Duration) error {
+	return errCantIdentify
+}
+
+// framesOutput runs headless, writing every ratio-th frame into a directory as a PNG, so something else can check
+// what the deck is showing
+type framesOutput struct {
+	*headlessOutput
+	path  string
+	ratio int
+}
+
+func newFramesOutput(path string, ratio int) (Output, error) {
+	if path == "" {
+		return nil, errors.New("the frames output needs a directory to write frames into")
+	}
+	if ratio < 1 {
+		return nil, fmt.Errorf("frame ratio %v must be at least 1", ratio)
+	}
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating frames directory: %w", err)
+	}
+	return &framesOutput{
+		headlessOutput: newHeadlessOutput(),
+		path:           path,
+		ratio:          ratio,
+	}, nil
+}
+
+func (o *framesOutput) Options() []string {
+	return append(o.headlessOutput.Options(),
+		"--video-filter=scene",
+		"--scene-path="+o.path,
+		"--scene-prefix=frame",
+		"--scene-format=png",
+		fmt.Sprintf("--scene-ratio=%v", o.ratio),
+	)
+}
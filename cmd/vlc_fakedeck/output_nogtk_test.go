@@ -0,0 +1,20 @@
+//go:build nogtk
+// +build nogtk
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowOutputWithoutGTK(t *testing.T) {
+	_, err := NewOutput(Config{Output: "window"})
+	assert.EqualError(t, err, `built without GTK; use the "headless" or "frames" output`)
+
+	output, err := NewOutput(Config{Name: "test", Output: "headless"})
+	require.NoError(t, err, "should still have the headless output")
+	assertRunsUntilStopped(t, output)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertRunsUntilStopped runs output like the deck does when it powers on, checking Stop makes it return
+func assertRunsUntilStopped(t *testing.T, output Output) {
+	done := make(chan interface{})
+	go func() {
+		output.Run(nil, func() {
+			t.Error("shouldn't power off when it's stopped")
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("should run until it's stopped")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	output.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("should return once it's stopped")
+	}
+	output.Stop() // the deck stops it again when it powers off
+}
+
+func TestHeadlessOutput(t *testing.T) {
+	output, err := NewOutput(Config{Output: "headless"})
+	require.NoError(t, err)
+	assert.Contains(t, output.Options(), "--vout=dummy", "shouldn't need a display")
+	assert.Equal(t, errCantIdentify, output.Identify(time.Second))
+	assertRunsUntilStopped(t, output)
+}
+
+func TestFramesOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakedeck-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "frames")
+
+	_, err = NewOutput(Config{Output: "frames", FramesPath: path})
+	assert.Error(t, err, "should need a frame ratio")
+	_, err = NewOutput(Config{Output: "frames", FramesRatio: 1})
+	assert.Error(t, err, "should need a directory")
+
+	output, err := NewOutput(Config{Output: "frames", FramesPath: path, FramesRatio: 2})
+	require.NoError(t, err)
+	_, err = os.Stat(path)
+	assert.NoError(t, err, "should create the directory")
+	assert.Contains(t, output.Options(), "--scene-path="+path)
+	assert.Contains(t, output.Options(), "--scene-ratio=2")
+	assertRunsUntilStopped(t, output)
+}
+
+func TestUnknownOutput(t *testing.T) {
+	_, err := NewOutput(Config{Output: "hologram"})
+	assert.Error(t, err)
+}
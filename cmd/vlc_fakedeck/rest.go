@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// restPrefix is where HyperDeck firmware serves its REST API
+const restPrefix = "/control/api/v1"
+
+// restHandler serves the REST API that newer HyperDeck firmware has. Reads come straight from the deck's state;
+// changes are made by running the equivalent protocol command through ProcessCommand, so they're validated and
+// notified exactly the same way as they would be over TCP.
+func (d *VLCDeck) restHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(restPrefix+"/system/product", d.restProduct)
+	mux.HandleFunc(restPrefix+"/transports/0", d.restTransport)
+	mux.HandleFunc(restPrefix+"/transports/0/stop", d.restStop)
+	mux.HandleFunc(restPrefix+"/transports/0/play", d.restPlay)
+	mux.HandleFunc(restPrefix+"/transports/0/playback", d.restPlayback)
+	mux.HandleFunc(restPrefix+"/transports/0/record", d.restRecord)
+	mux.HandleFunc(restPrefix+"/transports/0/timecode", d.restTimecode)
+	mux.HandleFunc(restPrefix+"/transports/0/clipIndex", d.restClipIndex)
+	mux.HandleFunc(restPrefix+"/media/workingset", d.restWorkingSet)
+	mux.HandleFunc(restPrefix+"/media/active", d.restActiveMedia)
+	mux.HandleFunc(restPrefix+"/timelines/0", d.restTimeline)
+	mux.HandleFunc(restPrefix+"/event/websocket", d.events.ServeHTTP)
+	return mux
+}
+
+// serveREST serves the REST API until the deck is powered off
+func (d *VLCDeck) serveREST() {
+	d.http = &http.Server{
+		Addr:    d.config.HTTPAddr,
+		Handler: d.restHandler(),
+	}
+	go func() {
+		err := d.http.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("error serving REST API")
+		}
+	}()
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Warn().Err(err).Msg("error writing REST response")
+	}
+}
+
+// readJSON decodes the request body into v, replying with an error if it can't
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// restCommand runs a protocol command for a REST request, and replies with how it went
+func (d *VLCDeck) restCommand(w http.ResponseWriter, name string, params map[string]string) {
+	if params == nil {
+		params = make(map[string]string)
+	}
+	res := d.ProcessCommand(&protocol.Command{Name: name, Parameters: params})
+	switch {
+	case res == protocol.ErrUnsupported:
+		http.Error(w, res, http.StatusNotImplemented)
+	case res == protocol.ErrRemoteControlDisabled:
+		http.Error(w, res, http.StatusForbidden)
+	case strings.HasPrefix(res, "1"):
+		http.Error(w, res, http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// restGet replies with a property's value, read while no command is changing it
+func (d *VLCDeck) restGet(w http.ResponseWriter, value func() interface{}) {
+	d.commandLock.Lock()
+	defer d.commandLock.Unlock()
+	writeJSON(w, value())
+}
+
+// restProperties are the REST API's properties that can be read, and so subscribed to over the event WebSocket
+func (d *VLCDeck) restProperties() map[string]func() interface{} {
+	return map[string]func() interface{}{
+		"/system/product":         d.restProductValue,
+		"/transports/0":           d.restTransportValue,
+		"/transports/0/playback":  d.restPlaybackValue,
+		"/transports/0/record":    d.restRecordValue,
+		"/transports/0/timecode":  d.restTimecodeValue,
+		"/transports/0/clipIndex": d.restClipIndexValue,
+		"/media/workingset":       d.restWorkingSetValue,
+		"/media/active":           d.restActiveMediaValue,
+		"/timelines/0":            d.restTimelineValue,
+	}
+}
+
+// allow replies 405 unless the request's method is one of methods
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func (d *VLCDeck) restProduct(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	d.restGet(w, d.restProductValue)
+}
+
+func (d *VLCDeck) restProductValue() interface{} {
+	return map[string]interface{}{
+		"deviceName":      d.config.Name,
+		"productName":     d.GetModel(),
+		"softwareVersion": d.GetProtocol(),
+	}
+}
+
+// transportMode is the REST name for what the transport is showing
+func (d *VLCDeck) transportMode() string {
+	switch {
+	case d.timeline.Recording():
+		return "InputRecord"
+	case d.timeline.Previewing():
+		return "InputPreview"
+	}
+	return "Output"
+}
+
+func (d *VLCDeck) restTransport(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		d.restGet(w, d.restTransportValue)
+		return
+	}
+
+	var body struct {
+		Mode string `json:"mode"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	switch body.Mode {
+	case "InputPreview":
+		d.restCommand(w, "preview", map[string]string{"enable": "true"})
+	case "Output":
+		d.restCommand(w, "preview", map[string]string{"enable": "false"})
+	default:
+		http.Error(w, "mode must be InputPreview or Output", http.StatusBadRequest)
+	}
+}
+
+func (d *VLCDeck) restTransportValue() interface{} {
+	return map[string]interface{}{
+		"mode": d.transportMode(),
+	}
+}
+
+func (d *VLCDeck) restStop(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPut) {
+		return
+	}
+	d.restCommand(w, "stop", nil)
+}
+
+func (d *VLCDeck) restPlay(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPut) {
+		return
+	}
+	d.restCommand(w, "play", nil)
+}
+
+func (d *VLCDeck) restPlayback(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		d.restGet(w, d.restPlaybackValue)
+		return
+	}
+
+	var body struct {
+		Type       string   `json:"type"`
+		Loop       *bool    `json:"loop"`
+		SingleClip *bool    `json:"singleClip"`
+		Speed      *float64 `json:"speed"`
+		Position   *int64   `json:"position"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.Position != nil {
+		// there's no protocol command to seek to a timeline frame, so this checks for remote control itself
+		d.commandLock.Lock()
+		if !d.remote.Allowed() {
+			d.commandLock.Unlock()
+			http.Error(w, protocol.ErrRemoteControlDisabled, http.StatusForbidden)
+			return
+		}
+		err := d.timeline.SeekFrame(*body.Position)
+		if err == nil {
+			d.timeline.sendAsyncTransportInfo()
+		}
+		d.commandLock.Unlock()
+		if err != nil {
+			http.Error(w, protocol.ErrOutOfRange, http.StatusBadRequest)
+			return
+		}
+	}
+	switch body.Type {
+	case "Stop":
+		d.restCommand(w, "stop", nil)
+		return
+	case "Play":
+	case "":
+		w.WriteHeader(http.StatusNoContent) // just moving
+		return
+	default:
+		http.Error(w, protocol.ErrInvalidValue, http.StatusBadRequest)
+		return
+	}
+	params := make(map[string]string)
+	if body.Loop != nil {
+		params["loop"] = strconv.FormatBool(*body.Loop)
+	}
+	if body.SingleClip != nil {
+		params["single clip"] = strconv.FormatBool(*body.SingleClip)
+	}
+	if body.Speed != nil {
+		params["speed"] = strconv.FormatInt(int64(*body.Speed*100), 10)
+	}
+	d.restCommand(w, "play", params)
+}
+
+func (d *VLCDeck) restPlaybackValue() interface{} {
+	speed, _ := strconv.ParseFloat(d.timeline.TransportSpeed(), 64)
+	return map[string]interface{}{
+		"type":       restTransportType(d.timeline.TransportStatus()),
+		"loop":       d.timeline.loop,
+		"singleClip": d.timeline.singleClip,
+		"speed":      speed / 100,
+		"position":   d.timeline.Timecode().Frame(),
+	}
+}
+
+// restTransportType is the REST name for a transport status
+func restTransportType(status string) string {
+	switch status {
+	case "play":
+		return "Play"
+	case "forward", "rewind", "shuttle":
+		return "Shuttle"
+	case "jog":
+		return "Jog"
+	case "record":
+		return "Record"
+	case "preview":
+		return "Preview"
+	}
+	return "Stop"
+}
+
+func (d *VLCDeck) restRecord(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		d.restGet(w, d.restRecordValue)
+		return
+	}
+
+	var body struct {
+		ClipName string `json:"clipName"`
+	}
+	if r.ContentLength != 0 && !readJSON(w, r, &body) {
+		return
+	}
+	params := make(map[string]string)
+	if body.ClipName != "" {
+		params["name"] = body.ClipName
+	}
+	d.restCommand(w, "record", params)
+}
+
+func (d *VLCDeck) restRecordValue() interface{} {
+	return map[string]interface{}{
+		"recording": d.timeline.Recording(),
+	}
+}
+
+func (d *VLCDeck) restTimecode(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	d.restGet(w, d.restTimecodeValue)
+}
+
+func (d *VLCDeck) restTimecodeValue() interface{} {
+	tc := d.timeline.Timecode()
+	return map[string]interface{}{
+		"display":  tc.String(),
+		"timeline": tc.String(),
+	}
+}
+
+func (d *VLCDeck) restClipIndex(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		d.restGet(w, d.restClipIndexValue)
+		return
+	}
+
+	var body struct {
+		ClipIndex int `json:"clipIndex"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	d.restCommand(w, "goto", map[string]string{"clip id": strconv.Itoa(body.ClipIndex + 1)})
+}
+
+func (d *VLCDeck) restClipIndexValue() interface{} {
+	index := int(d.timeline.clipID) - 1
+	if d.timeline.Count() == 0 {
+		index = -1
+	}
+	return map[string]interface{}{
+		"clipIndex": index,
+	}
+}
+
+func (d *VLCDeck) restWorkingSet(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	d.restGet(w, d.restWorkingSetValue)
+}
+
+func (d *VLCDeck) restWorkingSetValue() interface{} {
+	now := d.clock.Now()
+	workingSet := make([]map[string]interface{}, 0)
+	for idx, slot := range d.slots {
+		free, err := slot.FreeSpace()
+		if err != nil {
+			log.Warn().Err(err).Msgf("error getting free space on slot %v", idx+1)
+		}
+		entry := map[string]interface{}{
+			"index":          idx,
+			"activeDisk":     uint(idx+1) == d.state.slotID,
+			"volume":         slot.VolumeName(),
+			"deviceName":     restDeviceName(idx),
+			"remainingSpace": free,
+			"clipCount":      len(slot.Clips()),
+		}
+		if uint(idx+1) == d.state.slotID {
+			entry["remainingRecordTime"] = int64(d.recordingTimeRemaining(now).Seconds())
+		}
+		workingSet = append(workingSet, entry)
+	}
+	return map[string]interface{}{
+		"size":       len(workingSet),
+		"workingset": workingSet,
+	}
+}
+
+// restDeviceName is what the REST API calls the disk in a slot
+func restDeviceName(idx int) string {
+	return "slot" + strconv.Itoa(idx+1)
+}
+
+func (d *VLCDeck) restActiveMedia(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		d.restGet(w, d.restActiveMediaValue)
+		return
+	}
+
+	var body struct {
+		WorkingsetIndex int `json:"workingsetIndex"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.WorkingsetIndex < 0 || body.WorkingsetIndex >= len(d.slots) {
+		http.Error(w, protocol.ErrOutOfRange, http.StatusBadRequest)
+		return
+	}
+	d.commandLock.Lock()
+	active := uint(body.WorkingsetIndex+1) == d.state.slotID
+	d.commandLock.Unlock()
+	if active {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	d.restCommand(w, "slot select", map[string]string{"slot id": strconv.Itoa(body.WorkingsetIndex + 1)})
+}
+
+func (d *VLCDeck) restActiveMediaValue() interface{} {
+	active := map[string]interface{}{
+		"workingsetIndex": -1,
+		"deviceName":      "",
+	}
+	if d.state.slotID > 0 {
+		active["workingsetIndex"] = d.state.slotID - 1
+		active["deviceName"] = restDeviceName(int(d.state.slotID - 1))
+	}
+	return active
+}
+
+func (d *VLCDeck) restTimeline(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	d.restGet(w, d.restTimelineValue)
+}
+
+func (d *VLCDeck) restTimelineValue() interface{} {
+	d.timeline.RLock()
+	defer d.timeline.RUnlock()
+
+	clips := make([]map[string]interface{}, 0)
+	for idx, clip := range d.timeline.GetClips() {
+		clips = append(clips, map[string]interface{}{
+			"clipUniqueId":     idx + 1,
+			"name":             clip.Name,
+			"frameCount":       clip.Duration.Frame(),
+			"durationTimecode": clip.Duration.String(),
+			"timelineIn":       clip.Start.Frame(),
+			"inTimecode":       clip.Start.String(),
+		})
+	}
+	return map[string]interface{}{
+		"clips": clips,
+	}
+}
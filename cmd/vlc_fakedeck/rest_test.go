@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restPut sends handler a PUT with a JSON body
+func restPut(handler http.HandlerFunc, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPut, restPrefix+path, strings.NewReader(body)))
+	return w
+}
+
+func TestRESTActiveMedia(t *testing.T) {
+	d := newTestDeck(t)
+
+	put := func(body string) int {
+		return restPut(d.restActiveMedia, "/media/active", body).Code
+	}
+	assert.Equal(t, http.StatusNoContent, put(`{"workingsetIndex": 1}`), "should switch slots")
+	assert.Equal(t, uint(2), d.state.slotID)
+	assert.Equal(t, http.StatusNoContent, put(`{"workingsetIndex": 1}`), "should be fine staying put")
+	assert.Equal(t, http.StatusBadRequest, put(`{"workingsetIndex": 2}`), "there's no third slot")
+
+	d.remote.Enabled = false
+	assert.Equal(t, http.StatusForbidden, put(`{"workingsetIndex": 0}`), "should need remote control")
+	assert.Equal(t, uint(2), d.state.slotID)
+}
+
+func TestRESTPlaybackPosition(t *testing.T) {
+	d := newTestDeck(t)
+	require.NoError(t, d.timeline.AddClip(testClip("a.mov", 10*time.Second)))
+	require.NoError(t, d.timeline.AddClip(testClip("b.mov", 10*time.Second)))
+
+	put := func(body string) int {
+		return restPut(d.restPlayback, "/transports/0/playback", body).Code
+	}
+	assert.Equal(t, http.StatusNoContent, put(`{"position": 100}`), "should move")
+	assert.Equal(t, int64(100), d.timeline.Timecode().Frame())
+	assert.Equal(t, "stopped", d.timeline.TransportStatus(), "moving shouldn't start playing")
+
+	assert.Equal(t, http.StatusBadRequest, put(`{"position": 100000}`), "should refuse to move off the timeline")
+	assert.Equal(t, int64(100), d.timeline.Timecode().Frame())
+
+	assert.Equal(t, http.StatusNoContent, put(`{"type": "Play", "position": 200}`), "should move and play")
+	assert.Equal(t, "play", d.timeline.TransportStatus())
+	assert.Equal(t, http.StatusNoContent, put(`{"type": "Stop"}`))
+	assert.Equal(t, "stopped", d.timeline.TransportStatus())
+
+	d.remote.Enabled = false
+	assert.Equal(t, http.StatusForbidden, put(`{"position": 300}`), "moving should need remote control")
+	assert.NotEqual(t, int64(300), d.timeline.Timecode().Frame())
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/josh23french/fakedeck/pkg/protocol"
@@ -14,22 +18,30 @@ import (
 
 type Slot struct {
 	sync.RWMutex
-	clips   []*DiskClip
-	path    string // path to folder
-	watcher *fsnotify.Watcher
+	clips      []*DiskClip
+	path       string // path to folder
+	volumeName string
+	watcher    *fsnotify.Watcher
+	onChange   func() // called when clips appear or disappear; nil if nobody cares
+	ejected    bool   // the virtual disk has been ejected with admin slot eject
+	freeSpace  int64  // simulated free bytes, set with admin slot fill; negative for the real free space
+	probe      ClipProbe
 }
 
-func NewSlot(path string) (*Slot, error) {
+func NewSlot(path string, probe ClipProbe) (*Slot, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		log.Error().Err(err).Msg("error creating watcher")
 		return nil, err
 	}
 	s := &Slot{
-		RWMutex: sync.RWMutex{},
-		clips:   make([]*DiskClip, 0),
-		path:    path,
-		watcher: watcher,
+		RWMutex:    sync.RWMutex{},
+		clips:      make([]*DiskClip, 0),
+		path:       path,
+		volumeName: "Untitled",
+		watcher:    watcher,
+		freeSpace:  -1,
+		probe:      probe,
 	}
 
 	// start the loop... can be stopped by s.watcher.Close()
@@ -43,9 +55,12 @@ func NewSlot(path string) (*Slot, error) {
 		return nil, err
 	}
 	for idx, file := range files {
+		if hidden(file.Name()) {
+			continue
+		}
 		log.Info().Msgf("File %v: %v", idx, file)
 		path := filepath.Join(s.path, file.Name())
-		newClip, err := NewDiskClip(path)
+		newClip, err := NewDiskClip(path, s.probe)
 		if err != nil {
 			log.Error().Err(err).Msgf("error creating new disk clip: %v", file.Name())
 			continue
@@ -63,7 +78,7 @@ func NewSlot(path string) (*Slot, error) {
 func (s *Slot) GetClip(name string) (*DiskClip, error) {
 	s.RLock()
 	defer s.RUnlock()
-	for _, clip := range s.Clips() {
+	for _, clip := range s.clips {
 		if clip.Name == name {
 			return clip, nil
 		}
@@ -111,12 +126,112 @@ func (s *Slot) RemoveClip(name string) error {
 	return nil
 }
 
+// Clips returns a copy of the slot's clips, sorted by name, so they can be gone through while the folder changes
 func (s *Slot) Clips() []*DiskClip {
-	return s.clips
+	s.RLock()
+	defer s.RUnlock()
+	clips := make([]*DiskClip, len(s.clips))
+	copy(clips, s.clips)
+	return clips
+}
+
+// OnChange sets a function to be called whenever a clip is added to or removed from the slot's folder
+func (s *Slot) OnChange(fn func()) {
+	s.onChange = fn
+}
+
+func (s *Slot) changed() {
+	if s.onChange != nil {
+		s.onChange()
+	}
+}
+
+// hidden files are ignored, e.g. uploads that are still in progress
+func hidden(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
+
+func (s *Slot) Path() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.path
+}
+
+// Mounted returns whether there's a disk in the slot, i.e. it hasn't been ejected
+func (s *Slot) Mounted() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return !s.ejected
+}
+
+// SetMounted inserts or ejects the slot's virtual disk; the folder is left alone either way
+func (s *Slot) SetMounted(mounted bool) {
+	s.Lock()
+	s.ejected = !mounted
+	s.Unlock()
+	s.changed()
+}
+
+// SetFreeSpace pretends the disk has free bytes left, or goes back to the real free space if free is negative
+func (s *Slot) SetFreeSpace(free int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.freeSpace = free
+}
+
+// FreeSpace returns how many bytes are free on the disk the slot's folder is on, unless it's being simulated
+func (s *Slot) FreeSpace() (uint64, error) {
+	s.RLock()
+	simulated, path := s.freeSpace, s.path
+	s.RUnlock()
+	if simulated >= 0 {
+		return uint64(simulated), nil
+	}
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, fmt.Errorf("error getting free space for %v: %w", path, err)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+func (s *Slot) VolumeName() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.volumeName
+}
+
+// Format empties the folder at path and makes it the slot's folder, as if a freshly formatted disk was inserted
+func (s *Slot) Format(path string, volumeName string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := os.RemoveAll(path)
+	if err != nil {
+		return fmt.Errorf("error wiping %v: %w", path, err)
+	}
+	err = os.MkdirAll(path, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating %v: %w", path, err)
+	}
+
+	err = s.watcher.Remove(s.path)
+	if err != nil {
+		log.Warn().Err(err).Msgf("error unwatching %v", s.path)
+	}
+	err = s.watcher.Add(path)
+	if err != nil {
+		return fmt.Errorf("error watching %v: %w", path, err)
+	}
+
+	s.path = path
+	s.volumeName = volumeName
+	s.clips = make([]*DiskClip, 0)
+	return nil
 }
 
 func (s *Slot) loop() {
-	log.Info().Msgf("started watcher handler loop: %v", s.path)
+	log.Info().Msgf("started watcher handler loop: %v", s.Path())
 	for {
 		select {
 		case event, ok := <-s.watcher.Events:
@@ -125,19 +240,29 @@ func (s *Slot) loop() {
 				return
 			}
 			log.Info().Msgf("event: %v", event)
+			if hidden(event.Name) {
+				continue
+			}
 			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
 				log.Info().Msgf("saw new/changed file: %v", event.Name)
-				newClip, err := NewDiskClip(event.Name)
+				newClip, err := NewDiskClip(event.Name, s.probe)
 				if err != nil {
 					log.Error().Err(err).Msgf("error creating new disk clip: %v", event.Name)
 					continue
 				}
+				_, err = s.GetClip(newClip.Name)
+				isNew := err != nil
 				s.AddClip(newClip) // Name is the whole path already
+				if isNew {
+					s.changed()
+				}
 			}
 			if event.Op&fsnotify.Remove == fsnotify.Remove || event.Op&fsnotify.Rename == fsnotify.Rename {
 				log.Info().Msgf("saw deleted/renamed file: %v", event.Name)
 				name := filepath.Base(event.Name)
-				s.RemoveClip(name)
+				if s.RemoveClip(name) == nil {
+					s.changed()
+				}
 			}
 		case err, ok := <-s.watcher.Errors:
 			if !ok {
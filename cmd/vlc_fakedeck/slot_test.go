@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlotClipsWhileChanging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakedeck-slot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := NewSlot(dir, ClipProbe{})
+	require.NoError(t, err)
+
+	// the watcher adds and removes clips while commands read them
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			name := fmt.Sprintf("%03d.mov", i)
+			s.AddClip(testClip(name, time.Second))
+			if i%2 == 0 {
+				s.RemoveClip(name)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		for _, clip := range s.Clips() {
+			assert.NotNil(t, clip, "a clip that's removed shouldn't leave a hole in a copy already taken")
+		}
+		s.GetClip("001.mov")
+		s.Path()
+		s.VolumeName()
+	}
+	wg.Wait()
+
+	assert.Len(t, s.Clips(), 50)
+	_, err = s.GetClip("001.mov")
+	assert.NoError(t, err)
+}
+
+func TestSlotProbesClips(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakedeck-slot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.mov"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".uploading.mov"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.mov"), nil, 0644))
+
+	probe := ClipProbe{
+		Duration: func(path string) (time.Duration, error) {
+			if filepath.Base(path) == "broken.mov" {
+				return 0, errors.New("no duration")
+			}
+			return 5 * time.Second, nil
+		},
+		Color: func(path string) (string, string, error) { return "arib-std-b67", "bt2020", nil },
+	}
+	s, err := NewSlot(dir, probe)
+	require.NoError(t, err)
+
+	clips := s.Clips()
+	require.Len(t, clips, 1, "hidden files and clips that can't be probed should be left out")
+	assert.Equal(t, "a.mov", clips[0].Name)
+	assert.Equal(t, 5*time.Second, clips[0].Media().Duration)
+	assert.Equal(t, deck.DynamicRangeHLG, clips[0].DynamicRange)
+}
@@ -1,16 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"image"
-	"image/png"
+	"math"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	vlc "github.com/adrg/libvlc-go/v3"
 	"github.com/josh23french/fakedeck/pkg/deck"
 	"github.com/josh23french/fakedeck/pkg/protocol"
 	"github.com/rs/zerolog/log"
@@ -25,112 +24,423 @@ const (
 	Black
 )
 
-// TimelinePlayer is a HyperDeck-like replacement for vlc.MediaList, because it sucks
+// TimelinePlayer is a HyperDeck-like timeline of clips, played by a PlaybackEngine
 type TimelinePlayer struct {
-	sync.RWMutex             // need to Lock when we mess with the clips slice
-	player       *vlc.Player // reference to the Player
-	clips        []Clip      // set of clips
+	sync.RWMutex                // need to Lock when we mess with the clips slice
+	engine       PlaybackEngine // what actually plays the clips
+	clips        []Clip         // set of clips
 
 	// calculated
 	clipID       uint              // current clip ID
-	prevClipsDur timecode.Timecode // sum of duration of all clips prior to clipID
+	prevClipsDur timecode.Timecode // sum of duration of all clips on the timeline; where the next clip added will start
 
 	// options
 	loop       bool     // are we looping the timeline?
 	singleClip bool     // are we only playing the one clip?
 	stopMode   StopMode // what happens when we stop? (end of timeline or singleClip, not manually)
 
+	audioChannels int // channels playback audio is routed onto
+
+	// play range
+	rangeSet bool  // is playback constrained to the play range?
+	rangeIn  int64 // first frame of the play range on the timeline
+	rangeOut int64 // frame just after the end of the play range on the timeline
+
 	// state
-	blanked bool // true if the media in the player is not the clip and is the blank material
+	blanked    bool // true if the media in the engine is not the clip and is the blank material
+	previewing bool // true if the engine is showing the input instead of the timeline
+	recording  bool // true if we're pretending to record the input; always previewing too
+
+	// transport, which keeps time by the clock rather than the engine, so it can run on a fake one
+	clock      deck.Clock
+	playing    bool          // is the transport moving?
+	speed      float64       // how fast it moves while playing; 1 is normal speed
+	anchorPos  time.Duration // position in the current clip as of anchorTime
+	anchorTime time.Time
+	ticker     deck.Timer  // fires a frame from now while the transport needs watching; nil otherwise
+	tickLock   sync.Mutex  // guards ticker
+	lastFrame  int64       // last position ticked, so onTick only hears about changes
+	onTick     func()      // called from the clock each time the position changes
+	lock       sync.Locker // taken by the clock to move the transport; the deck's command lock, so they take turns
 
 	// stuff that probably belongs elsewhere
 	rate   timecode.Rate
 	server *deck.Server
+	notify *deck.NotifyFlags // whether the transport info it sends goes to the client
+	stats  *deck.Stats
 }
 
-func NewTimelinePlayer(player *vlc.Player, rate timecode.Rate) *TimelinePlayer {
+// engineMaxDrift is how far the engine can get from the transport before it's put back in step
+const engineMaxDrift = 500 * time.Millisecond
+
+func NewTimelinePlayer(engine PlaybackEngine, rate timecode.Rate, clock deck.Clock) *TimelinePlayer {
 	t := &TimelinePlayer{
-		RWMutex:      sync.RWMutex{},
-		player:       player,
-		clips:        []Clip{},
-		clipID:       1,
-		prevClipsDur: timecode.New(0, rate),
-		loop:         false,
-		singleClip:   false,
-		stopMode:     Black,
-		blanked:      false,
-		rate:         rate,
-	}
-
-	em, err := t.player.EventManager()
-	if err != nil {
-		log.Fatal().Err(err).Msg("error getting player EventManager")
+		RWMutex:       sync.RWMutex{},
+		engine:        engine,
+		clips:         []Clip{},
+		clipID:        1,
+		prevClipsDur:  timecode.New(0, rate),
+		loop:          false,
+		singleClip:    false,
+		stopMode:      Black,
+		audioChannels: 2,
+		blanked:       false,
+		rate:          rate,
+		clock:         clock,
+		speed:         1,
+		anchorTime:    clock.Now(),
+		lock:          &sync.Mutex{},
+		notify:        &deck.NotifyFlags{},
 	}
-	em.Attach(vlc.MediaPlayerEndReached, t.onEndReached, nil)
-
+	engine.OnEnd(t.onEngineEnd)
 	return t
 }
 
-func (t *TimelinePlayer) onEndReached(event vlc.Event, userData interface{}) {
-	log.Info().Msg("onEndReached!!")
-	go func() {
-		t.RLock()
-		defer t.RUnlock()
-		noNextClip := int(t.clipID)+1 >= len(t.clips)
-		if t.singleClip || noNextClip {
-			if t.loop {
-				if t.singleClip {
-					t.PlayClip(t.clipID)
-				} else {
-					t.PlayClip(1)
-				}
-				return
+// onEngineEnd hears when the engine plays a clip to its end, or its start if it's playing backwards. The transport
+// keeps time, but the engine has actually seen the media, so if it gets there first the clip must be shorter than it
+// looked, and the next tick moves on. Ends of anything but the current clip are too late to matter. It's called on the
+// engine's goroutine, so it takes the lock like tick does.
+func (t *TimelinePlayer) onEngineEnd(media *Media) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	clip, err := t.GetCurrentClip()
+	if err != nil || !t.playing || t.previewing || t.blanked || media != clip.media {
+		return
+	}
+	if t.speed < 0 {
+		if t.transportPosition() > 0 {
+			log.Debug().Msgf("engine reached the start of %v before the transport did", clip.Name)
+			t.setTransport(0, true, t.speed)
+		}
+		return
+	}
+	if end := t.framesToDuration(clip.Duration.Frame()); t.transportPosition() < end {
+		log.Debug().Msgf("engine reached the end of %v before the transport did", clip.Name)
+		t.setTransport(end, true, t.speed)
+	}
+}
+
+// transportPosition returns how far into the current clip the transport has moved, which can be past the end of the
+// clip until the next tick deals with it
+func (t *TimelinePlayer) transportPosition() time.Duration {
+	if !t.playing {
+		return t.anchorPos
+	}
+	return t.anchorPos + time.Duration(float64(t.clock.Now().Sub(t.anchorTime))*t.speed)
+}
+
+// clipPosition returns the position of the frame the transport's on in the current clip
+func (t *TimelinePlayer) clipPosition() time.Duration {
+	pos := t.transportPosition()
+	if clip, err := t.GetCurrentClip(); err == nil {
+		if last := t.framesToDuration(clip.Duration.Frame() - 1); pos > last {
+			pos = last
+		}
+	}
+	if pos < 0 {
+		return 0
+	}
+	return pos
+}
+
+// setTransport puts the transport at pos in the current clip, moving at speed if playing
+func (t *TimelinePlayer) setTransport(pos time.Duration, playing bool, speed float64) {
+	t.anchorPos = pos
+	t.anchorTime = t.clock.Now()
+	t.playing = playing
+	t.speed = speed
+	t.startTicking()
+}
+
+// startTicking makes sure the transport's watched for the next frame
+func (t *TimelinePlayer) startTicking() {
+	t.tickLock.Lock()
+	defer t.tickLock.Unlock()
+	if t.ticker == nil {
+		t.ticker = t.clock.AfterFunc(t.framesToDuration(1), t.tick)
+	}
+}
+
+// stopTicking stops watching the transport, for when there's nothing left to watch
+func (t *TimelinePlayer) stopTicking() {
+	t.tickLock.Lock()
+	defer t.tickLock.Unlock()
+	if t.ticker != nil {
+		t.ticker.Stop()
+		t.ticker = nil
+	}
+}
+
+// tick watches the transport each frame while it's moving: it reaches the ends of clips and the play range (or their
+// starts, playing backwards), keeps the engine in step, and lets onTick know about the new position. It runs on the
+// clock's goroutine, holding the lock throughout, onTick included.
+func (t *TimelinePlayer) tick() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.tickLock.Lock()
+	t.ticker = nil
+	t.tickLock.Unlock()
+
+	if t.previewing {
+		return
+	}
+	if clip, err := t.GetCurrentClip(); err == nil && t.playing && !t.blanked {
+		switch {
+		case t.speed < 0 && t.transportPosition() <= 0:
+			t.startOfClip()
+		case t.speed < 0 && t.rangeSet && t.Timecode().Frame() < t.rangeIn:
+			t.startOfRange()
+		case t.transportPosition() >= t.framesToDuration(clip.Duration.Frame()):
+			t.endOfClip()
+		case t.rangeSet && t.Timecode().Frame() >= t.rangeOut:
+			t.endOfRange()
+		default:
+			t.syncEngine(t.clipPosition())
+		}
+	}
+
+	if frame := t.Timecode().Frame(); frame != t.lastFrame {
+		t.lastFrame = frame
+		if t.onTick != nil {
+			t.onTick()
+		}
+	}
+	if t.playing {
+		t.startTicking()
+	}
+}
+
+// syncEngine seeks the engine to the transport's position if it's drifted too far from it
+func (t *TimelinePlayer) syncEngine(pos time.Duration) {
+	enginePos, err := t.engine.Position()
+	if err != nil {
+		return
+	}
+	drift := enginePos - pos
+	if drift > engineMaxDrift || drift < -engineMaxDrift {
+		log.Debug().Msgf("engine drifted %v from the transport; seeking", drift)
+		t.engine.Seek(pos)
+	}
+}
+
+// endOfClip goes on to whatever's next once the transport reaches the end of the current clip
+func (t *TimelinePlayer) endOfClip() {
+	log.Info().Msg("end of clip reached")
+	if t.rangeSet {
+		clip := t.GetClipByID(t.clipID)
+		if clip.Start.Frame()+clip.Duration.Frame() >= t.rangeOut {
+			t.endOfRange()
+			return
+		}
+	}
+	noNextClip := int(t.clipID) >= len(t.clips)
+	if t.singleClip || noNextClip {
+		if t.loop {
+			if t.singleClip {
+				t.PlayClip(t.clipID)
+			} else {
+				t.PlayClip(1)
 			}
-			switch t.stopMode {
-			case LastFrame:
-				go t.Stop()
-			case NextFrame:
-				if noNextClip {
-					err := t.StopOnBlack()
-					if err != nil {
-						log.Error().Err(err).Msg("error going to next clip after previous clip end reached")
-					}
-					break
-				}
-				t.player.SetPause(true)
-				go t.Next()
-			case Black:
+			return
+		}
+		switch t.stopMode {
+		case LastFrame:
+			t.Stop()
+		case NextFrame:
+			if noNextClip {
 				err := t.StopOnBlack()
 				if err != nil {
 					log.Error().Err(err).Msg("error going to next clip after previous clip end reached")
 				}
+				break
 			}
-		} else {
-			err := t.Next()
+			t.Stop()
+			t.Next()
+		case Black:
+			err := t.StopOnBlack()
 			if err != nil {
 				log.Error().Err(err).Msg("error going to next clip after previous clip end reached")
 			}
 		}
-	}()
+	} else {
+		err := t.Next()
+		if err != nil {
+			log.Error().Err(err).Msg("error going to next clip after previous clip end reached")
+		}
+	}
+}
+
+// endOfRange loops back to the start of the play range, or stops, depending on the loop setting
+func (t *TimelinePlayer) endOfRange() {
+	if !t.loop {
+		err := t.Stop()
+		if err != nil {
+			log.Error().Err(err).Msg("error stopping at end of play range")
+		}
+		return
+	}
+	err := t.SeekFrame(t.rangeIn)
+	if err != nil {
+		log.Error().Err(err).Msg("error looping to start of play range")
+	}
+}
+
+// startOfClip goes back to the end of the previous clip once the transport, playing backwards, reaches the start of
+// the current one. At the start of the timeline (or the clip, playing a single clip) it loops back round to the end or
+// stops.
+func (t *TimelinePlayer) startOfClip() {
+	log.Info().Msg("start of clip reached")
+	if t.rangeSet && t.GetClipByID(t.clipID).Start.Frame() <= t.rangeIn {
+		t.startOfRange()
+		return
+	}
+	switch {
+	case !t.singleClip && t.clipID > 1:
+		t.changeClip(t.clipID-1, true)
+	case t.loop && t.singleClip:
+		t.changeClip(t.clipID, true)
+	case t.loop:
+		t.changeClip(uint(len(t.clips)), true)
+	default:
+		t.Stop()
+	}
+}
+
+// startOfRange loops back to the end of the play range, or stops, once the transport reaches its start playing
+// backwards
+func (t *TimelinePlayer) startOfRange() {
+	if !t.loop {
+		err := t.Stop()
+		if err != nil {
+			log.Error().Err(err).Msg("error stopping at start of play range")
+		}
+		return
+	}
+	err := t.SeekFrame(t.rangeOut - 1)
+	if err != nil {
+		log.Error().Err(err).Msg("error looping to end of play range")
+	}
 }
 
 func (t *TimelinePlayer) Timecode() timecode.Timecode {
-	clipTime, err := t.player.MediaTime()
+	clip, err := t.GetCurrentClip()
 	if err != nil {
-		// If the media hasn't started yet, we're at zero
-		if err.Error() == "No active input" {
-			return timecode.New(0, t.rate)
+		return timecode.New(0, t.rate)
+	}
+	return clip.Start.Add(t.clipPosition())
+}
+
+// Duration returns the length of the whole timeline in frames
+func (t *TimelinePlayer) Duration() int64 {
+	return t.prevClipsDur.Frame()
+}
+
+// FramesToTimecode converts a position on the timeline in frames into a timecode
+func (t *TimelinePlayer) FramesToTimecode(frames int64) timecode.Timecode {
+	return timecode.New(t.framesToDuration(frames), t.rate)
+}
+
+// ParseTimecode converts a timecode string (hh:mm:ss:ff, or hh:mm:ss;ff for drop-frame) into a position on the
+// timeline in frames
+func (t *TimelinePlayer) ParseTimecode(tc string) int64 {
+	var h, m, s, f int64
+	fmt.Sscanf(strings.Replace(tc, ";", ":", 1), "%d:%d:%d:%d", &h, &m, &s, &f)
+
+	fps := (timecode.New(time.Hour, t.rate).Frame() + 1800) / 3600 // nominal frame rate, e.g. 60 for 59.94
+	frames := ((h*60+m)*60+s)*fps + f
+	if strings.Contains(tc, ";") {
+		// drop-frame skips the first fps/15 frame numbers of every minute, except every tenth minute
+		minutes := h*60 + m
+		frames -= fps / 15 * (minutes - minutes/10)
+	}
+	return frames
+}
+
+// framesToDuration returns how long frames frames last, rounded up to the nanosecond so that the position it gives is
+// in the frame it's meant to be rather than a hair before it
+func (t *TimelinePlayer) framesToDuration(frames int64) time.Duration {
+	perHour := timecode.New(time.Hour, t.rate).Frame()
+	return time.Duration(math.Ceil(float64(frames) / float64(perHour) * float64(time.Hour)))
+}
+
+// SeekFrame moves playback to a position on the timeline in frames, changing clips if needed
+func (t *TimelinePlayer) SeekFrame(frame int64) error {
+	for idx := range t.clips {
+		clip := &t.clips[idx]
+		start := clip.Start.Frame()
+		if frame < start || frame >= start+clip.Duration.Frame() {
+			continue
 		}
-		log.Fatal().Err(err).Msg("error getting media time")
+		clipID := uint(idx + 1)
+		pos := t.framesToDuration(frame - start)
+		if reversible, ok := t.engine.(ReversibleEngine); ok && clipID == t.clipID && !t.blanked && !t.playing {
+			// jogging a frame either way is stepped rather than sought, which the engine can do exactly
+			if step := frame - t.Timecode().Frame(); step == 1 || step == -1 {
+				t.setTransport(pos, false, t.speed)
+				return reversible.StepFrame(step > 0)
+			}
+		}
+		if clipID != t.clipID || t.blanked {
+			t.engine.Load(clip.media)
+			t.clipID = clipID
+			t.blanked = false
+			if t.playing {
+				t.engine.Play()
+			}
+		}
+		t.setTransport(pos, t.playing, t.speed)
+		return t.engine.Seek(pos)
 	}
-	return t.prevClipsDur.Add(time.Duration(clipTime) * time.Millisecond)
+	return errors.New(protocol.ErrOutOfRange)
+}
+
+// SetPlayRange constrains playback to the frames from in up to (but not including) out
+func (t *TimelinePlayer) SetPlayRange(in, out int64) error {
+	if in < 0 || out > t.Duration() || in >= out {
+		return errors.New(protocol.ErrOutOfRange)
+	}
+	t.rangeIn = in
+	t.rangeOut = out
+	t.rangeSet = true
+	return nil
+}
+
+// ClearPlayRange lets playback use the whole timeline again
+func (t *TimelinePlayer) ClearPlayRange() {
+	t.rangeSet = false
+	t.rangeIn = 0
+	t.rangeOut = 0
+}
+
+// PlayRange returns the current play range in frames, and whether there is one at all
+func (t *TimelinePlayer) PlayRange() (in int64, out int64, ok bool) {
+	return t.rangeIn, t.rangeOut, t.rangeSet
+}
+
+// ClipRange returns the frames spanned by count clips starting at clipID
+func (t *TimelinePlayer) ClipRange(clipID uint, count uint) (in int64, out int64, err error) {
+	if clipID < 1 || count < 1 || int(clipID+count-1) > len(t.clips) {
+		return 0, 0, errors.New(protocol.ErrOutOfRange)
+	}
+	first := t.GetClipByID(clipID)
+	last := t.GetClipByID(clipID + count - 1)
+	return first.Start.Frame(), last.Start.Frame() + last.Duration.Frame(), nil
 }
 
 // TransportStatus returns the current transport status:
 //  preview, stopped, play, forward, rewind, jog, shuttle, or record
 func (t *TimelinePlayer) TransportStatus() string {
-	if !t.blanked && t.player.IsPlaying() {
-		if t.player.PlaybackRate() != 1 {
+	if t.recording {
+		return "record"
+	}
+	if t.previewing {
+		return "preview"
+	}
+	if !t.blanked && t.playing {
+		if t.speed < 0 {
+			return "rewind"
+		}
+		if t.speed != 1 {
 			return "forward"
 		}
 		return "play"
@@ -139,82 +449,172 @@ func (t *TimelinePlayer) TransportStatus() string {
 }
 
 func (t *TimelinePlayer) TransportSpeed() string {
-	if t.player.IsPlaying() {
-		rate := t.player.PlaybackRate() * 100
-		return strconv.FormatInt(int64(rate), 10)
+	if !t.previewing && t.playing {
+		return strconv.FormatInt(int64(t.speed*100), 10)
 	}
 	return "0"
 }
 
 func (t *TimelinePlayer) Play() error {
-	state, err := t.player.MediaState()
+	clip, err := t.GetCurrentClip()
 	if err != nil {
-		return fmt.Errorf("error getting media state: %w", err)
+		return err
+	}
+	t.previewing = false
+	t.recording = false
+	pos := t.clipPosition()
+	if t.blanked {
+		t.engine.Load(clip.media)
+		t.blanked = false
+		pos = 0
+	}
+	t.engine.Play()
+	t.setTransport(pos, true, t.speed)
+	if t.rangeSet {
+		if pos := t.Timecode().Frame(); pos < t.rangeIn || pos >= t.rangeOut {
+			err := t.SeekFrame(t.rangeIn)
+			if err != nil {
+				return fmt.Errorf("error seeking to start of play range: %w", err)
+			}
+		}
 	}
-	if t.blanked || state == vlc.MediaEnded {
-		t.player.SetMedia(t.GetClipByID(t.clipID).media)
-		t.player.SetMediaTime(0)
+	t.sendAsyncTransportInfo()
+	return nil
+}
+
+// SetSpeed changes how fast the transport moves while it's playing; 1 is normal speed
+func (t *TimelinePlayer) SetSpeed(speed float64) error {
+	t.setTransport(t.clipPosition(), t.playing, speed)
+	err := t.engine.SetRate(speed)
+	if err != nil {
+		return err
 	}
-	t.player.Play()
 	t.sendAsyncTransportInfo()
 	return nil
 }
 
 func (t *TimelinePlayer) sendAsyncTransportInfo() {
 	go func() {
-		time.Sleep(100 * time.Millisecond)
+		t.clock.Sleep(100 * time.Millisecond)
+		t.lock.Lock()
+		status := t.TransportStatus()
+		if t.stats != nil {
+			t.stats.Playing(status == "play" || status == "forward" || status == "rewind")
+		}
 		note := protocol.Command{
 			Name: "508 transport info",
 			Parameters: map[string]string{
-				"status":      t.TransportStatus(),
+				"status":      status,
 				"speed":       t.TransportSpeed(),
 				"loop":        strconv.FormatBool(t.loop),
 				"single clip": strconv.FormatBool(t.singleClip),
 				"clip id":     strconv.FormatUint(uint64(t.clipID), 10),
 			},
 		}
-		t.server.AsyncSend(note.Marshall())
+		send := t.notify.Transport
+		t.lock.Unlock()
+		t.server.Notify(note.Marshall(), send)
 	}()
 }
 
 func (t *TimelinePlayer) PlayClip(clipID uint) error {
-	t.player.SetMedia(t.GetClipByID(clipID).media)
-	t.player.SetMediaTime(0)
-	t.player.Play()
+	t.engine.Load(t.GetClipByID(clipID).media)
+	t.engine.Play()
 	t.clipID = clipID
+	t.blanked = false
+	t.setTransport(0, true, t.speed)
 	return nil
 }
 
 func (t *TimelinePlayer) Stop() error {
-	t.player.SetPause(true)
+	if t.recording {
+		// back to showing the input, like a real deck does after recording
+		t.recording = false
+		t.sendAsyncTransportInfo()
+		return nil
+	}
+	t.engine.Pause()
+	t.setTransport(t.clipPosition(), false, t.speed)
 	t.sendAsyncTransportInfo()
 	return nil
 }
 
-func (t *TimelinePlayer) StopOnBlack() error {
-	img := image.NewGray(image.Rect(0, 0, 1, 1))
-	buf := bytes.NewBuffer(nil)
-	err := png.Encode(buf, img)
+// Preview shows the input instead of the timeline
+func (t *TimelinePlayer) Preview(input *Media) error {
+	err := t.engine.Load(input)
 	if err != nil {
-		return fmt.Errorf("error encoding blank screen image %w", err)
+		return fmt.Errorf("error setting input media: %w", err)
 	}
-	blank, err := vlc.NewMediaFromReadSeeker(bytes.NewReader(buf.Bytes()))
+	err = t.engine.Play()
 	if err != nil {
-		return fmt.Errorf("error creating new media from blank screen image %w", err)
+		return fmt.Errorf("error playing input media: %w", err)
 	}
-	t.player.SetMedia(blank)
-	t.player.Play()
+	t.previewing = true
+	t.blanked = true // the clip has to be loaded again before it can play
+	t.setTransport(0, false, t.speed)
+	t.sendAsyncTransportInfo()
+	return nil
+}
+
+// Record pretends to record the input: it's shown as in preview, but the transport status is record
+func (t *TimelinePlayer) Record(input *Media) error {
+	if !t.previewing {
+		err := t.Preview(input)
+		if err != nil {
+			return err
+		}
+	}
+	t.recording = true
+	t.sendAsyncTransportInfo()
+	return nil
+}
+
+// Recording returns whether we're pretending to record
+func (t *TimelinePlayer) Recording() bool {
+	return t.recording
+}
+
+// EndPreview goes back to showing the timeline, stopped at the start of the current clip
+func (t *TimelinePlayer) EndPreview() error {
+	t.previewing = false
+	t.recording = false
+	clip, err := t.GetCurrentClip()
+	if err != nil {
+		return t.StopOnBlack()
+	}
+	err = t.engine.Load(clip.media)
+	if err != nil {
+		return fmt.Errorf("error setting clip media after preview: %w", err)
+	}
+	t.blanked = false
+	t.setTransport(0, false, t.speed)
+	t.sendAsyncTransportInfo()
+	return nil
+}
+
+// Previewing returns whether the input is being shown instead of the timeline
+func (t *TimelinePlayer) Previewing() bool {
+	return t.previewing
+}
+
+func (t *TimelinePlayer) StopOnBlack() error {
+	blank, err := ImageMedia(image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		return fmt.Errorf("error creating blank screen media %w", err)
+	}
+	t.engine.Load(blank)
+	t.engine.Play()
+	t.blanked = true
+	t.setTransport(0, false, t.speed)
 	t.sendAsyncTransportInfo()
 	return nil
 }
 
 func (t *TimelinePlayer) Next() error {
-	nextClip := t.clipID + 1
-	if int(nextClip) >= len(t.clips) {
+	if int(t.clipID) >= len(t.clips) {
 		return errors.New(protocol.ErrOutOfRange)
 	}
-	t.player.SetMedia(t.GetClipByID(nextClip).media)
-	t.clipID = nextClip
+	t.changeClip(t.clipID+1, false)
 	return nil
 }
 
@@ -223,11 +623,28 @@ func (t *TimelinePlayer) Previous() error {
 	if nextClip < 1 {
 		return errors.New(protocol.ErrOutOfRange)
 	}
-	t.player.SetMedia(t.GetClipByID(nextClip).media)
-	t.clipID = nextClip
+	t.changeClip(nextClip, false)
 	return nil
 }
 
+// changeClip moves the transport to the start of another clip, or its last frame if atEnd, carrying on playing if it
+// was
+func (t *TimelinePlayer) changeClip(clipID uint, atEnd bool) {
+	clip := t.GetClipByID(clipID)
+	t.engine.Load(clip.media)
+	var pos time.Duration
+	if atEnd {
+		pos = t.framesToDuration(clip.Duration.Frame() - 1)
+		t.engine.Seek(pos)
+	}
+	if t.playing {
+		t.engine.Play()
+	}
+	t.clipID = clipID
+	t.blanked = false
+	t.setTransport(pos, t.playing, t.speed)
+}
+
 func (t *TimelinePlayer) GetCurrentClip() (*Clip, error) {
 	if len(t.clips) == 0 {
 		return nil, errors.New(protocol.ErrTimelineEmpty)
@@ -249,6 +666,17 @@ func (t *TimelinePlayer) SetLoop(loop bool) {
 	t.loop = loop
 }
 
+// SetAudioChannels routes the audio of every clip onto channels channels; it takes effect the next time each clip is
+// loaded into the engine
+func (t *TimelinePlayer) SetAudioChannels(channels int) error {
+	t.audioChannels = channels
+	err := t.engine.SetAudioChannels(channels)
+	if err != nil {
+		return fmt.Errorf("error routing audio: %w", err)
+	}
+	return nil
+}
+
 func (t *TimelinePlayer) recalcTimeline(from int) {
 
 }
@@ -261,16 +689,20 @@ func (t *TimelinePlayer) recalcTimeline(from int) {
 //  clips remove: clip id: {n}                         remove clip {n} from the timeline
 func (t *TimelinePlayer) AddClip(clip *DiskClip) error {
 	t.clips = append(t.clips, Clip{
-		Name:     clip.Name,
-		path:     clip.path,
-		media:    clip.media,
-		Duration: clip.Duration,
-		Start:    t.prevClipsDur,
+		Name:         clip.Name,
+		path:         clip.path,
+		media:        clip.media,
+		Duration:     clip.Duration,
+		DynamicRange: clip.DynamicRange,
+		Start:        t.prevClipsDur,
 	})
 	t.prevClipsDur += clip.Duration
-	if media, err := t.player.Media(); err == nil && media == nil {
-		log.Info().Msg("player media not set; setting to added clip")
-		t.player.SetMedia(clip.media)
+	if len(t.clips) == 1 && !t.blanked && !t.previewing {
+		log.Info().Msg("first clip on the timeline; loading it")
+		err := t.engine.Load(clip.media)
+		if err != nil {
+			return fmt.Errorf("error loading clip: %w", err)
+		}
 	}
 	return nil
 }
@@ -287,7 +719,14 @@ func (t *TimelinePlayer) InsertClip(clip *Clip, afterClipID uint) error {
 
 // clips clear
 func (t *TimelinePlayer) ClearClips() error {
+	t.Lock()
+	defer t.Unlock()
 	// Timeline doesn't own the clip, so just forget our references
 	t.clips = make([]Clip, 0)
+	t.prevClipsDur = timecode.New(0, t.rate)
+	t.clipID = 1
+	t.playing = false
+	t.anchorPos = 0
+	t.stopTicking()
 	return nil
 }
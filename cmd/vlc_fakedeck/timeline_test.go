@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trimmer.io/go-timecode/timecode"
+)
+
+// newTestTimeline makes a timeline of clips lasting durations, played by the sim engine on a fake clock
+func newTestTimeline(t *testing.T, durations ...time.Duration) (*TimelinePlayer, *deck.FakeClock) {
+	clock := deck.NewFakeClock(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	tl := NewTimelinePlayer(newSimEngine(clock), timecode.Rate60DF, clock)
+	tl.server = deck.NewServer(nil) // for the transport info it sends
+	for idx, dur := range durations {
+		require.NoError(t, tl.AddClip(testClip(fmt.Sprintf("%v.mov", idx+1), dur)))
+	}
+	return tl, clock
+}
+
+// frames is how many frames there are in dur, give or take the odd frame moving between clips loses
+func frames(tl *TimelinePlayer, dur time.Duration) float64 {
+	return float64(tl.FramesToTimecode(0).Add(dur).Frame())
+}
+
+func TestTimelinePlay(t *testing.T) {
+	tl, clock := newTestTimeline(t, 10*time.Second, 10*time.Second)
+
+	clock.Advance(time.Second)
+	assert.Equal(t, int64(0), tl.Timecode().Frame(), "shouldn't move until it's played")
+	assert.Equal(t, "stopped", tl.TransportStatus())
+
+	require.NoError(t, tl.Play())
+	assert.Equal(t, "play", tl.TransportStatus())
+	clock.Advance(time.Second)
+	assert.InDelta(t, frames(tl, time.Second), tl.Timecode().Frame(), 1, "should move with the clock")
+
+	require.NoError(t, tl.Stop())
+	stoppedAt := tl.Timecode().Frame()
+	clock.Advance(time.Second)
+	assert.Equal(t, stoppedAt, tl.Timecode().Frame(), "shouldn't move once it's stopped")
+	assert.Equal(t, "stopped", tl.TransportStatus())
+}
+
+func TestTimelineSpeed(t *testing.T) {
+	tl, clock := newTestTimeline(t, 10*time.Second)
+
+	require.NoError(t, tl.Play())
+	require.NoError(t, tl.SetSpeed(2))
+	assert.Equal(t, "forward", tl.TransportStatus())
+	assert.Equal(t, "200", tl.TransportSpeed())
+	clock.Advance(time.Second)
+	assert.InDelta(t, frames(tl, 2*time.Second), tl.Timecode().Frame(), 1, "should move twice as fast")
+
+	require.NoError(t, tl.SetSpeed(0.5))
+	clock.Advance(2 * time.Second)
+	assert.InDelta(t, frames(tl, 3*time.Second), tl.Timecode().Frame(), 1, "should move half as fast")
+}
+
+func TestTimelineEndOfClip(t *testing.T) {
+	tl, clock := newTestTimeline(t, time.Second, time.Second)
+
+	require.NoError(t, tl.Play())
+	clock.Advance(1500 * time.Millisecond)
+	assert.Equal(t, uint(2), tl.clipID, "should move on to the next clip")
+	assert.Equal(t, "play", tl.TransportStatus(), "should carry on playing")
+	assert.InDelta(t, frames(tl, 1500*time.Millisecond), tl.Timecode().Frame(), 2)
+
+	clock.Advance(time.Second)
+	assert.Equal(t, "stopped", tl.TransportStatus(), "should stop at the end of the timeline")
+	assert.True(t, tl.blanked, "should stop on black by default")
+}
+
+func TestTimelineEngineEndsFirst(t *testing.T) {
+	tl, clock := newTestTimeline(t)
+	short := testClip("short.mov", 10*time.Second)
+	short.media.Duration = time.Second // the engine finds it's shorter than it was probed as
+	require.NoError(t, tl.AddClip(short))
+	require.NoError(t, tl.AddClip(testClip("next.mov", 10*time.Second)))
+
+	require.NoError(t, tl.Play())
+	clock.Advance(1100 * time.Millisecond)
+	assert.Equal(t, uint(2), tl.clipID, "should move on as soon as the engine reaches the end, by the time the clock has")
+	assert.Equal(t, "play", tl.TransportStatus())
+}
+
+func TestTimelineLoop(t *testing.T) {
+	tl, clock := newTestTimeline(t, time.Second, time.Second)
+	tl.SetLoop(true)
+
+	require.NoError(t, tl.Play())
+	clock.Advance(2500 * time.Millisecond)
+	assert.Equal(t, uint(1), tl.clipID, "should go back round to the first clip")
+	assert.Equal(t, "play", tl.TransportStatus())
+	assert.InDelta(t, frames(tl, 500*time.Millisecond), tl.Timecode().Frame(), 3)
+
+	tl.singleClip = true
+	clock.Advance(time.Second)
+	assert.Equal(t, uint(1), tl.clipID, "should loop the one clip")
+	assert.Equal(t, "play", tl.TransportStatus())
+}
+
+func TestTimelineEndOfRange(t *testing.T) {
+	tl, clock := newTestTimeline(t, 10*time.Second)
+	require.NoError(t, tl.SetPlayRange(60, 120))
+
+	require.NoError(t, tl.Play())
+	assert.Equal(t, int64(60), tl.Timecode().Frame(), "should start at the start of the play range")
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, "stopped", tl.TransportStatus(), "should stop at the end of the play range")
+	assert.InDelta(t, 120, tl.Timecode().Frame(), 1)
+
+	tl.SetLoop(true)
+	require.NoError(t, tl.Play())
+	assert.Equal(t, int64(60), tl.Timecode().Frame(), "should go back to the start of the play range to play")
+	clock.Advance(1500 * time.Millisecond)
+	assert.Equal(t, "play", tl.TransportStatus(), "should loop the play range")
+	assert.InDelta(t, 60+frames(tl, 500*time.Millisecond), tl.Timecode().Frame(), 2)
+}
+
+func TestTimelineReverse(t *testing.T) {
+	tl, clock := newTestTimeline(t, time.Second, time.Second)
+	clipStart := tl.GetClipByID(2).Start.Frame()
+
+	require.NoError(t, tl.SeekFrame(clipStart+30))
+	require.NoError(t, tl.Play())
+	require.NoError(t, tl.SetSpeed(-1))
+	assert.Equal(t, "rewind", tl.TransportStatus())
+	assert.Equal(t, "-100", tl.TransportSpeed())
+
+	clock.Advance(250 * time.Millisecond)
+	assert.Equal(t, uint(2), tl.clipID)
+	assert.InDelta(t, clipStart+30-int64(frames(tl, 250*time.Millisecond)), tl.Timecode().Frame(), 1,
+		"should move backwards")
+
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, uint(1), tl.clipID, "should go back into the previous clip")
+	assert.Equal(t, "rewind", tl.TransportStatus(), "should carry on playing backwards")
+	assert.InDelta(t, clipStart+30-int64(frames(tl, 750*time.Millisecond)), tl.Timecode().Frame(), 2)
+
+	clock.Advance(time.Second)
+	assert.Equal(t, "stopped", tl.TransportStatus(), "should stop at the start of the timeline")
+	assert.Equal(t, int64(0), tl.Timecode().Frame())
+}
+
+func TestTimelineReverseLoop(t *testing.T) {
+	tl, clock := newTestTimeline(t, time.Second, time.Second)
+	tl.SetLoop(true)
+
+	require.NoError(t, tl.SeekFrame(30))
+	require.NoError(t, tl.Play())
+	require.NoError(t, tl.SetSpeed(-1))
+	clock.Advance(750 * time.Millisecond)
+	assert.Equal(t, uint(2), tl.clipID, "should go round to the end of the last clip")
+	assert.Equal(t, "rewind", tl.TransportStatus())
+	assert.InDelta(t, tl.Duration()-int64(frames(tl, 250*time.Millisecond)), tl.Timecode().Frame(), 2)
+}
+
+// notificationLog collects the notifications the deck sends with a code
+type notificationLog struct {
+	sync.Mutex
+	code string
+	msgs []string
+}
+
+func (n *notificationLog) watch(msg string) {
+	if !strings.HasPrefix(msg, n.code+" ") {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	n.msgs = append(n.msgs, msg)
+}
+
+// take returns what's been collected, and starts again
+func (n *notificationLog) take() []string {
+	n.Lock()
+	defer n.Unlock()
+	msgs := n.msgs
+	n.msgs = nil
+	return msgs
+}
+
+func TestPositionNotifications(t *testing.T) {
+	d := newTestDeck(t)
+	require.NoError(t, d.timeline.AddClip(testClip("a.mov", 10*time.Second)))
+	positions := &notificationLog{code: "514"}
+	timecodes := &notificationLog{code: "513"}
+	d.server.Watch(positions.watch)
+	d.server.Watch(timecodes.watch)
+	assert.Equal(t, "200 ok", command(d, "notify", map[string]string{"timeline position": "true", "display timecode": "true"}))
+
+	assert.Equal(t, "200 ok", command(d, "play", nil))
+	d.fake.Advance(time.Second)
+	sent := positions.take()
+	assert.InDelta(t, frames(d.timeline, time.Second), len(sent), 1, "should notify the position once a frame")
+	assert.Equal(t, len(sent), len(timecodes.take()), "should notify the timecode with each position")
+	for idx, msg := range sent {
+		assert.Equal(t, fmt.Sprintf("514 timeline position:\r\ntimeline: %v\r\n", idx+1), msg,
+			"should notify each frame in turn")
+	}
+
+	assert.Equal(t, "200 ok", command(d, "play", map[string]string{"speed": "200"}))
+	d.fake.Advance(time.Second)
+	assert.InDelta(t, frames(d.timeline, time.Second), len(positions.take()), 1,
+		"should notify once a frame at any speed")
+
+	assert.Equal(t, "200 ok", command(d, "stop", nil))
+	d.fake.Advance(100 * time.Millisecond) // for the tick that reports where it stopped
+	positions.take()
+	timecodes.take()
+	d.fake.Advance(time.Second)
+	assert.Empty(t, positions.take(), "shouldn't notify while stopped")
+	assert.Empty(t, timecodes.take())
+}
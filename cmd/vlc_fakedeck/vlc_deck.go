@@ -1,91 +1,202 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	vlc "github.com/adrg/libvlc-go/v3"
-	"github.com/gotk3/gotk3/gdk"
-	"github.com/gotk3/gotk3/glib"
-	"github.com/gotk3/gotk3/gtk"
 	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/josh23french/fakedeck/pkg/ftp"
+	"github.com/josh23french/fakedeck/pkg/mdns"
 	"github.com/josh23french/fakedeck/pkg/protocol"
 	"github.com/rs/zerolog/log"
 	"trimmer.io/go-timecode/timecode"
 )
 
-// #cgo LDFLAGS: -lX11
-// #include <stdlib.h>
-// #include <X11/Xlib.h>
-import "C"
-
 // State is the ephemeral state of the deck
 type State struct {
-	loop       bool // Are we in a looping mode?
-	singleClip bool // Are we in single clip mode?
-	slotID     uint // 1-indexed slot ID; 0 means "none"
+	slotID uint // 1-indexed slot ID; 0 means "none"
+}
+
+// formatRequest is a format that's been prepared, waiting to be confirmed with its token
+type formatRequest struct {
+	token      string
+	slotID     uint
+	filesystem string
+	volumeName string
 }
 
 type VLCDeck struct {
-	app      *gtk.Application
+	config   Config
+	output   Output // where the picture goes
 	timeline *TimelinePlayer
-	player   *vlc.Player
+	engine   PlaybackEngine
 	server   *deck.Server
+	ftp      *ftp.Server       // nil if FTP is disabled
+	http     *http.Server      // REST API; nil if it's disabled
+	events   *eventHub         // pushes notifications to the REST API's WebSocket subscribers
+	mdns     *mdns.Responder   // nil if mDNS is disabled
+	adminSrv *deck.AdminServer // admin commands on their own port; nil if it's disabled
+	commands *deck.Registry
+	admin    *deck.Registry // commands for the fake deck itself, on the admin port or with an "admin " prefix if allowed
+	stats    *deck.Stats
+	cache    *deck.Cache
+	clock    deck.Clock      // what the deck keeps time by
+	fake     *deck.FakeClock // the same clock, if it only moves when told to with admin clock advance; nil otherwise
+	faults   *deck.Faults    // what to do wrong on purpose, set with the admin faults command
 	notify   deck.NotifyFlags
+	remote   deck.RemoteFlags
 	state    State
 	slots    []*Slot
-	rate     timecode.Rate
-}
+	input    *InputSource // nil if there's no input; guarded by commandLock, as admin input changes it
 
-const appID string = "com.jafrench.fakedeck.vlc_fakedeck"
+	commandLock sync.Mutex // commands come in over TCP and REST at the same time, so they take turns
 
-func VLCDeckNew() *VLCDeck {
-	C.XInitThreads()
+	pendingFormat *formatRequest // nil until a format is prepared
+	identifyUntil time.Time      // when the deck stops identifying, by its clock
+	cacheStatus   string         // last cache status notified
+	cacheLock     sync.Mutex     // guards cacheStatus, since the cache is checked on a timer too
+
+	audioChannels int              // configured audio input channels
+	meter         *audioMeter      // guarded by audioLock, since it's run on a timer
+	audioLevels   deck.AudioLevels // last levels measured; guarded by audioLock
+	streamLevels  bool             // send 591 audio levels notifications as levels are measured
+	audioLock     sync.Mutex
+
+	dynamicRangeOverride string // playback dynamic range override; empty if off
+	dynamicRange         string // last dynamic range notified
+	rate                 timecode.Rate
+}
 
-	app, err := gtk.ApplicationNew(appID, glib.APPLICATION_FLAGS_NONE)
+func VLCDeckNew(config Config) *VLCDeck {
+	output, err := NewOutput(config)
 	if err != nil {
-		log.Fatal().Err(err).Msg("error initializing GTK Application")
+		log.Fatal().Err(err).Msg("error creating output")
 	}
 
-	// Initialize libVLC
-	if err := vlc.Init("--quiet"); err != nil {
-		log.Fatal().Err(err).Msg("error initializing VLC")
+	// Initialize libVLC, which only the vlc engine needs
+	if config.Engine == "vlc" {
+		if err := vlc.Init(append([]string{"--quiet"}, output.Options()...)...); err != nil {
+			log.Fatal().Err(err).Msg("error initializing VLC")
+		}
 	}
 
-	player, err := vlc.NewPlayer()
+	probe, err := NewClipProbe(config.Engine)
 	if err != nil {
-		log.Fatal().Err(err).Msg("error getting Player from ListPlayer")
+		if config.Engine != "sim" {
+			log.Fatal().Err(err).Msg("can't probe clips")
+		}
+		// the sim engine's for tests, which can make their clips up
+		log.Warn().Err(err).Msg("can't probe clips; any in the slots will be left out")
 	}
 
-	// Create slots
-	basePath := "/home/playout/slots/" // could come from os.Args or flags
+	// Create a slot for each numbered directory; slot 1 has to be there, and any more are optional
+	basePath := config.SlotsPath
 	slots := make([]*Slot, 0)
-	for slotID := uint(1); slotID <= 1; slotID++ {
-		slot, err := NewSlot(filepath.Join(basePath, fmt.Sprintf("%v/", slotID)))
+	for slotID := uint(1); ; slotID++ {
+		path := filepath.Join(basePath, fmt.Sprintf("%v/", slotID))
+		if _, err := os.Stat(path); err != nil && slotID > 1 {
+			break
+		}
+		slot, err := NewSlot(path, probe)
 		if err != nil {
 			log.Fatal().Err(err).Msg("error making slot")
 		}
 		slots = append(slots, slot)
 	}
 
+	var input *InputSource
+	if config.Input != "" {
+		input, err = NewInputSource(config.Input, config.InputFormat)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error creating input source")
+		}
+	}
+
+	if !isAudioInputChannels(config.AudioChannels) {
+		log.Fatal().Msgf("unsupported audio channels %v; must be one of %v", config.AudioChannels, deck.AudioInputChannels)
+	}
+
 	rate := timecode.Rate60DF
 
+	var clock deck.Clock = deck.RealClock{}
+	var fake *deck.FakeClock
+	if config.FakeClock {
+		fake = deck.NewFakeClock(time.Now())
+		clock = fake
+	}
+
+	engine, err := NewPlaybackEngine(config, clock)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error creating playback engine")
+	}
+
 	d := &VLCDeck{
-		app:      app,
-		timeline: NewTimelinePlayer(player, rate),
-		player:   player,
+		config:   config,
+		output:   output,
+		timeline: NewTimelinePlayer(engine, rate, clock),
+		engine:   engine,
 		server:   nil,
+		commands: deck.NewRegistry(),
+		admin:    deck.NewAdminRegistry(),
+		stats:    deck.NewStats(),
+		clock:    clock,
+		fake:     fake,
+		cache:    deck.NewCache(config.CacheSize*1e6, config.CacheFillRate*1e6, config.CacheDrainRate*1e6),
 		notify:   deck.NotifyFlags{},
+		remote: deck.RemoteFlags{
+			Enabled: true,
+		},
 		state: State{
 			slotID: 1, // gotta at least have one
 		},
-		slots: slots,
-		rate:  rate,
+		slots:         slots,
+		input:         input,
+		audioChannels: config.AudioChannels,
+		meter:         newAudioMeter(config.AudioChannels),
+		audioLevels:   deck.Silence(config.AudioChannels),
+		rate:          rate,
+	}
+	err = d.timeline.SetAudioChannels(config.AudioChannels)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error routing audio channels")
+	}
+	d.faults = deck.NewFaults(time.Now().UnixNano())
+	d.server = deck.NewServer(deck.WithFaults(d, d.faults, func() int { return int(d.state.slotID) }))
+	d.server.SetFaults(d.faults)
+	d.events = newEventHub(d.restProperties(), &d.commandLock)
+	d.server.Watch(d.events.Publish)
+	if config.MDNS {
+		d.mdns = d.newResponder()
 	}
-	d.server = deck.NewServer(d)
+	if config.FTPAddr != "" {
+		d.ftp = ftp.NewServer(config.FTPAddr, d.ftpFolders)
+	}
+	for idx, slot := range slots {
+		slotID := uint(idx + 1)
+		slot.OnChange(func() {
+			d.sendAsync(d.marshallSlotInfo("502 slot info", slotID), d.notify.Slot)
+		})
+	}
+	d.registerCommands()
+	d.registerAdminCommands()
+	d.registerWorldCommands()
+	if config.AdminAddr != "" {
+		d.adminSrv = deck.NewAdminServer(config.AdminAddr, d.processAdmin, d.admin.Keys)
+	}
+	d.commands.RequireRemote(&d.remote)
 	slot, err := d.CurrentSlot()
 	if err != nil {
 		log.Fatal().Err(err).Msg("error getting current slot")
@@ -98,25 +209,17 @@ func VLCDeckNew() *VLCDeck {
 		}
 	}
 
-	app.Connect("activate", d.onActivate)
-	app.Connect("shutdown", func() {
-		glib.IdleAdd(d.PowerOff)
-	})
-
-	// VLC Events
-	// Player
-	eventManager, err := d.player.EventManager()
-	if err != nil {
-		log.Fatal().Err(err).Msg("error getting event manager")
-	}
-
-	eventID, err := eventManager.Attach(vlc.MediaPlayerTimeChanged, d.vlcEventHandler, nil)
-	if err != nil {
-		log.Fatal().Err(err).Msg("error attaching event")
-	}
-	log.Info().Msgf("attached event: %v", eventID)
-
+	d.timeline.onTick = d.onTimelineTick
+	d.timeline.lock = &d.commandLock
 	d.timeline.server = d.server
+	d.timeline.notify = &d.notify
+	d.timeline.stats = d.stats
+	d.stats.SetClock(d.clock)
+	d.server.SetClock(d.clock)
+	d.server.SetStats(d.stats)
+	d.server.SetAddr(config.Addr)
+	d.server.SetGrammar(d.grammar)
+	d.server.SetTranscriptDir(config.TranscriptsPath)
 
 	return d
 }
@@ -128,27 +231,39 @@ func (d *VLCDeck) CurrentSlot() (*Slot, error) {
 	return d.slots[d.state.slotID-1], nil
 }
 
-func (d *VLCDeck) onActivate() {
-	appWin, err := gtk.ApplicationWindowNew(d.app)
-	if err != nil {
-		log.Fatal().Err(err).Msg("error initializing GTK ApplicationWindow")
+// newResponder advertises the deck the way real HyperDecks do, so switchers and apps can find it
+func (d *VLCDeck) newResponder() *mdns.Responder {
+	text := []string{
+		"txtvers=1",
+		"name=" + d.config.Name,
+		"class=HyperDeck",
+		"model=" + d.GetModel(),
+		"protocol version=" + d.GetProtocol(),
+		"unique id=" + d.config.UniqueID,
 	}
-	area, err := gtk.DrawingAreaNew()
-	appWin.Add(area)
-	appWin.ShowAll()
-	d.app.AddWindow(appWin)
-
-	win, err := area.ToWidget().GetWindow()
-	if err != nil {
-		log.Fatal().Err(err).Msg("error getting appWin gdk.Window")
+	port := 9993
+	if _, p, err := net.SplitHostPort(d.config.Addr); err == nil {
+		if n, err := strconv.Atoi(p); err == nil && n > 0 {
+			port = n
+		}
 	}
-	d.player.SetXWindow(win.GetXID())
-
-	appWin.Fullscreen()
+	host, instance := mdns.UniqueNames(d.config.Name, d.config.UniqueID)
+	return mdns.NewResponder(host, mdns.LocalIPs(),
+		mdns.Service{Instance: instance, Type: "_hyperdeck_ctrl._tcp", Port: port, Text: text},
+		mdns.Service{Instance: instance, Type: "_blackmagic._tcp", Port: port, Text: text},
+	)
+}
 
-	display, _ := appWin.GetDisplay()
-	blankCursor, _ := gdk.CursorNewFromName(display, "none")
-	win.SetCursor(blankCursor)
+// ftpFolders serves the folder of each slot with a disk in it, named by its slot ID
+func (d *VLCDeck) ftpFolders() map[string]string {
+	folders := make(map[string]string)
+	for idx, slot := range d.slots {
+		if !slot.Mounted() {
+			continue
+		}
+		folders[strconv.Itoa(idx+1)] = slot.Path()
+	}
+	return folders
 }
 
 func (d *VLCDeck) GetModel() string {
@@ -160,232 +275,1000 @@ func (d *VLCDeck) GetProtocol() string {
 }
 
 func (d *VLCDeck) ProcessCommand(cmd *protocol.Command) string {
-	switch cmd.Name {
-	case "help":
-		return `201 help:
-no help.
-lol
-`
-	case "notify":
-		for param, valStr := range cmd.Parameters {
-			var valBool bool
-			switch valStr {
-			case "true":
-				valBool = true
-			case "false":
-				valBool = false
-			default:
-				return protocol.ErrOutOfRange
-			}
+	d.commandLock.Lock()
+	defer d.commandLock.Unlock()
 
-			switch param {
-			case "transport":
-				d.notify.Transport = valBool
-			case "slot":
-				d.notify.Slot = valBool
-			case "remote":
-				d.notify.Remote = valBool
-			case "configuration":
-				d.notify.Configuration = valBool
-			case "dropped frames":
-				d.notify.DroppedFrames = valBool
-			case "display timecode":
-				d.notify.DisplayTimecode = valBool
-			case "timeline position":
-				d.notify.TimelinePosition = valBool
-			case "playrange":
-				d.notify.PlayRange = valBool
-			case "cache":
-				d.notify.Cache = valBool
-			case "dynamic range":
-				d.notify.DynamicRange = valBool
-			default:
-				return protocol.ErrUnsupportedParameter
-			}
+	if d.config.ProtocolAdmin && strings.HasPrefix(cmd.Name, deck.AdminPrefix) {
+		return d.admin.Process(&protocol.Command{
+			Name:       strings.TrimPrefix(cmd.Name, deck.AdminPrefix),
+			Parameters: cmd.Parameters,
+		})
+	}
+
+	if deck.DiskCommands[cmd.Name] {
+		if slot, err := d.CurrentSlot(); err == nil && !slot.Mounted() {
+			return protocol.ErrNoDisk
 		}
+	}
 
-		return "200 ok"
-	case "play":
-		// if player isn't playing, can't set speed... will have to deal with slight hiccups :(
-		err := d.timeline.Play()
+	res := d.commands.Process(cmd)
+	if res == protocol.ErrUnsupported {
+		log.Warn().Msgf("unsupported command: %v", cmd)
+	}
+
+	// any transport command could have started or stopped a recording
+	d.cache.SetRecording(d.clock.Now(), d.timeline.Recording())
+	d.checkCache()
+	return res
+}
+
+// grammar knows the parameters of the deck's commands, and of the admin ones behind their prefix if they're taken
+func (d *VLCDeck) grammar(name string) []string {
+	if d.config.ProtocolAdmin && strings.HasPrefix(name, deck.AdminPrefix) {
+		return d.admin.Keys(strings.TrimPrefix(name, deck.AdminPrefix))
+	}
+	return d.commands.Keys(name)
+}
+
+func (d *VLCDeck) registerCommands() {
+	slotID := protocol.IntRange(1, int64(len(d.slots)))
+
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "notify",
+		Description: "set notifications",
+		Parameters: []protocol.Parameter{
+			{Name: "transport", Type: protocol.Bool},
+			{Name: "slot", Type: protocol.Bool},
+			{Name: "remote", Type: protocol.Bool},
+			{Name: "configuration", Type: protocol.Bool},
+			{Name: "dropped frames", Type: protocol.Bool},
+			{Name: "display timecode", Type: protocol.Bool},
+			{Name: "timeline position", Type: protocol.Bool},
+			{Name: "playrange", Type: protocol.Bool},
+			{Name: "cache", Type: protocol.Bool},
+			{Name: "dynamic range", Type: protocol.Bool},
+		},
+		Handler: d.setNotify,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "play",
+		Description: "play from current timecode",
+		Parameters: []protocol.Parameter{
+			{Name: "speed", Type: protocol.IntRange(-1600, 1600)},
+			{Name: "loop", Type: protocol.Bool},
+			{Name: "single clip", Type: protocol.Bool},
+		},
+		Handler: d.play,
+		Remote:  true,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "stop",
+		Description: "stop playback or recording",
+		Handler:     d.stop,
+		Remote:      true,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "remote",
+		Description: "query or set unit remote control state",
+		Parameters: []protocol.Parameter{
+			{Name: "enable", Type: protocol.Bool},
+			{Name: "override", Type: protocol.Bool},
+		},
+		Handler: d.setRemote,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "record",
+		Description: "record from current input (simulated; nothing is written)",
+		Parameters:  []protocol.Parameter{{Name: "name"}},
+		Handler:     d.record,
+		Remote:      true,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "cache info",
+		Description: "query record cache status",
+		Handler:     d.cacheInfo,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "preview",
+		Description: "switch to preview or output",
+		Parameters:  []protocol.Parameter{{Name: "enable", Type: protocol.Bool}},
+		Handler:     d.preview,
+		Remote:      true,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "clips count",
+		Description: "query number of clips on timeline",
+		Handler:     d.clipsCount,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "disk list",
+		Description: "query clip list on active disk",
+		Parameters:  []protocol.Parameter{{Name: "slot id", Type: slotID}},
+		Handler:     d.diskList,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "clips get",
+		Description: "query all timeline clips",
+		Handler:     d.clipsGet,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "goto",
+		Description: "goto clip id {n}, or forward/backward {n} clips",
+		Parameters: []protocol.Parameter{
+			{Name: "clip id", Type: protocol.AnyOf(protocol.IntRange(1, math.MaxUint16), protocol.Relative(math.MaxUint16))},
+		},
+		Handler: d.goTo,
+		Remote:  true,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "playrange",
+		Description: "query playrange setting",
+		Handler:     d.playRange,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "playrange set",
+		Description: "set play range to {m} clips from clip {n}, timecode {inT} to {outT}, or timeline frames {in} to {out}",
+		Parameters: []protocol.Parameter{
+			{Name: "clip id", Type: protocol.IntRange(1, math.MaxUint16)},
+			{Name: "count", Type: protocol.IntRange(1, math.MaxUint16)},
+			{Name: "in", Type: protocol.Timecode},
+			{Name: "out", Type: protocol.Timecode},
+			{Name: "timeline in", Type: protocol.IntRange(0, math.MaxInt32)},
+			{Name: "timeline out", Type: protocol.IntRange(0, math.MaxInt32)},
+		},
+		Handler: d.playRangeSet,
+		Remote:  true,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "playrange clear",
+		Description: "clear/reset play range setting",
+		Handler:     d.playRangeClear,
+		Remote:      true,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "slot info",
+		Description: "query slot status",
+		Parameters:  []protocol.Parameter{{Name: "slot id", Type: slotID}},
+		Handler:     d.slotInfo,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "slot select",
+		Description: "switch to slot {n}",
+		Parameters:  []protocol.Parameter{{Name: "slot id", Type: slotID}},
+		Handler:     d.slotSelect,
+		Remote:      true,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "format",
+		Description: "prepare a disk for formatting (returns a token), or perform the format with that token",
+		Parameters: []protocol.Parameter{
+			{Name: "slot id", Type: slotID},
+			{Name: "prepare", Type: protocol.Filesystem},
+			{Name: "name"},
+			{Name: "confirm"},
+		},
+		Handler: d.format,
+		Remote:  true,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "identify",
+		Description: "identify the device for {n} seconds (10 by default)",
+		Parameters: []protocol.Parameter{
+			{Name: "enable", Type: protocol.Bool},
+			{Name: "duration", Type: protocol.IntRange(1, 3600)},
+		},
+		Handler: d.setIdentify,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "dynamic range",
+		Description: "query dynamic range, or override it for playback",
+		Parameters: []protocol.Parameter{
+			{Name: "playback override", Type: protocol.Enum("off",
+				deck.DynamicRangeRec709, deck.DynamicRangeRec2020SDR, deck.DynamicRangeHLG,
+				deck.DynamicRangePQ300, deck.DynamicRangePQ500, deck.DynamicRangePQ800,
+				deck.DynamicRangePQ1000, deck.DynamicRangePQ2000, deck.DynamicRangePQ4000)},
+		},
+		Handler: d.setDynamicRange,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "configuration",
+		Description: "query or change configuration",
+		Parameters: []protocol.Parameter{
+			{Name: "audio input channels", Type: protocol.Enum(deck.AudioInputChannels...)},
+		},
+		Handler: d.configuration,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "uptime",
+		Description: "return time since last boot",
+		Handler:     d.uptime,
+	})
+	d.commands.Register(&deck.CommandSpec{
+		Name:        "transport info",
+		Description: "query current activity",
+		Handler:     d.transportInfo,
+	})
+}
+
+func (d *VLCDeck) setNotify(params map[string]string) string {
+	if len(params) == 0 {
+		return "209 notify:\r\n" + strings.Join(d.notify.Marshall(), "\r\n") + "\r\n"
+	}
+	for param, valStr := range params {
+		valBool := valStr == "true"
+
+		switch param {
+		case "transport":
+			d.notify.Transport = valBool
+		case "slot":
+			d.notify.Slot = valBool
+		case "remote":
+			d.notify.Remote = valBool
+		case "configuration":
+			d.notify.Configuration = valBool
+		case "dropped frames":
+			d.notify.DroppedFrames = valBool
+		case "display timecode":
+			d.notify.DisplayTimecode = valBool
+		case "timeline position":
+			d.notify.TimelinePosition = valBool
+		case "playrange":
+			d.notify.PlayRange = valBool
+		case "cache":
+			d.notify.Cache = valBool
+		case "dynamic range":
+			d.notify.DynamicRange = valBool
+		}
+	}
+
+	return "200 ok"
+}
+
+func (d *VLCDeck) play(params map[string]string) string {
+	if d.timeline.Count() == 0 {
+		return protocol.ErrTimelineEmpty
+	}
+
+	// Speed
+	speedFloat := float32(1)
+	if speedStr, ok := params["speed"]; ok {
+		speed, _ := strconv.ParseInt(speedStr, 10, 0)
+		if _, ok := d.engine.(ReversibleEngine); speed < 0 && !ok {
+			// VLC does not support playing backwards
+			return protocol.ErrOutOfRange
+		}
+		// speedFloat should be between -16 and 16 now
+		speedFloat = float32(speed) / 100.0
+	}
+
+	// Single Clip
+	if singleClip, ok := params["single clip"]; ok {
+		d.timeline.singleClip = singleClip == "true"
+	}
+
+	// Looping
+	if loop, ok := params["loop"]; ok {
+		d.timeline.SetLoop(loop == "true")
+	}
+
+	if speedFloat == 0 {
+		err := d.timeline.Stop()
 		if err != nil {
-			log.Error().Err(err).Msg("error playing player")
+			log.Error().Err(err).Msg("error setting playback rate 0/stop")
 			return protocol.ErrInternal
 		}
+		return "200 ok"
+	}
 
-		// Single Clip
-		if singleClip, ok := cmd.Parameters["singleClip"]; ok {
-			singleClipBool, err := strconv.ParseBool(singleClip)
-			if err != nil {
-				return protocol.ErrOutOfRange
-			}
-			d.timeline.singleClip = singleClipBool
+	// if player isn't playing, can't set speed... will have to deal with slight hiccups :(
+	err := d.timeline.Play()
+	if err != nil {
+		log.Error().Err(err).Msg("error playing player")
+		return protocol.ErrInternal
+	}
+
+	err = d.timeline.SetSpeed(float64(speedFloat))
+	if err != nil {
+		log.Error().Err(err).Msgf("error setting playback rate %v", speedFloat)
+		return protocol.ErrInternal
+	}
+
+	return "200 ok"
+}
+
+func (d *VLCDeck) stop(params map[string]string) string {
+	err := d.timeline.Stop()
+	if err != nil {
+		log.Error().Err(err).Msg("error pausing player")
+		return protocol.ErrInternal
+	}
+	return "200 ok"
+}
+
+func (d *VLCDeck) setRemote(params map[string]string) string {
+	if len(params) == 0 {
+		return "210 remote info:\r\n" + strings.Join(d.remote.Marshall(), "\r\n") + "\r\n"
+	}
+
+	previous := d.remote
+	if enable, ok := params["enable"]; ok {
+		d.remote.Enabled = enable == "true"
+	}
+	if override, ok := params["override"]; ok {
+		d.remote.Override = override == "true"
+	}
+
+	if d.remote != previous {
+		d.sendAsync("510 remote info:\r\n"+strings.Join(d.remote.Marshall(), "\r\n")+"\r\n", d.notify.Remote)
+	}
+	return "200 ok"
+}
+
+func (d *VLCDeck) record(params map[string]string) string {
+	if d.input == nil {
+		return protocol.ErrNoInput
+	}
+	if d.state.slotID == 0 {
+		return protocol.ErrNoDisk
+	}
+	if free, err := d.slots[d.state.slotID-1].FreeSpace(); err == nil && free == 0 {
+		return protocol.ErrDiskFull
+	}
+	err := d.timeline.Record(d.input.Media())
+	if err != nil {
+		log.Error().Err(err).Msg("error starting recording")
+		return protocol.ErrInternal
+	}
+	return "200 ok"
+}
+
+func (d *VLCDeck) cacheInfo(params map[string]string) string {
+	return "221 cache info:\r\n" + d.marshallCache()
+}
+
+func (d *VLCDeck) marshallCache() string {
+	now := d.clock.Now()
+	return strings.Join(d.cache.Marshall(now, d.recordingTimeRemaining(now)), "\r\n") + "\r\n"
+}
+
+// recordingTimeRemaining is how long a recording could go on before the cache or the disk fills up
+func (d *VLCDeck) recordingTimeRemaining(now time.Time) time.Duration {
+	slot, err := d.CurrentSlot()
+	if err != nil || d.cache.FillRate <= 0 {
+		return 0
+	}
+	free, err := slot.FreeSpace()
+	if err != nil {
+		log.Warn().Err(err).Msg("error getting free space on current slot")
+		return 0
+	}
+	remaining := time.Duration(float64(free) / d.cache.FillRate * float64(time.Second))
+	if untilFull, ok := d.cache.TimeUntilFull(now); ok && untilFull < remaining {
+		remaining = untilFull
+	}
+	return remaining
+}
+
+// checkCache sends a 516 cache info notification if the cache status has changed
+func (d *VLCDeck) checkCache() {
+	d.cacheLock.Lock()
+	defer d.cacheLock.Unlock()
+	status := d.cache.Status(d.clock.Now())
+	if status == d.cacheStatus {
+		return
+	}
+	d.cacheStatus = status
+	d.sendAsync("516 cache info:\r\n"+d.marshallCache(), d.notify.Cache)
+}
+
+// watchCache keeps checking the cache, since it fills and drains on its own
+func (d *VLCDeck) watchCache() {
+	ticker := d.clock.NewTicker(time.Second)
+	for range ticker.C() {
+		d.checkCache()
+	}
+}
+
+func (d *VLCDeck) preview(params map[string]string) string {
+	enable, ok := params["enable"]
+	if !ok {
+		return protocol.ErrSyntax
+	}
+
+	if enable == "true" {
+		if d.input == nil {
+			return protocol.ErrNoInput
+		}
+		err := d.timeline.Preview(d.input.Media())
+		if err != nil {
+			log.Error().Err(err).Msg("error switching to preview")
+			return protocol.ErrInternal
 		}
+		return "200 ok"
+	}
 
-		// Looping
-		if loop, ok := cmd.Parameters["loop"]; ok {
-			loopBool, err := strconv.ParseBool(loop)
-			if err != nil {
-				return protocol.ErrOutOfRange
-			}
-			d.timeline.SetLoop(loopBool)
+	if d.timeline.Previewing() {
+		err := d.timeline.EndPreview()
+		if err != nil {
+			log.Error().Err(err).Msg("error switching back to output")
+			return protocol.ErrInternal
 		}
+	}
+	return "200 ok"
+}
 
-		// Speed
-		if speedStr, ok := cmd.Parameters["speed"]; ok {
-			// Convert to int64
-			speed, err := strconv.ParseInt(speedStr, 10, 0)
-			if err != nil {
-				log.Error().Err(err).Msg("error converting play: speed parameter to int")
-				return protocol.ErrSyntax
-			}
+func (d *VLCDeck) clipsCount(params map[string]string) string {
+	return fmt.Sprintf("214 clips count:\r\nclip count: %v\r\n", d.timeline.Count())
+}
 
-			// Check param for range...
-			if speed < 0 || speed > 1600 {
-				// VLC does not support playing backwards
-				return protocol.ErrOutOfRange
-			}
+func (d *VLCDeck) goTo(params map[string]string) string {
+	clipIDStr, ok := params["clip id"]
+	if !ok {
+		return protocol.ErrSyntax
+	}
 
-			// speedFloat should be between 0 and 16 now
-			speedFloat := float32(speed) / 100.0
+	if clipIDStr[0] == '+' || clipIDStr[0] == '-' {
+		// relative
+		offset, _ := strconv.ParseUint(clipIDStr[1:], 10, 0)
 
-			if speedFloat == 0 {
-				err := d.timeline.Stop()
+		if clipIDStr[0] == '+' {
+			for n := uint64(0); n < offset; n++ {
+				err := d.timeline.Next()
 				if err != nil {
-					log.Error().Err(err).Msg("error setting playback rate 0/stop")
-					return protocol.ErrInternal
+					log.Error().Err(err).Msg("error going through clips to get to offset")
+					return protocol.ErrOutOfRange
 				}
-			} else {
-				err = d.player.SetPlaybackRate(speedFloat)
+			}
+		} else {
+			for n := uint64(0); n < offset; n++ {
+				err := d.timeline.Previous()
 				if err != nil {
-					log.Error().Err(err).Msgf("error setting playback rate %v", speedFloat)
-					return protocol.ErrInternal
+					log.Error().Err(err).Msg("error going through clips to get to offset")
+					return protocol.ErrOutOfRange
 				}
 			}
 		}
-
 		return "200 ok"
-	case "stop":
-		err := d.timeline.Stop()
+	}
+
+	// absolute
+	clipID, _ := strconv.ParseUint(clipIDStr, 10, 0)
+	if int(clipID) > d.timeline.Count() {
+		return protocol.ErrOutOfRange
+	}
+	err := d.timeline.PlayClip(uint(clipID))
+	if err != nil {
+		log.Error().Err(err).Msgf("error playing clip id %v", clipID)
+		return protocol.ErrInternal
+	}
+	return "200 ok"
+}
+
+func (d *VLCDeck) playRange(params map[string]string) string {
+	return "215 playrange info:\r\n" + d.marshallPlayRange()
+}
+
+func (d *VLCDeck) playRangeSet(params map[string]string) string {
+	var in, out int64
+	_, hasClipID := params["clip id"]
+	_, hasCount := params["count"]
+	_, hasIn := params["in"]
+	_, hasTimelineIn := params["timeline in"]
+
+	switch {
+	case hasClipID && (len(params) == 1 || (len(params) == 2 && hasCount)):
+		clipID, _ := strconv.ParseUint(params["clip id"], 10, 0)
+		count := uint64(1)
+		if countStr, ok := params["count"]; ok {
+			count, _ = strconv.ParseUint(countStr, 10, 0)
+		}
+		var err error
+		in, out, err = d.timeline.ClipRange(uint(clipID), uint(count))
 		if err != nil {
-			log.Error().Err(err).Msg("error pausing player")
-			return protocol.ErrInternal
+			return protocol.ErrOutOfRange
 		}
+	case hasIn && len(params) == 2 && params["out"] != "":
+		in = d.timeline.ParseTimecode(params["in"])
+		out = d.timeline.ParseTimecode(params["out"])
+	case hasTimelineIn && len(params) == 2 && params["timeline out"] != "":
+		in, _ = strconv.ParseInt(params["timeline in"], 10, 64)
+		out, _ = strconv.ParseInt(params["timeline out"], 10, 64)
+	default:
+		return protocol.ErrSyntax
+	}
+
+	err := d.timeline.SetPlayRange(in, out)
+	if err != nil {
+		return protocol.ErrOutOfRange
+	}
+	d.sendAsyncPlayRange()
+	return "200 ok"
+}
+
+func (d *VLCDeck) playRangeClear(params map[string]string) string {
+	d.timeline.ClearPlayRange()
+	d.sendAsyncPlayRange()
+	return "200 ok"
+}
+
+// marshallPlayRange returns the play range lines of 215/515 responses; there are none if there's no play range
+func (d *VLCDeck) marshallPlayRange() string {
+	in, out, ok := d.timeline.PlayRange()
+	if !ok {
+		return ""
+	}
+	playRange := deck.PlayRange{
+		In:          deck.Timecode(d.timeline.FramesToTimecode(in).String()),
+		Out:         deck.Timecode(d.timeline.FramesToTimecode(out).String()),
+		TimelineIn:  in,
+		TimelineOut: out,
+	}
+	return strings.Join(playRange.Marshall(), "\r\n") + "\r\n"
+}
+
+func (d *VLCDeck) sendAsyncPlayRange() {
+	d.sendAsync("515 playrange info:\r\n"+d.marshallPlayRange(), d.notify.PlayRange)
+}
+
+func (d *VLCDeck) slotInfo(params map[string]string) string {
+	slotID := d.state.slotID
+	if slotStr, ok := params["slot id"]; ok {
+		slot, _ := strconv.ParseUint(slotStr, 10, 0)
+		slotID = uint(slot)
+	}
+	if slotID == 0 {
+		return protocol.ErrNoDisk
+	}
+	return d.marshallSlotInfo("202 slot info", slotID)
+}
+
+// slotSelect makes another slot current, with the timeline made of the clips on its disk
+func (d *VLCDeck) slotSelect(params map[string]string) string {
+	slotStr, ok := params["slot id"]
+	if !ok {
+		return protocol.ErrSyntax
+	}
+	slotID, _ := strconv.ParseUint(slotStr, 10, 0)
+	if uint(slotID) == d.state.slotID {
 		return "200 ok"
-	case "remote":
-		return "210 remote info:\r\nenabled: true\r\noverride: false\r\n"
-	case "clips count":
-		return fmt.Sprintf("214 clips count:\r\nclip count: %v\r\n", d.timeline.Count())
-	case "disk list":
-		return d.diskList(cmd.Parameters)
-	case "clips get":
-		return d.clipsGet(cmd.Parameters)
-	case "goto":
-		if clipIDStr, ok := cmd.Parameters["clip id"]; ok {
-			if clipIDStr[0] == '+' || clipIDStr[0] == '-' {
-				// relative
-				offset, err := strconv.ParseUint(clipIDStr[1:], 10, 0)
-				if err != nil {
-					log.Error().Err(err).Msg("error parsing clip id")
-					return protocol.ErrSyntax
-				}
+	}
+	d.state.slotID = uint(slotID)
 
-				if clipIDStr[0] == '+' {
-					for n := uint64(0); n < offset; n++ {
-						err := d.timeline.Next()
-						if err != nil {
-							log.Error().Err(err).Msg("error going through clips to get to offset")
-							return protocol.ErrOutOfRange
-						}
-					}
-				} else {
-					for n := uint64(0); n < offset; n++ {
-						err := d.timeline.Previous()
-						if err != nil {
-							log.Error().Err(err).Msg("error going through clips to get to offset")
-							return protocol.ErrOutOfRange
-						}
-					}
-				}
-				return "200 ok"
-			} else {
-				// absolute
-				clipID, err := strconv.ParseUint(clipIDStr, 10, 0)
-				if err != nil {
-					log.Error().Err(err).Msg("error parsing clip id")
-					return protocol.ErrSyntax
-				}
-				err = d.timeline.PlayClip(uint(clipID))
-				if err != nil {
-					log.Error().Err(err).Msgf("error playing clip id %v", clipID)
-					return protocol.ErrInternal
-				}
-				return "200 ok"
-			}
-		}
-		return protocol.ErrUnsupportedParameter
-	case "slot info":
-		slotID := int64(1) // we only have one slot at this point... should come from deck's state
-		if slotStr, ok := cmd.Parameters["slot id"]; ok {
-			var err error // this is here so slotID below refers to the one in the upper scope
-			slotID, err = strconv.ParseInt(slotStr, 10, 0)
+	d.timeline.ClearPlayRange()
+	err := d.timeline.ClearClips()
+	if err != nil {
+		log.Error().Err(err).Msg("error clearing timeline to switch slots")
+		return protocol.ErrInternal
+	}
+	if slot := d.slots[slotID-1]; slot.Mounted() {
+		for _, diskClip := range slot.Clips() {
+			err := d.timeline.AddClip(diskClip)
 			if err != nil {
-				log.Error().Err(err).Msg("error parsing slot id")
-				return protocol.ErrOutOfRange
+				log.Error().Err(err).Msg("error adding clip from selected slot to timeline")
 			}
 		}
-		cmd := protocol.Command{
-			Name:       "202 slot info:",
-			Parameters: make(map[string]string, 0),
+	}
+	if d.timeline.Count() == 0 {
+		err = d.timeline.StopOnBlack()
+	} else {
+		err = d.timeline.Stop()
+		if err == nil {
+			err = d.timeline.SeekFrame(0)
 		}
-		cmd.Parameters["slot id"] = strconv.FormatInt(slotID, 10)
-		cmd.Parameters["status"] = "mounted"                      // always mounted at this point; could be "empty"
-		cmd.Parameters["volume name"] = "Untitled"                // lol
-		cmd.Parameters["recording time"] = "0"                    // we don't record.
-		cmd.Parameters["video format"] = deck.VideoFormat720p5994 // should come from deck state, if we are going to be controlling the output resolution
-		cmd.Parameters["blocked"] = "false"
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("error stopping timeline to switch slots")
+	}
+	d.sendAsync(d.marshallSlotInfo("502 slot info", uint(slotID)), d.notify.Slot)
+	return "200 ok"
+}
+
+func (d *VLCDeck) marshallSlotInfo(name string, slotID uint) string {
+	cmd := protocol.Command{
+		Name:       name,
+		Parameters: make(map[string]string, 0),
+	}
+	cmd.Parameters["slot id"] = strconv.FormatUint(uint64(slotID), 10)
+	cmd.Parameters["status"] = "empty"
+	cmd.Parameters["volume name"] = ""
+	if slot := d.slots[slotID-1]; slot.Mounted() {
+		cmd.Parameters["status"] = "mounted"
+		cmd.Parameters["volume name"] = slot.VolumeName()
+	}
+	cmd.Parameters["recording time"] = "0"                    // we don't record.
+	cmd.Parameters["video format"] = deck.VideoFormat720p5994 // should come from deck state, if we are going to be controlling the output resolution
+	cmd.Parameters["blocked"] = "false"
 
-		return cmd.Marshall()
-	case "transport info":
-		cmd := protocol.Command{
-			Name:       "208 transport info:",
-			Parameters: make(map[string]string, 0),
+	return cmd.Marshall()
+}
+
+func (d *VLCDeck) format(params map[string]string) string {
+	if token, ok := params["confirm"]; ok {
+		if len(params) != 1 {
+			return protocol.ErrSyntax
+		}
+		if d.pendingFormat == nil {
+			return protocol.ErrFormatNotPrepared
 		}
-		slot := strconv.FormatUint(uint64(d.state.slotID), 10)
-		if d.state.slotID == 0 {
-			slot = "none"
+		if token != d.pendingFormat.token {
+			return protocol.ErrInvalidToken
+		}
+		return d.formatConfirm()
+	}
+
+	filesystem, ok := params["prepare"]
+	if !ok {
+		return protocol.ErrSyntax
+	}
+	if d.config.FormatSandbox == "" {
+		log.Warn().Msg("refusing to format without a format sandbox configured")
+		return protocol.ErrUnsupported
+	}
+
+	slotID := d.state.slotID
+	if slotStr, ok := params["slot id"]; ok {
+		slot, _ := strconv.ParseUint(slotStr, 10, 0)
+		slotID = uint(slot)
+	}
+	if slotID == 0 || !d.slots[slotID-1].Mounted() {
+		return protocol.ErrNoDisk
+	}
+
+	volumeName := d.slots[slotID-1].VolumeName()
+	if name, ok := params["name"]; ok {
+		volumeName = name
+	}
+
+	tokenBytes := make([]byte, 8)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		log.Error().Err(err).Msg("error generating format token")
+		return protocol.ErrInternal
+	}
+
+	d.pendingFormat = &formatRequest{
+		token:      hex.EncodeToString(tokenBytes),
+		slotID:     slotID,
+		filesystem: filesystem,
+		volumeName: volumeName,
+	}
+	return "216 format ready:\r\n" + d.pendingFormat.token + "\r\n"
+}
+
+// formatConfirm carries out the pending format by pointing the slot at an emptied folder in the sandbox, leaving the
+// real media alone
+func (d *VLCDeck) formatConfirm() string {
+	request := d.pendingFormat
+	d.pendingFormat = nil
+	slot := d.slots[request.slotID-1]
+	if !slot.Mounted() {
+		return protocol.ErrNoDisk // ejected since it was prepared
+	}
+
+	sandbox, err := filepath.Abs(filepath.Join(d.config.FormatSandbox, strconv.FormatUint(uint64(request.slotID), 10)))
+	if err != nil {
+		log.Error().Err(err).Msg("error finding format sandbox")
+		return protocol.ErrInternal
+	}
+	slots, err := filepath.Abs(d.config.SlotsPath)
+	if err != nil {
+		log.Error().Err(err).Msg("error finding slots")
+		return protocol.ErrInternal
+	}
+	if strings.HasPrefix(slots+string(filepath.Separator), sandbox+string(filepath.Separator)) ||
+		strings.HasPrefix(sandbox+string(filepath.Separator), slots+string(filepath.Separator)) {
+		log.Error().Msgf("refusing to format: sandbox %v overlaps slots %v", sandbox, slots)
+		return protocol.ErrInternal
+	}
+
+	log.Info().Msgf("formatting slot %v as %v (%v) in %v", request.slotID, request.volumeName, request.filesystem, sandbox)
+	err = slot.Format(sandbox, request.volumeName)
+	if err != nil {
+		log.Error().Err(err).Msgf("error formatting slot %v", request.slotID)
+		return protocol.ErrDiskError
+	}
+
+	if request.slotID == d.state.slotID {
+		// the timeline was made of clips from the disk that's gone
+		d.timeline.ClearPlayRange()
+		err = d.timeline.ClearClips()
+		if err != nil {
+			log.Error().Err(err).Msg("error clearing timeline after format")
+		}
+		err = d.timeline.StopOnBlack()
+		if err != nil {
+			log.Error().Err(err).Msg("error stopping timeline after format")
+		}
+	}
+
+	d.sendAsync(d.marshallSlotInfo("502 slot info", request.slotID), d.notify.Slot)
+	return "200 ok"
+}
+
+func (d *VLCDeck) setIdentify(params map[string]string) string {
+	enable, ok := params["enable"]
+	if !ok {
+		return protocol.ErrSyntax
+	}
+
+	duration := int64(10)
+	if durationStr, ok := params["duration"]; ok {
+		duration, _ = strconv.ParseInt(durationStr, 10, 0)
+	}
+	if enable == "false" {
+		duration = 0
+	}
+	err := d.output.Identify(time.Duration(duration) * time.Second)
+	if err == errCantIdentify {
+		// the deck still identifies, as far as anyone asking it is concerned
+		log.Debug().Msg("nothing to show identify on")
+	} else if err != nil {
+		log.Warn().Err(err).Msg("error identifying")
+		return protocol.ErrInvalidState
+	}
+	d.identifyUntil = d.clock.Now().Add(time.Duration(duration) * time.Second)
+	return "200 ok"
+}
+
+// identifying returns whether identify's still going
+func (d *VLCDeck) identifying() bool {
+	return d.clock.Now().Before(d.identifyUntil)
+}
+
+func (d *VLCDeck) setDynamicRange(params map[string]string) string {
+	override, ok := params["playback override"]
+	if !ok {
+		return "218 dynamic range:\r\n" + d.marshallDynamicRange()
+	}
+	d.dynamicRangeOverride = override
+	if override == "off" {
+		d.dynamicRangeOverride = ""
+	}
+	d.checkDynamicRange()
+	return "200 ok"
+}
+
+func (d *VLCDeck) marshallDynamicRange() string {
+	override := d.dynamicRangeOverride
+	if override == "" {
+		override = "off"
+	}
+	return fmt.Sprintf("dynamic range: %v\r\nplayback override: %v\r\n", d.currentDynamicRange(), override)
+}
+
+// currentDynamicRange is the override if there is one, otherwise the dynamic range of the current clip
+func (d *VLCDeck) currentDynamicRange() string {
+	if d.dynamicRangeOverride != "" {
+		return d.dynamicRangeOverride
+	}
+	if d.timeline.Previewing() {
+		return "none"
+	}
+	clip, err := d.timeline.GetCurrentClip()
+	if err != nil {
+		return "none"
+	}
+	return clip.DynamicRange
+}
+
+// checkDynamicRange sends a 517 dynamic range notification if the dynamic range has changed, e.g. by moving on to a
+// clip with a different one
+func (d *VLCDeck) checkDynamicRange() {
+	dynamicRange := d.currentDynamicRange()
+	if dynamicRange == d.dynamicRange {
+		return
+	}
+	d.dynamicRange = dynamicRange
+	d.sendAsync("517 dynamic range:\r\n"+d.marshallDynamicRange(), d.notify.DynamicRange)
+}
+
+func (d *VLCDeck) configuration(params map[string]string) string {
+	if len(params) == 0 {
+		return "211 configuration:\r\n" + d.marshallConfiguration()
+	}
+
+	channels, _ := strconv.Atoi(params["audio input channels"])
+	if channels == d.audioChannels {
+		return "200 ok"
+	}
+	err := d.timeline.SetAudioChannels(channels)
+	if err != nil {
+		log.Error().Err(err).Msg("error routing audio channels")
+		return protocol.ErrInternal
+	}
+	d.audioLock.Lock()
+	d.audioChannels = channels
+	d.meter.SetChannels(channels)
+	d.audioLevels = deck.Silence(channels)
+	d.audioLock.Unlock()
+
+	d.sendAsync("511 configuration:\r\n"+d.marshallConfiguration(), d.notify.Configuration)
+	return "200 ok"
+}
+
+func (d *VLCDeck) marshallConfiguration() string {
+	return fmt.Sprintf("audio input channels: %v\r\n", d.audioChannels)
+}
+
+func isAudioInputChannels(channels int) bool {
+	for _, valid := range deck.AudioInputChannels {
+		if strconv.Itoa(channels) == valid {
+			return true
 		}
-		cmd.Parameters["status"] = d.timeline.TransportStatus()
-		cmd.Parameters["speed"] = d.timeline.TransportSpeed()                         // -1600 through 1600
-		cmd.Parameters["slot id"] = slot                                              // or none
-		cmd.Parameters["clip id"] = strconv.FormatUint(uint64(d.timeline.clipID), 10) // or none??!? (HDS Mini shows clip id: 1 even when the timeline is clear!)
-		cmd.Parameters["single clip"] = strconv.FormatBool(d.timeline.singleClip)
-		cmd.Parameters["display timecode"] = d.timeline.Timecode().String() // timecode on front of deck
-		cmd.Parameters["timecode"] = d.timeline.Timecode().String()         // timecode on timeline/playlist
-		cmd.Parameters["video format"] = "720p5994"
-		cmd.Parameters["loop"] = strconv.FormatBool(d.state.loop)
-		cmd.Parameters["timeline"] = strconv.FormatInt(d.timeline.Timecode().Frame(), 10) // number of framess into timeline??
-		cmd.Parameters["input video format"] = "none"
-		cmd.Parameters["dynamic range"] = "none"
+	}
+	return false
+}
+
+// measureAudio measures the peak levels of whatever's playing since it was last measured. What's playing is read
+// under the command lock, but it's measured outside it, as that waits on the decoder.
+func (d *VLCDeck) measureAudio() deck.AudioLevels {
+	d.commandLock.Lock()
+	status := d.timeline.TransportStatus()
+	playing := status == "play" || status == "forward"
+	path := ""
+	var position time.Duration
+	if clip, err := d.timeline.GetCurrentClip(); err == nil && playing {
+		path = clip.path
+		position = d.timeline.clipPosition()
+	}
+	d.commandLock.Unlock()
+
+	d.audioLock.Lock()
+	defer d.audioLock.Unlock()
+	d.audioLevels = d.meter.Measure(path, position, playing)
+	return d.audioLevels
+}
 
-		return cmd.Marshall()
+// watchAudio keeps the audio levels up to date, streaming them if asked to
+func (d *VLCDeck) watchAudio() {
+	ticker := d.clock.NewTicker(meterInterval)
+	for range ticker.C() {
+		levels := d.measureAudio()
+		d.commandLock.Lock()
+		stream := d.streamLevels
+		d.commandLock.Unlock()
+		d.server.Notify("591 audio levels:\r\n"+strings.Join(levels.Marshall(), "\r\n")+"\r\n", stream)
+	}
+}
+
+func (d *VLCDeck) uptime(params map[string]string) string {
+	return fmt.Sprintf("228 uptime:\r\nuptime: %v\r\n", int64(d.stats.Uptime().Seconds()))
+}
+
+func (d *VLCDeck) transportInfo(params map[string]string) string {
+	cmd := protocol.Command{
+		Name:       "208 transport info",
+		Parameters: make(map[string]string, 0),
+	}
+	slot := strconv.FormatUint(uint64(d.state.slotID), 10)
+	if d.state.slotID == 0 {
+		slot = "none"
+	}
+	cmd.Parameters["status"] = d.timeline.TransportStatus()
+	cmd.Parameters["speed"] = d.timeline.TransportSpeed()                         // -1600 through 1600
+	cmd.Parameters["slot id"] = slot                                              // or none
+	cmd.Parameters["clip id"] = strconv.FormatUint(uint64(d.timeline.clipID), 10) // or none??!? (HDS Mini shows clip id: 1 even when the timeline is clear!)
+	cmd.Parameters["single clip"] = strconv.FormatBool(d.timeline.singleClip)
+	cmd.Parameters["display timecode"] = d.timeline.Timecode().String() // timecode on front of deck
+	cmd.Parameters["timecode"] = d.timeline.Timecode().String()         // timecode on timeline/playlist
+	cmd.Parameters["video format"] = "720p5994"
+	cmd.Parameters["loop"] = strconv.FormatBool(d.timeline.loop)
+	cmd.Parameters["timeline"] = strconv.FormatInt(d.timeline.Timecode().Frame(), 10) // number of framess into timeline??
+	cmd.Parameters["input video format"] = "none"
+	if d.input != nil {
+		cmd.Parameters["input video format"] = d.input.Format
 	}
+	cmd.Parameters["dynamic range"] = d.currentDynamicRange()
+
+	return cmd.Marshall()
+}
+
+func (d *VLCDeck) registerAdminCommands() {
+	d.admin.Register(&deck.CommandSpec{
+		Name:        "stats",
+		Description: "query uptime, play time, connection, command and error counts",
+		Handler:     d.adminStats,
+	})
+	d.admin.Register(&deck.CommandSpec{
+		Name:        "audio levels",
+		Description: "query peak audio levels per channel, or stream them as 591 notifications",
+		Parameters:  []protocol.Parameter{{Name: "stream", Type: protocol.Bool}},
+		Handler:     d.adminAudioLevels,
+	})
+	d.admin.Register(&deck.CommandSpec{
+		Name:        "faults",
+		Description: "query or set faults to inject: latency and notify delay in ms, the rest in percent, disk error slots",
+		Parameters:  deck.FaultParameters,
+		Handler:     d.adminFaults,
+	})
+}
 
-	log.Warn().Msgf("unsupported command: %v", cmd)
-	return protocol.ErrUnsupported
+func (d *VLCDeck) adminFaults(params map[string]string) string {
+	if len(params) == 0 {
+		return "292 faults:\r\n" + strings.Join(d.faults.Marshall(), "\r\n") + "\r\n"
+	}
+	return d.faults.Configure(params)
+}
+
+func (d *VLCDeck) adminAudioLevels(params map[string]string) string {
+	if stream, ok := params["stream"]; ok {
+		d.streamLevels = stream == "true"
+		return "200 ok"
+	}
+	d.audioLock.Lock()
+	defer d.audioLock.Unlock()
+	return "291 audio levels:\r\n" + strings.Join(d.audioLevels.Marshall(), "\r\n") + "\r\n"
+}
+
+func (d *VLCDeck) adminStats(params map[string]string) string {
+	return "290 stats:\r\n" + strings.Join(d.stats.Marshall(), "\r\n") + "\r\n"
 }
 
 func (d *VLCDeck) PowerOn() {
+	d.stats.PowerOn()
+	go d.watchCache()
+	go d.watchAudio()
 	d.server.Serve()
-	d.app.Run(os.Args)
+	if d.config.HTTPAddr != "" {
+		d.serveREST()
+	}
+	if d.mdns != nil {
+		err := d.mdns.Start()
+		if err != nil {
+			log.Error().Err(err).Msg("error starting mdns responder; the deck won't be discoverable")
+			d.mdns = nil
+		}
+	}
+	if d.adminSrv != nil {
+		err := d.adminSrv.Serve()
+		if err != nil {
+			if d.config.ProtocolAdmin {
+				log.Error().Err(err).Msg("error starting admin server; admin commands will only work over the HyperDeck protocol")
+			} else {
+				log.Error().Err(err).Msg("error starting admin server; admin commands won't be available")
+			}
+			d.adminSrv = nil
+		}
+	}
+	if d.ftp != nil {
+		err := d.ftp.Serve()
+		if err != nil {
+			log.Error().Err(err).Msg("error starting ftp server; slot media won't be available over FTP")
+			d.ftp = nil
+		}
+	}
+	d.output.Run(d.engine, d.PowerOff)
 }
 
 func (d *VLCDeck) PowerOff() {
+	if d.mdns != nil {
+		d.mdns.Close()
+		log.Debug().Msg("withdrew mdns advertisement")
+	}
+
 	d.server.Close()
 	log.Debug().Msg("stopped server")
 
-	d.app.Quit()
-	log.Debug().Msg("quit app")
+	if d.http != nil {
+		err := d.http.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("error stopping REST API")
+		}
+		log.Debug().Msg("stopped REST API")
+	}
+
+	if d.adminSrv != nil {
+		d.adminSrv.Close()
+		log.Debug().Msg("stopped admin server")
+	}
+
+	if d.ftp != nil {
+		d.ftp.Close()
+		log.Debug().Msg("stopped ftp server")
+	}
+
+	d.output.Stop()
+	log.Debug().Msg("stopped output")
 
 	err := d.timeline.Stop()
 	if err != nil {
@@ -393,16 +1276,26 @@ func (d *VLCDeck) PowerOff() {
 	}
 	log.Debug().Msg("stopped timeline")
 
-	err = vlc.Release()
-	if err != nil {
-		log.Fatal().Err(err).Msg("error releasing VLC")
+	if closer, ok := d.engine.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("error closing playback engine")
+		}
+		log.Debug().Msg("closed playback engine")
+	}
+
+	if d.config.Engine == "vlc" {
+		err = vlc.Release()
+		if err != nil {
+			log.Fatal().Err(err).Msg("error releasing VLC")
+		}
+		log.Debug().Msg("released VLC")
 	}
-	log.Debug().Msg("released VLC")
 }
 
 func (d *VLCDeck) clipsGet(params map[string]string) (output string) {
 	output += "205 clips info:\r\n"
-	output += fmt.Sprintf("clips count: %v\r\n", d.timeline.Count())
+	output += fmt.Sprintf("clip count: %v\r\n", d.timeline.Count())
 
 	d.timeline.RLock()
 	defer d.timeline.RUnlock()
@@ -417,23 +1310,16 @@ func (d *VLCDeck) clipsGet(params map[string]string) (output string) {
 func (d *VLCDeck) diskList(params map[string]string) (output string) {
 	slotID := d.state.slotID
 	if slotStr, ok := params["slot id"]; ok {
-		slot, err := strconv.ParseUint(slotStr, 10, 0)
-		if err != nil {
-			log.Error().Err(err).Msg("error parsing slot id")
-			return protocol.ErrInternal
-		}
+		slot, _ := strconv.ParseUint(slotStr, 10, 0)
 		slotID = uint(slot)
 	}
-	output += "206 disk list:\r\n"
-	output += fmt.Sprintf("slot id: %v\r\n", slotID)
-
-	slot, err := d.CurrentSlot()
-	if err != nil {
+	if slotID == 0 {
 		return protocol.ErrNoDisk
 	}
+	slot := d.slots[slotID-1]
 
-	slot.RLock()
-	defer slot.RUnlock()
+	output += "206 disk list:\r\n"
+	output += fmt.Sprintf("slot id: %v\r\n", slotID)
 
 	for idx, clip := range slot.Clips() {
 		output += fmt.Sprintf("%v: %v %v %v %v\r\n", idx+1, clip.Name, "QuickTimeProResLT", "720p5994", clip.Duration.String())
@@ -442,25 +1328,26 @@ func (d *VLCDeck) diskList(params map[string]string) (output string) {
 	return output + "\r\n"
 }
 
-func (d *VLCDeck) vlcEventHandler(event vlc.Event, userData interface{}) {
-	log.Debug().Msgf("got vlc event: %v", event)
+// sendAsync notifies once the response to the current command has gone out; the client only gets it if send is true
+func (d *VLCDeck) sendAsync(msg string, send bool) {
+	go func() {
+		d.clock.Sleep(100 * time.Millisecond)
+		d.server.Notify(msg, send)
+	}()
+}
 
-	switch event {
-	case vlc.MediaPlayerPositionChanged, vlc.MediaPlayerTimeChanged:
-		log.Info().Msg("got position/time changed event")
-		if d.notify.TimelinePosition {
-			// Send 514 timeline position
-			// timeline: 566
-			//
-			msg := fmt.Sprintf("514 timeline position:\r\ntimeline: %v\r\n", d.timeline.Timecode().Frame())
-			d.server.AsyncSend(msg)
-		}
-		if d.notify.DisplayTimecode {
-			// Send 513 display timecode:
-			// display timecode: 00:00:06;02
-			//
-			msg := fmt.Sprintf("513 display timecode:\r\ndisplay timecode: %v\r\n", d.timeline.Timecode().String())
-			d.server.AsyncSend(msg)
-		}
-	}
+// onTimelineTick notifies the new position each time the timeline's transport moves to another frame
+func (d *VLCDeck) onTimelineTick() {
+	d.checkDynamicRange()
+	// Send 514 timeline position
+	// timeline: 566
+	//
+	msg := fmt.Sprintf("514 timeline position:\r\ntimeline: %v\r\n", d.timeline.Timecode().Frame())
+	d.server.Notify(msg, d.notify.TimelinePosition)
+
+	// Send 513 display timecode:
+	// display timecode: 00:00:06;02
+	//
+	msg = fmt.Sprintf("513 display timecode:\r\ndisplay timecode: %v\r\n", d.timeline.Timecode().String())
+	d.server.Notify(msg, d.notify.DisplayTimecode)
 }
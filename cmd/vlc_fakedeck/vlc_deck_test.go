@@ -0,0 +1,273 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/josh23french/fakedeck/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"trimmer.io/go-timecode/timecode"
+)
+
+// newTestDeck makes a deck that plays nothing, shows nothing and keeps time by a fake clock, with two empty slots and
+// none of its servers listening
+func newTestDeck(t *testing.T) *VLCDeck {
+	return VLCDeckNew(testConfig(t))
+}
+
+// testConfig is the config for newTestDeck, with its slots in a directory that's removed after the test
+func testConfig(t *testing.T) Config {
+	dir, err := ioutil.TempDir("", "fakedeck-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	for _, slot := range []string{"1", "2"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "slots", slot), 0755))
+	}
+
+	return Config{
+		Name:           "test",
+		SlotsPath:      filepath.Join(dir, "slots"),
+		AudioChannels:  2,
+		Addr:           "127.0.0.1:0",
+		Engine:         "sim",
+		Output:         "headless",
+		FakeClock:      true,
+		FormatSandbox:  filepath.Join(dir, "formatted"),
+		CacheSize:      1024,
+		CacheFillRate:  30,
+		CacheDrainRate: 100,
+	}
+}
+
+// testClip makes a clip of dur that's never probed or played, for the sim engine
+func testClip(name string, dur time.Duration) *DiskClip {
+	return &DiskClip{
+		Name:     name,
+		Duration: timecode.New(dur, timecode.Rate60DF),
+		path:     name,
+		media:    &Media{Location: name, Duration: dur},
+	}
+}
+
+// command runs a command on the deck like it came in over the protocol port
+func command(d *VLCDeck, name string, params map[string]string) string {
+	return d.ProcessCommand(&protocol.Command{Name: name, Parameters: params})
+}
+
+func TestFormatClearsTimeline(t *testing.T) {
+	d := newTestDeck(t)
+	require.NoError(t, d.timeline.AddClip(testClip("a.mov", 10*time.Second)))
+	require.NoError(t, d.timeline.AddClip(testClip("b.mov", 10*time.Second)))
+	require.NotZero(t, d.timeline.Duration())
+
+	res := command(d, "format", map[string]string{"prepare": "exFAT", "name": "Blank"})
+	require.Contains(t, res, "216 format ready")
+	token := res[len("216 format ready:\r\n") : len(res)-len("\r\n")]
+	assert.Equal(t, "200 ok", command(d, "format", map[string]string{"confirm": token}))
+
+	assert.Equal(t, 0, d.timeline.Count(), "format should empty the timeline")
+	assert.Equal(t, int64(0), d.timeline.Duration(), "format should leave the timeline with no length")
+
+	require.NoError(t, d.timeline.AddClip(testClip("c.mov", 10*time.Second)))
+	assert.Equal(t, int64(0), d.timeline.GetClipByID(1).Start.Frame(), "clips added after a format should start at 0")
+	assert.Equal(t, int64(0), d.timeline.Timecode().Frame(), "the transport should be at the start")
+}
+
+func TestFormatNeedsDisk(t *testing.T) {
+	d := newTestDeck(t)
+	assert.Equal(t, "200 ok", d.processAdmin(&protocol.Command{Name: "slot eject", Parameters: map[string]string{"slot id": "2"}}))
+	assert.Equal(t, protocol.ErrNoDisk, command(d, "format", map[string]string{"prepare": "exFAT", "slot id": "2"}),
+		"there's no disk in slot 2 to format")
+	assert.Nil(t, d.pendingFormat)
+
+	res := command(d, "format", map[string]string{"prepare": "exFAT", "slot id": "1"})
+	require.Contains(t, res, "216 format ready")
+	token := res[len("216 format ready:\r\n") : len(res)-len("\r\n")]
+	assert.Equal(t, "200 ok", d.processAdmin(&protocol.Command{Name: "slot eject", Parameters: map[string]string{"slot id": "1"}}))
+	assert.Equal(t, protocol.ErrNoDisk, command(d, "format", map[string]string{"confirm": token}),
+		"the disk was ejected after the format was prepared")
+	assert.NotEqual(t, d.config.FormatSandbox, filepath.Dir(d.slots[0].Path()), "the slot shouldn't have been formatted")
+}
+
+func TestIdentify(t *testing.T) {
+	d := newTestDeck(t)
+	identifying := func() bool {
+		state := d.processAdmin(&protocol.Command{Name: "state"})
+		return strings.Contains(state, "identifying: true\r\n")
+	}
+	assert.False(t, identifying())
+
+	assert.Equal(t, "200 ok", command(d, "identify", map[string]string{"enable": "true", "duration": "5"}),
+		"should identify, even with nothing to show it on")
+	assert.True(t, identifying(), "admin state should report identifying")
+	d.fake.Advance(4 * time.Second)
+	assert.True(t, identifying(), "should keep identifying for the duration")
+	d.fake.Advance(time.Second)
+	assert.False(t, identifying(), "should stop once the duration's up on the clock")
+
+	assert.Equal(t, "200 ok", command(d, "identify", map[string]string{"enable": "true"}))
+	d.fake.Advance(9 * time.Second)
+	assert.True(t, identifying(), "should identify for 10 seconds by default")
+	assert.Equal(t, "200 ok", command(d, "identify", map[string]string{"enable": "false"}))
+	assert.False(t, identifying(), "should stop when disabled")
+}
+
+func TestSlotSelect(t *testing.T) {
+	d := newTestDeck(t)
+	require.Len(t, d.slots, 2, "should have a slot for each directory")
+	require.NoError(t, d.slots[1].AddClip(testClip("b1.mov", 10*time.Second)))
+	require.NoError(t, d.slots[1].AddClip(testClip("b2.mov", 5*time.Second)))
+	require.NoError(t, d.timeline.AddClip(testClip("a.mov", 30*time.Second)))
+
+	assert.Equal(t, "200 ok", command(d, "slot select", map[string]string{"slot id": "2"}))
+	assert.Equal(t, uint(2), d.state.slotID, "should switch to slot 2")
+	require.Equal(t, 2, d.timeline.Count(), "the timeline should be slot 2's clips")
+	assert.Equal(t, "b1.mov", d.timeline.GetClipByID(1).Name)
+	clips := d.slots[1].Clips()
+	assert.Equal(t, clips[0].Duration.Frame()+clips[1].Duration.Frame(), d.timeline.Duration(),
+		"the timeline should be as long as slot 2's clips")
+	assert.Equal(t, "stopped", d.timeline.TransportStatus())
+	assert.Contains(t, command(d, "slot info", nil), "slot id: 2", "slot info should be about slot 2")
+
+	assert.NotEqual(t, "200 ok", command(d, "slot select", map[string]string{"slot id": "3"}),
+		"there's no slot 3")
+	assert.Equal(t, uint(2), d.state.slotID)
+
+	d.remote.Enabled = false
+	assert.Equal(t, protocol.ErrRemoteControlDisabled, command(d, "slot select", map[string]string{"slot id": "1"}),
+		"switching slots should need remote control")
+}
+
+func TestLoopInTransportInfo(t *testing.T) {
+	d := newTestDeck(t)
+	require.NoError(t, d.timeline.AddClip(testClip("a.mov", 10*time.Second)))
+
+	assert.Equal(t, "200 ok", command(d, "play", map[string]string{"loop": "true", "single clip": "true"}))
+	info := command(d, "transport info", nil)
+	assert.Contains(t, info, "loop: true\r\n")
+	assert.Contains(t, info, "single clip: true\r\n")
+	state := d.processAdmin(&protocol.Command{Name: "state"})
+	assert.Contains(t, state, "loop: true\r\n", "admin state should show the loop play was given")
+	assert.Contains(t, state, "single clip: true\r\n")
+}
+
+func TestMeasureAudioWhileCommanded(t *testing.T) {
+	d := newTestDeck(t)
+	require.NoError(t, d.timeline.AddClip(testClip("a.mov", 10*time.Second)))
+
+	done := make(chan interface{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			d.measureAudio()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		command(d, "play", nil)
+		command(d, "stop", nil)
+	}
+	<-done
+	assert.Equal(t, deck.Silence(2), d.measureAudio(), "nothing's playing")
+}
+
+func TestPreview(t *testing.T) {
+	d := newTestDeck(t)
+	require.NoError(t, d.timeline.AddClip(testClip("a.mov", 10*time.Second)))
+	status := func() string {
+		info := command(d, "transport info", nil)
+		for _, line := range strings.Split(info, "\r\n") {
+			if strings.HasPrefix(line, "status: ") {
+				return strings.TrimPrefix(line, "status: ")
+			}
+		}
+		return ""
+	}
+
+	assert.Equal(t, protocol.ErrNoInput, command(d, "preview", map[string]string{"enable": "true"}),
+		"there's no input to preview")
+	assert.Equal(t, "stopped", status())
+
+	assert.Equal(t, "200 ok", d.processAdmin(&protocol.Command{Name: "input", Parameters: map[string]string{"source": "bars"}}))
+	assert.Equal(t, "200 ok", command(d, "preview", map[string]string{"enable": "true"}))
+	assert.Equal(t, "preview", status())
+	assert.Contains(t, command(d, "transport info", nil), "input video format: 720p5994\r\n")
+
+	assert.Equal(t, "200 ok", command(d, "play", nil))
+	assert.Equal(t, "play", status(), "play should switch from preview to the timeline")
+	assert.Equal(t, "200 ok", command(d, "preview", map[string]string{"enable": "true"}))
+	assert.Equal(t, "preview", status(), "preview should switch from playing to the input")
+	assert.Equal(t, "200 ok", command(d, "preview", map[string]string{"enable": "false"}))
+	assert.Equal(t, "stopped", status(), "ending preview should go back to the timeline, stopped")
+	assert.Equal(t, "200 ok", command(d, "play", nil))
+	assert.Equal(t, "play", status())
+
+	assert.Equal(t, "200 ok", d.processAdmin(&protocol.Command{Name: "input", Parameters: map[string]string{"source": "none"}}))
+	assert.Equal(t, protocol.ErrNoInput, command(d, "preview", map[string]string{"enable": "true"}),
+		"the input's been taken away")
+	assert.Equal(t, "play", status())
+}
+
+func TestFTPFoldersSkipEjectedSlots(t *testing.T) {
+	d := newTestDeck(t)
+	assert.Len(t, d.ftpFolders(), 2)
+
+	assert.Equal(t, "200 ok", d.processAdmin(&protocol.Command{Name: "slot eject", Parameters: map[string]string{"slot id": "2"}}))
+	folders := d.ftpFolders()
+	assert.Contains(t, folders, "1")
+	assert.NotContains(t, folders, "2", "an ejected slot shouldn't be served")
+}
+
+func TestAdminClockAdvance(t *testing.T) {
+	d := newTestDeck(t)
+	require.NoError(t, d.timeline.AddClip(testClip("a.mov", 10*time.Second)))
+
+	assert.Equal(t, "200 ok", command(d, "play", nil))
+	assert.Equal(t, "200 ok", d.processAdmin(&protocol.Command{Name: "clock advance", Parameters: map[string]string{"ms": "1000"}}))
+	assert.InDelta(t, frames(d.timeline, time.Second), d.timeline.Timecode().Frame(), 1,
+		"the transport should move with the clock as it's advanced")
+	assert.Contains(t, command(d, "transport info", nil), "status: play\r\n")
+}
+
+func TestProtocolAdmin(t *testing.T) {
+	d := newTestDeck(t)
+	eject := map[string]string{"slot id": "1"}
+	assert.Equal(t, protocol.ErrUnsupported, command(d, "admin slot eject", eject),
+		"admin commands shouldn't be taken over the HyperDeck protocol unless that's been allowed")
+	assert.True(t, d.slots[0].Mounted())
+
+	config := testConfig(t)
+	config.ProtocolAdmin = true
+	d = VLCDeckNew(config)
+	assert.Equal(t, "200 ok", command(d, "admin slot eject", eject))
+	assert.False(t, d.slots[0].Mounted())
+}
+
+func TestDynamicRange(t *testing.T) {
+	d := newTestDeck(t)
+	sdr := testClip("a.mov", 10*time.Second)
+	sdr.DynamicRange = deck.DynamicRangeRec709
+	hlg := testClip("b.mov", 10*time.Second)
+	hlg.DynamicRange = deck.DynamicRangeHLG
+	require.NoError(t, d.timeline.AddClip(sdr))
+	require.NoError(t, d.timeline.AddClip(hlg))
+
+	assert.Contains(t, command(d, "transport info", nil), "dynamic range: Rec709\r\n")
+	require.Equal(t, "200 ok", command(d, "goto", map[string]string{"clip id": "2"}))
+	assert.Contains(t, command(d, "transport info", nil), "dynamic range: HLG\r\n")
+	assert.Equal(t, "218 dynamic range:\r\ndynamic range: HLG\r\nplayback override: off\r\n",
+		command(d, "dynamic range", nil))
+
+	require.Equal(t, "200 ok", command(d, "dynamic range", map[string]string{"playback override": deck.DynamicRangePQ1000}))
+	assert.Equal(t, deck.DynamicRangePQ1000, d.dynamicRange, "should notify the override")
+	assert.Contains(t, command(d, "transport info", nil), "dynamic range: ST2084_1000\r\n")
+	assert.Equal(t, "218 dynamic range:\r\ndynamic range: ST2084_1000\r\nplayback override: ST2084_1000\r\n",
+		command(d, "dynamic range", nil))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	vlc "github.com/adrg/libvlc-go/v3"
+)
+
+// vlcEngine plays media with libVLC, which has to have been initialized first
+type vlcEngine struct {
+	sync.Mutex
+	player   *vlc.Player
+	media    *vlc.Media // what's loaded, released when it's replaced
+	loaded   *Media     // what media was made from
+	channels int        // audio channels to route loaded media onto; 0 to leave it alone
+	onEnd    []func(media *Media)
+}
+
+func newVLCEngine() (*vlcEngine, error) {
+	player, err := vlc.NewPlayer()
+	if err != nil {
+		return nil, fmt.Errorf("error creating player: %w", err)
+	}
+	e := &vlcEngine{
+		player: player,
+	}
+
+	em, err := player.EventManager()
+	if err != nil {
+		return nil, fmt.Errorf("error getting player EventManager: %w", err)
+	}
+	_, err = em.Attach(vlc.MediaPlayerEndReached, e.onEndReached, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error attaching end reached event: %w", err)
+	}
+	return e, nil
+}
+
+// onEndReached is called on libVLC's thread, which mustn't call back into libVLC, so the handlers get their own
+func (e *vlcEngine) onEndReached(event vlc.Event, userData interface{}) {
+	e.Lock()
+	defer e.Unlock()
+	for _, fn := range e.onEnd {
+		go fn(e.loaded)
+	}
+}
+
+// newMedia creates the libVLC media for media
+func (e *vlcEngine) newMedia(media *Media) (*vlc.Media, error) {
+	var m *vlc.Media
+	var err error
+	switch {
+	case media.Image != nil:
+		m, err = vlc.NewMediaFromReadSeeker(bytes.NewReader(media.Image))
+		if err == nil {
+			err = m.AddOptions(":image-duration=-1") // show it forever
+		}
+	case strings.Contains(media.Location, "://"):
+		m, err = vlc.NewMediaFromURL(media.Location)
+	default:
+		m, err = vlc.NewMediaFromPath(media.Location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if media.Repeat {
+		err = m.AddOptions(":input-repeat=65535")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if e.channels > 0 && media.Image == nil {
+		err = m.AddOptions(audioRouting(e.channels)...)
+		if err != nil {
+			return nil, fmt.Errorf("error routing audio: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (e *vlcEngine) Load(media *Media) error {
+	e.Lock()
+	defer e.Unlock()
+	m, err := e.newMedia(media)
+	if err != nil {
+		return fmt.Errorf("error creating media: %w", err)
+	}
+	err = e.player.SetMedia(m)
+	if err != nil {
+		m.Release()
+		return fmt.Errorf("error setting media: %w", err)
+	}
+	if e.media != nil {
+		e.media.Release() // the player keeps its own reference while it needs one
+	}
+	e.media = m
+	e.loaded = media
+	return nil
+}
+
+func (e *vlcEngine) Play() error {
+	return e.player.Play()
+}
+
+func (e *vlcEngine) Pause() error {
+	return e.player.SetPause(true)
+}
+
+func (e *vlcEngine) Seek(pos time.Duration) error {
+	return e.player.SetMediaTime(int(pos / time.Millisecond))
+}
+
+func (e *vlcEngine) SetRate(rate float64) error {
+	return e.player.SetPlaybackRate(float32(rate))
+}
+
+func (e *vlcEngine) Position() (time.Duration, error) {
+	ms, err := e.player.MediaTime()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (e *vlcEngine) SetAudioChannels(channels int) error {
+	e.Lock()
+	defer e.Unlock()
+	e.channels = channels
+	return nil
+}
+
+func (e *vlcEngine) OnEnd(fn func(media *Media)) {
+	e.Lock()
+	defer e.Unlock()
+	e.onEnd = append(e.onEnd, fn)
+}
+
+// SetXWindow plays into an X window, for the window output
+func (e *vlcEngine) SetXWindow(id uint32) error {
+	return e.player.SetXWindow(id)
+}
@@ -0,0 +1,116 @@
+//go:build !nogtk
+// +build !nogtk
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/rs/zerolog/log"
+)
+
+// #cgo LDFLAGS: -lX11
+// #include <stdlib.h>
+// #include <X11/Xlib.h>
+import "C"
+
+// xWindowEngine is a PlaybackEngine that can play into an X window
+type xWindowEngine interface {
+	SetXWindow(id uint32) error
+}
+
+const appID string = "com.jafrench.fakedeck.vlc_fakedeck"
+
+// windowOutput plays into a fullscreen GTK window, with an overlay to identify the deck
+type windowOutput struct {
+	app      *gtk.Application
+	name     string
+	identify *identifyOverlay // nil until the window is up
+}
+
+func newWindowOutput(name string) (Output, error) {
+	C.XInitThreads()
+
+	app, err := gtk.ApplicationNew(appID, glib.APPLICATION_FLAGS_NONE)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing GTK Application: %w", err)
+	}
+	return &windowOutput{
+		app:  app,
+		name: name,
+	}, nil
+}
+
+func (o *windowOutput) Options() []string {
+	return nil
+}
+
+func (o *windowOutput) Run(engine PlaybackEngine, powerOff func()) {
+	o.app.Connect("activate", func() {
+		o.onActivate(engine)
+	})
+	o.app.Connect("shutdown", func() {
+		glib.IdleAdd(powerOff)
+	})
+	o.app.Run(os.Args)
+}
+
+func (o *windowOutput) Stop() {
+	o.app.Quit()
+}
+
+func (o *windowOutput) Identify(duration time.Duration) error {
+	if o.identify == nil {
+		return errCantIdentify
+	}
+	if duration == 0 {
+		o.identify.Stop()
+		return nil
+	}
+	o.identify.Start(duration)
+	return nil
+}
+
+func (o *windowOutput) onActivate(engine PlaybackEngine) {
+	appWin, err := gtk.ApplicationWindowNew(o.app)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error initializing GTK ApplicationWindow")
+	}
+	area, err := gtk.DrawingAreaNew()
+	if err != nil {
+		log.Fatal().Err(err).Msg("error initializing GTK DrawingArea")
+	}
+	overlay, err := gtk.OverlayNew()
+	if err != nil {
+		log.Fatal().Err(err).Msg("error initializing GTK Overlay")
+	}
+	overlay.Add(area)
+	o.identify, err = newIdentifyOverlay(overlay, identifyText(o.name))
+	if err != nil {
+		log.Fatal().Err(err).Msg("error initializing identify overlay")
+	}
+	appWin.Add(overlay)
+	appWin.ShowAll()
+	o.app.AddWindow(appWin)
+
+	win, err := area.ToWidget().GetWindow()
+	if err != nil {
+		log.Fatal().Err(err).Msg("error getting appWin gdk.Window")
+	}
+	if windowed, ok := engine.(xWindowEngine); ok {
+		windowed.SetXWindow(win.GetXID())
+	} else {
+		log.Warn().Msg("the playback engine can't play into the window")
+	}
+
+	appWin.Fullscreen()
+
+	display, _ := appWin.GetDisplay()
+	blankCursor, _ := gdk.CursorNewFromName(display, "none")
+	win.SetCursor(blankCursor)
+}
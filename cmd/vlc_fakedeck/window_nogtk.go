@@ -0,0 +1,11 @@
+//go:build nogtk
+// +build nogtk
+
+package main
+
+import "errors"
+
+// newWindowOutput can't make a window when the deck's built without GTK, for boxes without a display
+func newWindowOutput(name string) (Output, error) {
+	return nil, errors.New(`built without GTK; use the "headless" or "frames" output`)
+}
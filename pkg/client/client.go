@@ -2,26 +2,39 @@
 package client
 
 import (
+	"bufio"
+	"errors"
+	"io"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/josh23french/fakedeck/pkg/protocol"
 	"github.com/rs/zerolog/log"
 )
 
+// ErrNotConnected is returned when there's no connection to send on, and one couldn't be made
+var ErrNotConnected = errors.New("not connected")
+
 // Client represents a Hyperdeck protocol client
 type Client struct {
-	remoteHost string   // remoteHost is the host:port string to (re)connect to
-	conn       net.Conn // conn may be a valid connection, but it might not be...
+	remoteHost string      // remoteHost is the host:port string to (re)connect to
+	conn       net.Conn    // conn may be a valid connection, but it might not be...
+	messages   chan string // everything read from conn, in order
+	sync.Mutex
 }
 
 // New creates a new Client
 func New(remoteHost string) *Client {
 	client := &Client{
-		conn: nil,
+		remoteHost: remoteHost,
+		conn:       nil,
 	}
 	err := client.tryConnect()
 	if err != nil {
 		log.Warn().Err(err).Msg("error connecting to remote host")
+		client.messages = make(chan string)
+		close(client.messages)
 	}
 	return client
 }
@@ -35,5 +48,66 @@ func (c *Client) tryConnect() error {
 		return err
 	}
 	c.conn = conn
+	c.messages = make(chan string, 64)
+	go read(conn, c.messages)
 	return nil
 }
+
+// read passes on messages from conn until it's closed
+func read(conn net.Conn, messages chan<- string) {
+	defer close(messages)
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := protocol.ReadMessage(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Debug().Err(err).Msg("error reading from remote host")
+			}
+			return
+		}
+		messages <- msg
+	}
+}
+
+// Connected returns whether the client has a connection; it might have been closed by the other end since
+func (c *Client) Connected() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.conn != nil
+}
+
+// Messages returns everything the deck sends over the current connection, responses and asynchronous messages alike,
+// in the order they're sent. Multi-line messages keep their line endings, without the blank line ending them. It's
+// closed when the connection is.
+func (c *Client) Messages() <-chan string {
+	c.Lock()
+	defer c.Unlock()
+	return c.messages
+}
+
+// Send sends a command line, connecting first if there's no connection
+func (c *Client) Send(command string) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == nil {
+		err := c.tryConnect()
+		if err != nil {
+			log.Warn().Err(err).Msg("error connecting to remote host")
+			return ErrNotConnected
+		}
+	}
+	_, err := io.WriteString(c.conn, command+"\r\n")
+	return err
+}
+
+// Close closes the connection, if there is one
+func (c *Client) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
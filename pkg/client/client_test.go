@@ -1,12 +1,43 @@
 package client
 
 import (
+	"bufio"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNew(t *testing.T) {
 	deck := New("172.16.49.78:9993")
 	assert.NotNil(t, deck)
 }
+
+func TestSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("500 connection info:\r\nprotocol version: 1.11\r\nmodel: Test\r\n\r\n"))
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		if line == "ping\r\n" {
+			conn.Write([]byte("200 ok\r\n"))
+		}
+	}()
+
+	c := New(l.Addr().String())
+	require.True(t, c.Connected())
+	assert.Equal(t, "500 connection info:\r\nprotocol version: 1.11\r\nmodel: Test\r\n", <-c.Messages())
+	require.NoError(t, c.Send("ping"))
+	assert.Equal(t, "200 ok", <-c.Messages())
+	_, open := <-c.Messages()
+	assert.False(t, open, "should close messages when the deck hangs up")
+	assert.NoError(t, c.Close())
+	assert.False(t, c.Connected())
+}
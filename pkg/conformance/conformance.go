@@ -0,0 +1,98 @@
+// Package conformance checks decks against captured sessions with HyperDecks, so we know exactly where fakedeck still
+// behaves differently.
+//
+// Captures are transcripts (see deck.Transcript), one session per file. They're recordings of real units where we
+// have them, and otherwise written from the protocol documentation; testdata/README.md says which are which. To check a deck, serve it on a loopback
+// address and Run the cases against its server's Addr; the deck is driven through pkg/client like any controller
+// would drive it.
+package conformance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/client"
+	"github.com/josh23french/fakedeck/pkg/deck"
+)
+
+// How long to wait for the deck
+var (
+	Timeout = 2 * time.Second        // for each response
+	Settle  = 200 * time.Millisecond // for notifications after each response
+)
+
+// Identity matches the fields where a deck says which unit it is, which are ignored in every case: a deck being checked
+// is never the unit a capture came from, and shouldn't have to claim to be
+var Identity = []string{`model: [^\r\n]*`, `unique id: [^\r\n]*`}
+
+// Case is a capture to check a deck against
+type Case struct {
+	Capture       string   // file name of the capture, in the captures directory
+	Ignore        []string // regexps matching parts of messages that are allowed to differ, like timecodes, besides Identity
+	Notifications bool     // compare notifications as well as responses
+	Diverges      string   // why the deck is known not to match the capture; empty if it should
+}
+
+// Load reads a capture
+func Load(path string) ([]deck.TranscriptEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return deck.ReadTranscript(f)
+}
+
+// Check replays a capture against the deck listening on addr, in a new session, and returns where it differs
+func Check(addr string, entries []deck.TranscriptEntry, opts deck.ReplayOptions) ([]deck.Mismatch, error) {
+	c := client.New(addr)
+	if !c.Connected() {
+		return nil, fmt.Errorf("could not connect to %v", addr)
+	}
+	defer c.Close()
+	return deck.ReplayMessages(c.Send, c.Messages(), entries, opts)
+}
+
+// Run checks the deck listening on addr against each case's capture in dir, as subtests of t. Cases that are known
+// to diverge are skipped with their mismatches logged, unless they've started matching, which fails so the case can
+// be updated.
+func Run(t *testing.T, addr string, dir string, cases []Case) {
+	for _, c := range cases {
+		c := c
+		t.Run(c.Capture, func(t *testing.T) {
+			entries, err := Load(filepath.Join(dir, c.Capture))
+			if err != nil {
+				t.Fatalf("error loading capture: %v", err)
+			}
+			opts := deck.ReplayOptions{
+				Timeout:       Timeout,
+				Settle:        Settle,
+				Notifications: c.Notifications,
+			}
+			for _, expr := range append(append([]string{}, Identity...), c.Ignore...) {
+				opts.Ignore = append(opts.Ignore, regexp.MustCompile(expr))
+			}
+
+			mismatches, err := Check(addr, entries, opts)
+			if err != nil {
+				t.Fatalf("error replaying capture: %v", err)
+			}
+			if c.Diverges != "" {
+				if len(mismatches) == 0 {
+					t.Fatalf("matches now, but is marked as diverging because %v", c.Diverges)
+				}
+				for _, m := range mismatches {
+					t.Log(m)
+				}
+				t.Skipf("known to diverge: %v", c.Diverges)
+			}
+			for _, m := range mismatches {
+				t.Error(m)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package conformance
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/josh23french/fakedeck/pkg/deck"
+	"github.com/josh23french/fakedeck/pkg/protocol"
+)
+
+// memoryDeck is just enough of a deck to check the Server against the captures, without VLC
+type memoryDeck struct {
+	server       *deck.Server
+	remote       deck.RemoteFlags
+	notifyRemote bool
+	sync.Mutex
+}
+
+func (d *memoryDeck) GetModel() string    { return "HyperDeck Studio Mini" }
+func (d *memoryDeck) GetProtocol() string { return "1.11" }
+func (d *memoryDeck) PowerOn()            { d.server.Serve() }
+func (d *memoryDeck) PowerOff()           { d.server.Close() }
+
+func (d *memoryDeck) ProcessCommand(cmd *protocol.Command) string {
+	d.Lock()
+	defer d.Unlock()
+	switch cmd.Name {
+	case "notify":
+		if v, ok := cmd.Parameters["remote"]; ok {
+			d.notifyRemote = v == "true"
+		}
+		return "200 ok"
+	case "remote":
+		v, ok := cmd.Parameters["enable"]
+		if !ok {
+			return "210 remote info:\r\n" + strings.Join(d.remote.Marshall(), "\r\n") + "\r\n"
+		}
+		previous := d.remote
+		d.remote.Enabled = v == "true"
+		if d.remote != previous {
+			go d.server.Notify("510 remote info:\r\n"+strings.Join(d.remote.Marshall(), "\r\n")+"\r\n", d.notifyRemote)
+		}
+		return "200 ok"
+	}
+	return protocol.ErrSyntax
+}
+
+// These captures are written by hand from the example exchanges in Blackmagic's HyperDeck Ethernet Protocol
+// documentation for a HyperDeck Studio Mini, not recorded from one; see testdata/README.md. The ones about the
+// connection check the Server here; all of them check VLCDeck in cmd/vlc_fakedeck.
+var cases = []Case{
+	{Capture: "connection.jsonl"},
+	{Capture: "watchdog.jsonl"},
+	{Capture: "remote.jsonl", Notifications: true},
+	{Capture: "multiline.jsonl"},
+}
+
+func TestServer(t *testing.T) {
+	d := &memoryDeck{remote: deck.RemoteFlags{Enabled: true}}
+	d.server = deck.NewServer(d)
+	d.server.SetAddr("127.0.0.1:0")
+	d.PowerOn()
+	defer d.PowerOff()
+
+	Run(t, d.server.Addr().String(), "testdata", cases)
+}
@@ -0,0 +1,110 @@
+package deck
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// AdminServer serves admin commands on their own port, so test harnesses can change the fake deck's world without
+// going through the HyperDeck protocol, or taking its only connection. It speaks the same line protocol, without
+// the admin prefix, and any number of clients can connect at once.
+type AdminServer struct {
+	addr     string
+	process  func(*protocol.Command) string
+	grammar  protocol.Grammar
+	listener net.Listener
+	quit     chan interface{}
+}
+
+// NewAdminServer constructs an AdminServer that will listen on addr, e.g. ":9994", and answer commands with process
+func NewAdminServer(addr string, process func(*protocol.Command) string, grammar protocol.Grammar) *AdminServer {
+	return &AdminServer{
+		addr:    addr,
+		process: process,
+		grammar: grammar,
+		quit:    make(chan interface{}),
+	}
+}
+
+// Addr returns the address the AdminServer is listening on; nil until it's serving
+func (s *AdminServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Serve starts listening and serving clients in the background
+func (s *AdminServer) Serve() error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("could not start admin server: %w", err)
+	}
+	s.listener = l
+	go func() {
+		<-s.quit
+		err := l.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("error closing admin listener")
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if strings.Contains(err.Error(), "use of closed network connection") {
+					log.Info().Msg("admin server closing...")
+					return
+				}
+				log.Warn().Err(err).Msg("error accepting admin connection")
+				continue
+			}
+			go s.serveConn(conn)
+		}
+	}()
+	return nil
+}
+
+// Close stops listening; connected clients stay connected until they quit
+func (s *AdminServer) Close() {
+	close(s.quit)
+}
+
+func (s *AdminServer) serveConn(c net.Conn) {
+	defer c.Close()
+	log.Info().Msgf("admin client connected from %v", c.RemoteAddr())
+	reader := bufio.NewReader(c)
+	for {
+		req, err := protocol.ReadMessage(reader)
+		if err != nil {
+			log.Info().Msgf("admin client %v went away", c.RemoteAddr())
+			return
+		}
+		log.Info().Msgf("got admin request: %q", req)
+
+		var res string
+		cmd, err := protocol.ParseCommand(req, s.grammar)
+		switch {
+		case err != nil:
+			log.Info().Err(err).Msg("couldn't parse admin request")
+			res = protocol.ErrSyntax
+		case cmd.Name == "ping":
+			res = "200 ok"
+		case cmd.Name == "quit":
+			return
+		default:
+			res = s.process(cmd)
+		}
+
+		_, err = c.Write([]byte(res + "\r\n"))
+		if err != nil {
+			log.Error().Err(err).Msg("error writing admin response")
+			return
+		}
+	}
+}
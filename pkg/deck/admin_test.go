@@ -0,0 +1,41 @@
+package deck
+
+import (
+	"testing"
+
+	"github.com/josh23french/fakedeck/pkg/client"
+	"github.com/josh23french/fakedeck/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminServer(t *testing.T) {
+	r := NewAdminRegistry()
+	r.Register(&CommandSpec{
+		Name:       "input",
+		Parameters: []protocol.Parameter{{Name: "source"}},
+		Handler: func(params map[string]string) string {
+			return "200 ok " + params["source"]
+		},
+	})
+	s := NewAdminServer("127.0.0.1:0", r.Process, r.Keys)
+	require.NoError(t, s.Serve())
+	defer s.Close()
+
+	first := client.New(s.Addr().String())
+	defer first.Close()
+	second := client.New(s.Addr().String())
+	defer second.Close()
+	require.True(t, first.Connected())
+	require.True(t, second.Connected(), "should take more than one client")
+
+	require.NoError(t, first.Send("input: source: My Input.mov"))
+	assert.Equal(t, "200 ok My Input.mov", <-first.Messages(), "should run commands with the registry's grammar")
+	require.NoError(t, second.Send("ping"))
+	assert.Equal(t, "200 ok", <-second.Messages())
+	require.NoError(t, second.Send("a: b:\r\n"))
+	assert.Equal(t, protocol.ErrSyntax, <-second.Messages())
+	require.NoError(t, second.Send("quit"))
+	_, open := <-second.Messages()
+	assert.False(t, open, "should hang up on quit")
+}
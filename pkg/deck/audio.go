@@ -0,0 +1,74 @@
+package deck
+
+import (
+	"fmt"
+	"math"
+)
+
+// AudioInputChannels are the channel counts the deck can be configured for
+var AudioInputChannels = []string{"2", "4", "8", "16"}
+
+// AudioLevels are the peak levels of each channel in dBFS; silence is -Inf
+type AudioLevels []float64
+
+// Silence returns AudioLevels for channels channels of nothing
+func Silence(channels int) AudioLevels {
+	levels := make(AudioLevels, channels)
+	for i := range levels {
+		levels[i] = math.Inf(-1)
+	}
+	return levels
+}
+
+// RouteChannels maps interleaved samples with inChannels channels onto outChannels channels: input channel n goes
+// to output channel n, and any channels beyond outChannels are folded back on (mixed into n % outChannels).
+func RouteChannels(samples []int16, inChannels, outChannels int) []int16 {
+	frames := len(samples) / inChannels
+	routed := make([]int16, frames*outChannels)
+	for frame := 0; frame < frames; frame++ {
+		for ch := 0; ch < inChannels; ch++ {
+			idx := frame*outChannels + ch%outChannels
+			sum := int32(routed[idx]) + int32(samples[frame*inChannels+ch])
+			if sum > math.MaxInt16 {
+				sum = math.MaxInt16
+			} else if sum < math.MinInt16 {
+				sum = math.MinInt16
+			}
+			routed[idx] = int16(sum)
+		}
+	}
+	return routed
+}
+
+// PeakLevels works out the peak level of each channel of interleaved 16-bit samples
+func PeakLevels(samples []int16, channels int) AudioLevels {
+	peaks := make([]int32, channels)
+	for i, sample := range samples {
+		s := int32(sample)
+		if s < 0 {
+			s = -s
+		}
+		if s > peaks[i%channels] {
+			peaks[i%channels] = s
+		}
+	}
+
+	levels := make(AudioLevels, channels)
+	for ch, peak := range peaks {
+		levels[ch] = 20 * math.Log10(float64(peak)/-math.MinInt16)
+	}
+	return levels
+}
+
+// Marshall turns the AudioLevels into a slice of strings
+func (a AudioLevels) Marshall() []string {
+	lines := make([]string, 0)
+	for ch, level := range a {
+		if math.IsInf(level, -1) {
+			lines = append(lines, fmt.Sprintf("channel %v: -inf", ch+1))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("channel %v: %.1f", ch+1, level))
+	}
+	return lines
+}
@@ -0,0 +1,32 @@
+package deck
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteChannels(t *testing.T) {
+	// two frames of four channels
+	samples := []int16{1, 2, 3, 4, 30000, -30000, 10000, -10000}
+
+	assert.Equal(t, []int16{4, 6, 32767, -32768}, RouteChannels(samples, 4, 2), "should fold extra channels back and clip")
+	assert.Equal(t, []int16{1, 2, 3, 4, 0, 0, 0, 0, 30000, -30000, 10000, -10000, 0, 0, 0, 0}, RouteChannels(samples, 4, 8), "should leave extra channels silent")
+}
+
+func TestPeakLevels(t *testing.T) {
+	samples := []int16{16384, 0, -32768, 0, 100, 0}
+	levels := PeakLevels(samples, 2)
+
+	assert.Equal(t, float64(0), levels[0], "full scale should be 0dBFS")
+	assert.True(t, math.IsInf(levels[1], -1), "silence should be -inf")
+
+	joinedLines := strings.Join(levels.Marshall(), "\r\n") + "\r\n"
+	assert.Equal(t, "channel 1: 0.0\r\nchannel 2: -inf\r\n", joinedLines, "should marshall levels correctly")
+
+	joinedLines = strings.Join(PeakLevels([]int16{16384}, 1).Marshall(), "\r\n") + "\r\n"
+	assert.Equal(t, "channel 1: -6.0\r\n", joinedLines, "half scale should be -6dBFS")
+	assert.Equal(t, Silence(2), PeakLevels(nil, 2), "no samples should be silence")
+}
@@ -0,0 +1,106 @@
+package deck
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Cache statuses
+const (
+	CacheIdle      = "idle"      // empty and not recording
+	CacheRecording = "recording" // recording, with room to spare
+	CacheDraining  = "draining"  // not recording, but still writing out to the disk
+	CacheFull      = "full"      // recording faster than the disk can keep up, and out of room
+)
+
+// Cache simulates a record cache: it fills at FillRate while recording and drains to the disk at DrainRate, so
+// it only ever fills up if the disk can't keep up with the recording
+type Cache struct {
+	sync.Mutex
+	Size      float64 // bytes
+	FillRate  float64 // bytes/second coming in while recording
+	DrainRate float64 // bytes/second going out to the disk
+
+	level     float64 // bytes currently in the cache
+	recording bool
+	updated   time.Time // when level was last brought up to date
+}
+
+// NewCache creates an empty Cache
+func NewCache(size, fillRate, drainRate float64) *Cache {
+	return &Cache{
+		Size:      size,
+		FillRate:  fillRate,
+		DrainRate: drainRate,
+	}
+}
+
+// update brings level up to date as of now; must be called with the lock held
+func (c *Cache) update(now time.Time) {
+	if !c.updated.IsZero() {
+		rate := -c.DrainRate
+		if c.recording {
+			rate += c.FillRate
+		}
+		c.level += rate * now.Sub(c.updated).Seconds()
+		c.level = math.Max(0, math.Min(c.Size, c.level))
+	}
+	c.updated = now
+}
+
+// SetRecording starts or stops filling the cache
+func (c *Cache) SetRecording(now time.Time, recording bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.update(now)
+	c.recording = recording
+}
+
+// Level returns how many bytes are in the cache
+func (c *Cache) Level(now time.Time) float64 {
+	c.Lock()
+	defer c.Unlock()
+	c.update(now)
+	return c.level
+}
+
+// Status returns one of the Cache statuses
+func (c *Cache) Status(now time.Time) string {
+	c.Lock()
+	defer c.Unlock()
+	c.update(now)
+	switch {
+	case c.recording && c.level >= c.Size:
+		return CacheFull
+	case c.recording:
+		return CacheRecording
+	case c.level > 0:
+		return CacheDraining
+	}
+	return CacheIdle
+}
+
+// TimeUntilFull returns how much longer a recording could go before the cache fills up; ok is false if the disk
+// keeps up, so the cache never fills
+func (c *Cache) TimeUntilFull(now time.Time) (remaining time.Duration, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.update(now)
+	net := c.FillRate - c.DrainRate
+	if net <= 0 {
+		return 0, false
+	}
+	return time.Duration((c.Size - c.level) / net * float64(time.Second)), true
+}
+
+// Marshall turns the Cache into a slice of strings. recordingTimeRemaining is passed in because it also depends
+// on how much room is left on the disk.
+func (c *Cache) Marshall(now time.Time, recordingTimeRemaining time.Duration) []string {
+	lines := make([]string, 0)
+	lines = append(lines, fmt.Sprintf("cache status: %v", c.Status(now)))
+	lines = append(lines, fmt.Sprintf("cache size: %v", int64(c.Size/1000000)))
+	lines = append(lines, fmt.Sprintf("recording time remaining: %v", int64(recordingTimeRemaining.Seconds())))
+	return lines
+}
@@ -0,0 +1,43 @@
+package deck
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewCache(100e6, 30e6, 20e6) // 100MB, filling 10MB/s faster than it drains
+
+	assert.Equal(t, CacheIdle, cache.Status(start), "should start idle")
+
+	cache.SetRecording(start, true)
+	assert.Equal(t, CacheRecording, cache.Status(start.Add(5*time.Second)), "should be recording")
+	assert.Equal(t, 50e6, cache.Level(start.Add(5*time.Second)), "should fill at the difference between the rates")
+	remaining, ok := cache.TimeUntilFull(start.Add(5 * time.Second))
+	assert.True(t, ok, "should fill up eventually")
+	assert.Equal(t, 5*time.Second, remaining, "should fill up in 5 more seconds")
+
+	assert.Equal(t, CacheFull, cache.Status(start.Add(20*time.Second)), "should fill up")
+	assert.Equal(t, 100e6, cache.Level(start.Add(20*time.Second)), "should not overfill")
+
+	cache.SetRecording(start.Add(20*time.Second), false)
+	assert.Equal(t, CacheDraining, cache.Status(start.Add(21*time.Second)), "should drain after recording")
+	assert.Equal(t, CacheIdle, cache.Status(start.Add(30*time.Second)), "should be idle once drained")
+
+	joinedLines := strings.Join(cache.Marshall(start.Add(30*time.Second), 90*time.Second), "\r\n") + "\r\n"
+	assert.Equal(t, "cache status: idle\r\ncache size: 100\r\nrecording time remaining: 90\r\n", joinedLines, "should marshall cache correctly")
+}
+
+func TestCacheKeepsUp(t *testing.T) {
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewCache(100e6, 20e6, 30e6)
+
+	cache.SetRecording(start, true)
+	assert.Equal(t, float64(0), cache.Level(start.Add(time.Hour)), "should stay empty when the disk keeps up")
+	_, ok := cache.TimeUntilFull(start.Add(time.Hour))
+	assert.False(t, ok, "should never fill up when the disk keeps up")
+}
@@ -0,0 +1,239 @@
+package deck
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is where a deck gets the time from, so tests can control it instead of waiting for it
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a Clock's version of *time.Timer
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a Clock's version of *time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock is the Clock on the wall
+type RealClock struct{}
+
+func (RealClock) Now() time.Time        { return time.Now() }
+func (RealClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func (RealClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (RealClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// FakeClock only moves when it's told to with Advance, so everything waiting on it happens in a known order
+type FakeClock struct {
+	sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	seq     uint64 // orders waiters that are due at the same time by when they started waiting
+	changed chan struct{}
+}
+
+// fakeWaiter is a sleep, timer or ticker waiting for the FakeClock to reach its deadline
+type fakeWaiter struct {
+	clock    *FakeClock
+	deadline time.Time
+	seq      uint64
+	period   time.Duration  // for tickers; 0 for everything else
+	fn       func()         // for timers
+	done     chan struct{}  // for sleeps
+	ticks    chan time.Time // for tickers
+	active   bool           // guarded by the clock's lock
+}
+
+// NewFakeClock creates a FakeClock that reads now until it's advanced
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+// Sleep blocks until the clock has been advanced by d
+func (c *FakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	w := &fakeWaiter{done: make(chan struct{})}
+	c.add(w, d)
+	<-w.done
+}
+
+// AfterFunc calls f once the clock has been advanced by d. Unlike time.AfterFunc, f is called on the goroutine
+// that advances the clock, so it's finished by the time Advance returns.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	w := &fakeWaiter{fn: f}
+	c.add(w, d)
+	return w
+}
+
+// NewTicker ticks each time the clock is advanced past another d. Like time.Ticker, ticks are dropped if nothing's
+// receiving them.
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	w := &fakeWaiter{period: d, ticks: make(chan time.Time, 1)}
+	c.add(w, d)
+	return fakeTicker{w}
+}
+
+// add starts w waiting for d from now
+func (c *FakeClock) add(w *fakeWaiter, d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	w.clock = c
+	c.schedule(w, c.now.Add(d))
+}
+
+// schedule (re)starts w waiting for deadline; the lock must be held
+func (c *FakeClock) schedule(w *fakeWaiter, deadline time.Time) {
+	c.seq++
+	w.deadline = deadline
+	w.seq = c.seq
+	if !w.active {
+		w.active = true
+		c.waiters = append(c.waiters, w)
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// remove stops w waiting; the lock must be held. It returns whether w was waiting.
+func (c *FakeClock) remove(w *fakeWaiter) bool {
+	if !w.active {
+		return false
+	}
+	w.active = false
+	for idx, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:idx], c.waiters[idx+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Advance moves the clock forward by d, waking everything that's due along the way in deadline order. The clock reads
+// each one's deadline as it's woken, and timers set while advancing fire too if they're due before the end.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool {
+			a, b := c.waiters[i], c.waiters[j]
+			if !a.deadline.Equal(b.deadline) {
+				return a.deadline.Before(b.deadline)
+			}
+			return a.seq < b.seq
+		})
+		if len(c.waiters) == 0 || c.waiters[0].deadline.After(end) {
+			break
+		}
+		w := c.waiters[0]
+		if w.deadline.After(c.now) {
+			c.now = w.deadline
+		}
+		if w.period > 0 {
+			c.schedule(w, w.deadline.Add(w.period))
+			select {
+			case w.ticks <- c.now:
+			default:
+			}
+			continue
+		}
+		c.remove(w)
+		if w.done != nil {
+			close(w.done)
+			continue
+		}
+		c.Unlock()
+		w.fn()
+		c.Lock()
+	}
+	c.now = end
+	c.Unlock()
+}
+
+// Waiters returns how many sleeps, timers and tickers are waiting on the clock
+func (c *FakeClock) Waiters() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until at least n sleeps, timers and tickers are waiting on the clock, so a test knows the code it's
+// testing has got as far as waiting before it advances the clock
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.Lock()
+		waiting := len(c.waiters)
+		changed := c.changed
+		c.Unlock()
+		if waiting >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.Lock()
+	defer w.clock.Unlock()
+	return w.clock.remove(w)
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	w.clock.Lock()
+	defer w.clock.Unlock()
+	active := w.active
+	w.clock.schedule(w, w.clock.now.Add(d))
+	return active
+}
+
+// fakeTicker is a fakeWaiter with a ticker's methods
+type fakeTicker struct {
+	w *fakeWaiter
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.w.ticks
+}
+
+func (t fakeTicker) Stop() {
+	t.w.Stop()
+}
@@ -0,0 +1,109 @@
+package deck
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	fired := make([]time.Duration, 0)
+	after := func(d time.Duration) Timer {
+		return clock.AfterFunc(d, func() {
+			fired = append(fired, clock.Now().Sub(start))
+		})
+	}
+	after(300 * time.Millisecond)
+	after(100 * time.Millisecond)
+	stopped := after(200 * time.Millisecond)
+	clock.AfterFunc(150*time.Millisecond, func() {
+		after(100 * time.Millisecond) // due at 250ms, while still advancing
+	})
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop(), "should only stop once")
+
+	clock.Advance(299 * time.Millisecond)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 250 * time.Millisecond}, fired, "should fire due timers in order")
+	assert.Equal(t, start.Add(299*time.Millisecond), clock.Now())
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 250 * time.Millisecond, 300 * time.Millisecond}, fired)
+
+	woken := make(chan time.Time)
+	go func() {
+		clock.Sleep(time.Second)
+		woken <- clock.Now()
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-woken:
+		t.Fatal("should not wake before the clock's been advanced enough")
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, start.Add(1300*time.Millisecond), <-woken)
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	ticker := clock.NewTicker(time.Second)
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("should not tick early")
+	default:
+	}
+	clock.Advance(2 * time.Second)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("should drop ticks nothing was waiting for")
+	default:
+	}
+	ticker.Stop()
+	assert.Equal(t, 0, clock.Waiters())
+}
+
+func TestServerClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	s := NewServer(okDeck{})
+	s.SetClock(clock)
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.serveConn(conn)
+	r := bufio.NewReader(client)
+	_, err := protocol.ReadMessage(r)
+	require.NoError(t, err)
+
+	client.Write([]byte("watchdog: period: 2\r\n"))
+	msg, err := protocol.ReadMessage(r)
+	require.NoError(t, err)
+	assert.Equal(t, "200 ok", msg)
+
+	// responding doesn't wait on the clock, so commands are answered without it being advanced
+	for i := 0; i < 3; i++ {
+		client.Write([]byte("ping\r\n"))
+		msg, err = protocol.ReadMessage(r)
+		require.NoError(t, err)
+		assert.Equal(t, "200 ok", msg, "should answer without the clock moving")
+	}
+	assert.Equal(t, 1, clock.Waiters(), "only the watchdog should be waiting on the clock")
+
+	clock.Advance(1900 * time.Millisecond)
+	client.Write([]byte("ping\r\n"))
+	msg, err = protocol.ReadMessage(r)
+	require.NoError(t, err)
+	assert.Equal(t, "200 ok", msg, "should still be connected until the watchdog period passes")
+
+	clock.Advance(2 * time.Second)
+	_, err = protocol.ReadMessage(r)
+	assert.Error(t, err, "should hang up once the period passes without a command")
+}
@@ -21,6 +21,22 @@ type NotifyFlags struct {
 	Cache            bool
 }
 
+// Marshall turns the NotifyFlags into a slice of strings, named like the notify command's parameters
+func (n *NotifyFlags) Marshall() []string {
+	lines := make([]string, 0)
+	lines = append(lines, fmt.Sprintf("transport: %v", n.Transport))
+	lines = append(lines, fmt.Sprintf("slot: %v", n.Slot))
+	lines = append(lines, fmt.Sprintf("remote: %v", n.Remote))
+	lines = append(lines, fmt.Sprintf("configuration: %v", n.Configuration))
+	lines = append(lines, fmt.Sprintf("dropped frames: %v", n.DroppedFrames))
+	lines = append(lines, fmt.Sprintf("display timecode: %v", n.DisplayTimecode))
+	lines = append(lines, fmt.Sprintf("timeline position: %v", n.TimelinePosition))
+	lines = append(lines, fmt.Sprintf("playrange: %v", n.PlayRange))
+	lines = append(lines, fmt.Sprintf("cache: %v", n.Cache))
+	lines = append(lines, fmt.Sprintf("dynamic range: %v", n.DynamicRange))
+	return lines
+}
+
 // Video Formats, prefixed with VideoFormat because apparently starting a const with a number is illegal now... :(
 const (
 	// SD
@@ -50,12 +66,38 @@ const (
 	// No 4Kp60 ???
 )
 
+// Dynamic ranges, as they're named in the protocol
+const (
+	DynamicRangeRec709     = "Rec709"
+	DynamicRangeRec2020SDR = "Rec2020_SDR"
+	DynamicRangeHLG        = "HLG"
+	DynamicRangePQ300      = "ST2084_300"
+	DynamicRangePQ500      = "ST2084_500"
+	DynamicRangePQ800      = "ST2084_800"
+	DynamicRangePQ1000     = "ST2084_1000"
+	DynamicRangePQ2000     = "ST2084_2000"
+	DynamicRangePQ4000     = "ST2084_4000"
+)
+
 // RemoteFlags keeps the state of the Deck's remote functionality...
 type RemoteFlags struct {
 	Enabled  bool
 	Override bool
 }
 
+// Allowed returns whether remote control commands should be obeyed
+func (r *RemoteFlags) Allowed() bool {
+	return r.Enabled || r.Override
+}
+
+// Marshall turns the RemoteFlags into a slice of strings
+func (r *RemoteFlags) Marshall() []string {
+	lines := make([]string, 0)
+	lines = append(lines, fmt.Sprintf("enabled: %v", r.Enabled))
+	lines = append(lines, fmt.Sprintf("override: %v", r.Override))
+	return lines
+}
+
 // Drive represents a drive you can insert into a slot
 type Drive struct {
 	VolumeName string
@@ -147,6 +189,24 @@ func (t *Transport) Marshall() []string {
 	return lines
 }
 
+// PlayRange represents the part of the timeline playback is constrained to
+type PlayRange struct {
+	In          Timecode
+	Out         Timecode
+	TimelineIn  int64
+	TimelineOut int64
+}
+
+// Marshall turns the PlayRange into a slice of strings
+func (p *PlayRange) Marshall() []string {
+	lines := make([]string, 0)
+	lines = append(lines, fmt.Sprintf("in: %v", p.In))
+	lines = append(lines, fmt.Sprintf("out: %v", p.Out))
+	lines = append(lines, fmt.Sprintf("timeline in: %v", p.TimelineIn))
+	lines = append(lines, fmt.Sprintf("timeline out: %v", p.TimelineOut))
+	return lines
+}
+
 // Deck represents the deck state
 type Deck interface {
 	GetModel() string                        // returns the model of the deck
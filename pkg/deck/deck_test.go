@@ -20,3 +20,38 @@ func TestSlotMarshall(t *testing.T) {
 	joinedLines := strings.Join(slot.Marshall(), "\r\n") + "\r\n"
 	assert.Equal(t, "slot id: 1\r\nstatus: empty\r\nvolume name: \r\nrecording time: 0\r\nvideo format: 720p5994\r\n", joinedLines, "should marshall slot correctly")
 }
+
+func TestRemoteFlags(t *testing.T) {
+	remote := &RemoteFlags{
+		Enabled:  false,
+		Override: false,
+	}
+	assert.False(t, remote.Allowed(), "should not allow remote control when disabled")
+
+	remote.Override = true
+	assert.True(t, remote.Allowed(), "should allow remote control when overridden")
+
+	joinedLines := strings.Join(remote.Marshall(), "\r\n") + "\r\n"
+	assert.Equal(t, "enabled: false\r\noverride: true\r\n", joinedLines, "should marshall remote flags correctly")
+}
+
+func TestNotifyFlagsMarshall(t *testing.T) {
+	notify := &NotifyFlags{Transport: true, DynamicRange: true}
+
+	joinedLines := strings.Join(notify.Marshall(), "\r\n") + "\r\n"
+	assert.Equal(t, "transport: true\r\nslot: false\r\nremote: false\r\nconfiguration: false\r\ndropped frames: false\r\n"+
+		"display timecode: false\r\ntimeline position: false\r\nplayrange: false\r\ncache: false\r\ndynamic range: true\r\n",
+		joinedLines, "should marshall notify flags correctly")
+}
+
+func TestPlayRangeMarshall(t *testing.T) {
+	playRange := &PlayRange{
+		In:          "00:00:01:00",
+		Out:         "00:00:05:00",
+		TimelineIn:  60,
+		TimelineOut: 300,
+	}
+
+	joinedLines := strings.Join(playRange.Marshall(), "\r\n") + "\r\n"
+	assert.Equal(t, "in: 00:00:01:00\r\nout: 00:00:05:00\r\ntimeline in: 60\r\ntimeline out: 300\r\n", joinedLines, "should marshall play range correctly")
+}
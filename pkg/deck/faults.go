@@ -0,0 +1,245 @@
+package deck
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+)
+
+// FaultSettings are the ways a deck should misbehave. The zero value misbehaves in no way at all.
+type FaultSettings struct {
+	Latency           time.Duration // added before every response
+	DropPercent       int           // chance of a response never being sent
+	TruncatePercent   int           // chance of a response being cut off partway
+	DisconnectPercent int           // chance of hanging up partway through a response
+	ErrorPercent      int           // chance of a command failing with 108 internal error instead of being run
+	NotifyDelay       time.Duration // added before every notification
+	DuplicatePercent  int           // chance of a notification being sent twice
+	DiskErrorSlots    []int         // slots whose commands fail with 106 disk error
+}
+
+// FaultParameters are the parameters of the admin command that configures Faults
+var FaultParameters = []protocol.Parameter{
+	{Name: "latency", Type: protocol.IntRange(0, 60000)},
+	{Name: "drop", Type: protocol.IntRange(0, 100)},
+	{Name: "truncate", Type: protocol.IntRange(0, 100)},
+	{Name: "disconnect", Type: protocol.IntRange(0, 100)},
+	{Name: "error", Type: protocol.IntRange(0, 100)},
+	{Name: "notify delay", Type: protocol.IntRange(0, 60000)},
+	{Name: "duplicate", Type: protocol.IntRange(0, 100)},
+	{Name: "disk error"}, // comma-separated slot IDs, or none
+	{Name: "clear", Type: protocol.Bool},
+}
+
+// DiskCommands use the disk in the current slot, so they fail when there's something wrong with it
+var DiskCommands = map[string]bool{
+	"record":      true,
+	"play":        true,
+	"goto":        true,
+	"disk list":   true,
+	"clips get":   true,
+	"clips count": true,
+	"clips add":   true,
+	"clips clear": true,
+	"format":      true,
+}
+
+// responseFault is what to do wrong with a response
+type responseFault int
+
+const (
+	faultNone responseFault = iota
+	faultDrop
+	faultTruncate
+	faultDisconnect
+)
+
+// Faults makes a deck misbehave on purpose, the way real decks sometimes do, to test controllers against. A nil
+// *Faults never does anything wrong.
+type Faults struct {
+	sync.Mutex
+	settings FaultSettings
+	rand     *rand.Rand
+}
+
+// NewFaults creates Faults that don't do anything wrong until they're set, using seed to decide when to
+func NewFaults(seed int64) *Faults {
+	return &Faults{
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Set replaces the fault settings
+func (f *Faults) Set(settings FaultSettings) {
+	f.Lock()
+	defer f.Unlock()
+	f.settings = settings
+}
+
+// Settings returns the current fault settings
+func (f *Faults) Settings() FaultSettings {
+	f.Lock()
+	defer f.Unlock()
+	return f.settings
+}
+
+// Configure changes the settings named by an admin command's parameters, which should already have been validated
+// against FaultParameters, returning a response for the command
+func (f *Faults) Configure(params map[string]string) string {
+	f.Lock()
+	defer f.Unlock()
+	settings := f.settings
+	if params["clear"] == "true" {
+		settings = FaultSettings{}
+	}
+	if slots, ok := params["disk error"]; ok {
+		settings.DiskErrorSlots = nil
+		if slots != "none" {
+			for _, str := range strings.Split(slots, ",") {
+				slotID, err := strconv.Atoi(strings.TrimSpace(str))
+				if err != nil || slotID < 1 {
+					return protocol.ErrInvalidValue
+				}
+				settings.DiskErrorSlots = append(settings.DiskErrorSlots, slotID)
+			}
+			sort.Ints(settings.DiskErrorSlots)
+		}
+	}
+	for name, val := range params {
+		n, _ := strconv.Atoi(val)
+		switch name {
+		case "latency":
+			settings.Latency = time.Duration(n) * time.Millisecond
+		case "drop":
+			settings.DropPercent = n
+		case "truncate":
+			settings.TruncatePercent = n
+		case "disconnect":
+			settings.DisconnectPercent = n
+		case "error":
+			settings.ErrorPercent = n
+		case "notify delay":
+			settings.NotifyDelay = time.Duration(n) * time.Millisecond
+		case "duplicate":
+			settings.DuplicatePercent = n
+		}
+	}
+	f.settings = settings
+	return "200 ok"
+}
+
+// Marshall turns the fault settings into a slice of strings, in the units Configure takes
+func (f *Faults) Marshall() []string {
+	settings := f.Settings()
+	diskError := "none"
+	if len(settings.DiskErrorSlots) > 0 {
+		slots := make([]string, len(settings.DiskErrorSlots))
+		for idx, slotID := range settings.DiskErrorSlots {
+			slots[idx] = strconv.Itoa(slotID)
+		}
+		diskError = strings.Join(slots, ",")
+	}
+
+	lines := make([]string, 0)
+	lines = append(lines, fmt.Sprintf("latency: %v", settings.Latency.Milliseconds()))
+	lines = append(lines, fmt.Sprintf("drop: %v", settings.DropPercent))
+	lines = append(lines, fmt.Sprintf("truncate: %v", settings.TruncatePercent))
+	lines = append(lines, fmt.Sprintf("disconnect: %v", settings.DisconnectPercent))
+	lines = append(lines, fmt.Sprintf("error: %v", settings.ErrorPercent))
+	lines = append(lines, fmt.Sprintf("notify delay: %v", settings.NotifyDelay.Milliseconds()))
+	lines = append(lines, fmt.Sprintf("duplicate: %v", settings.DuplicatePercent))
+	lines = append(lines, fmt.Sprintf("disk error: %v", diskError))
+	return lines
+}
+
+// chance returns true percent% of the time; the lock must be held
+func (f *Faults) chance(percent int) bool {
+	return percent > 0 && f.rand.Intn(100) < percent
+}
+
+// command returns the failure a command should get instead of being run, or "" if it should run. Admin commands
+// always run.
+func (f *Faults) command(cmd *protocol.Command, currentSlot func() int) string {
+	if f == nil || strings.HasPrefix(cmd.Name, AdminPrefix) {
+		return ""
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.chance(f.settings.ErrorPercent) {
+		return protocol.ErrInternal
+	}
+	for _, slotID := range f.settings.DiskErrorSlots {
+		if cmd.Parameters["slot id"] == strconv.Itoa(slotID) {
+			return protocol.ErrDiskError
+		}
+		if DiskCommands[cmd.Name] && currentSlot != nil && currentSlot() == slotID {
+			return protocol.ErrDiskError
+		}
+	}
+	return ""
+}
+
+// response decides what to do wrong with the response to a command: how long to wait before sending it, and whether
+// to drop it, cut it off, or hang up partway through. For the last two, it's cut off after cut bytes. Admin commands
+// are left alone, so faults can always be cleared.
+func (f *Faults) response(cmd *protocol.Command, length int) (latency time.Duration, fault responseFault, cut int) {
+	if f == nil || (cmd != nil && strings.HasPrefix(cmd.Name, AdminPrefix)) {
+		return 0, faultNone, length
+	}
+	f.Lock()
+	defer f.Unlock()
+	latency = f.settings.Latency
+	switch {
+	case f.chance(f.settings.DropPercent):
+		return latency, faultDrop, 0
+	case f.chance(f.settings.DisconnectPercent):
+		return latency, faultDisconnect, f.rand.Intn(length)
+	case f.chance(f.settings.TruncatePercent):
+		return latency, faultTruncate, f.rand.Intn(length)
+	}
+	return latency, faultNone, length
+}
+
+// notification decides how long to wait before sending a notification, and how many times to send it
+func (f *Faults) notification() (delay time.Duration, copies int) {
+	if f == nil {
+		return 0, 1
+	}
+	f.Lock()
+	defer f.Unlock()
+	copies = 1
+	if f.chance(f.settings.DuplicatePercent) {
+		copies = 2
+	}
+	return f.settings.NotifyDelay, copies
+}
+
+// faultyDeck is a Deck that fails commands the way its Faults say to
+type faultyDeck struct {
+	Deck
+	faults      *Faults
+	currentSlot func() int
+}
+
+// WithFaults wraps d so its commands fail the way faults say to. currentSlot returns the slot the deck has selected,
+// so disk errors can fail commands that use it; if it's nil, only commands naming a slot get disk errors.
+func WithFaults(d Deck, faults *Faults, currentSlot func() int) Deck {
+	return &faultyDeck{
+		Deck:        d,
+		faults:      faults,
+		currentSlot: currentSlot,
+	}
+}
+
+func (d *faultyDeck) ProcessCommand(cmd *protocol.Command) string {
+	if res := d.faults.command(cmd, d.currentSlot); res != "" {
+		return res
+	}
+	return d.Deck.ProcessCommand(cmd)
+}
@@ -0,0 +1,125 @@
+package deck
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// okDeck says ok to everything
+type okDeck struct{}
+
+func (okDeck) GetModel() string                        { return "OK" }
+func (okDeck) GetProtocol() string                     { return "1.11" }
+func (okDeck) PowerOn()                                {}
+func (okDeck) PowerOff()                               {}
+func (okDeck) ProcessCommand(*protocol.Command) string { return "200 ok" }
+
+func TestFaultsConfigure(t *testing.T) {
+	f := NewFaults(1)
+	assert.Equal(t, "200 ok", f.Configure(map[string]string{"latency": "250", "drop": "10", "disk error": "2,1"}))
+	assert.Equal(t, FaultSettings{Latency: 250 * time.Millisecond, DropPercent: 10, DiskErrorSlots: []int{1, 2}}, f.Settings())
+	assert.Equal(t, "latency: 250\r\ndrop: 10\r\ntruncate: 0\r\ndisconnect: 0\r\nerror: 0\r\nnotify delay: 0\r\nduplicate: 0\r\ndisk error: 1,2\r\n",
+		strings.Join(f.Marshall(), "\r\n")+"\r\n", "should marshall in the units it's configured in")
+
+	assert.Equal(t, protocol.ErrInvalidValue, f.Configure(map[string]string{"disk error": "one"}))
+	assert.Equal(t, "200 ok", f.Configure(map[string]string{"clear": "true", "error": "5"}))
+	assert.Equal(t, FaultSettings{ErrorPercent: 5}, f.Settings(), "should clear before setting anything else")
+}
+
+func TestWithFaults(t *testing.T) {
+	f := NewFaults(1)
+	currentSlot := 1
+	d := WithFaults(okDeck{}, f, func() int { return currentSlot })
+	play := &protocol.Command{Name: "play", Parameters: map[string]string{}}
+
+	assert.Equal(t, "200 ok", d.ProcessCommand(play), "should do nothing wrong by default")
+
+	f.Set(FaultSettings{DiskErrorSlots: []int{2}})
+	assert.Equal(t, "200 ok", d.ProcessCommand(play))
+	assert.Equal(t, protocol.ErrDiskError, d.ProcessCommand(&protocol.Command{Name: "slot info", Parameters: map[string]string{"slot id": "2"}}), "should fail commands naming the slot")
+	currentSlot = 2
+	assert.Equal(t, protocol.ErrDiskError, d.ProcessCommand(play), "should fail disk commands on the current slot")
+	assert.Equal(t, "200 ok", d.ProcessCommand(&protocol.Command{Name: "remote", Parameters: map[string]string{}}), "should leave other commands alone")
+
+	f.Set(FaultSettings{ErrorPercent: 100})
+	assert.Equal(t, protocol.ErrInternal, d.ProcessCommand(play))
+	assert.Equal(t, "200 ok", d.ProcessCommand(&protocol.Command{Name: AdminPrefix + "faults", Parameters: map[string]string{}}), "should never fail admin commands")
+}
+
+func TestServerFaults(t *testing.T) {
+	f := NewFaults(1)
+	s := NewServer(okDeck{})
+	s.SetFaults(f)
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.serveConn(conn)
+	r := bufio.NewReader(client)
+	_, err := protocol.ReadMessage(r)
+	require.NoError(t, err)
+
+	f.Set(FaultSettings{DropPercent: 100})
+	client.Write([]byte("ping\r\n" + AdminPrefix + "faults: clear: true\r\n"))
+	msg, err := protocol.ReadMessage(r)
+	require.NoError(t, err)
+	assert.Equal(t, "200 ok", msg, "should only answer the admin command")
+
+	f.Set(FaultSettings{DisconnectPercent: 100})
+	client.Write([]byte("ping\r\n"))
+	_, err = r.ReadString('\n')
+	assert.Error(t, err, "should hang up before the end of the response")
+}
+
+func TestServerFaultsTranscript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fakedeck-transcripts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	f := NewFaults(1)
+	s := NewServer(okDeck{})
+	s.SetFaults(f)
+	s.SetTranscriptDir(dir)
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.serveConn(conn)
+	r := bufio.NewReader(client)
+	_, err = protocol.ReadMessage(r)
+	require.NoError(t, err)
+
+	f.Set(FaultSettings{DropPercent: 100})
+	client.Write([]byte("ping\r\n" + AdminPrefix + "faults: clear: true\r\n"))
+	_, err = protocol.ReadMessage(r)
+	require.NoError(t, err)
+	f.Set(FaultSettings{DisconnectPercent: 100})
+	client.Write([]byte("ping\r\n"))
+	sent, _ := ioutil.ReadAll(r)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	file, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer file.Close()
+	entries, err := ReadTranscript(file)
+	require.NoError(t, err)
+
+	kinds := make([]string, 0)
+	for _, entry := range entries[1:] { // after the connection info
+		kinds = append(kinds, entry.Kind)
+	}
+	expected := []string{TranscriptCommand, TranscriptCommand, TranscriptResponse, TranscriptCommand}
+	if len(sent) > 0 {
+		expected = append(expected, TranscriptResponse)
+		assert.Equal(t, strings.TrimSuffix(string(sent), "\r\n"), entries[len(entries)-1].Text,
+			"should record as much of the response as was sent before hanging up")
+	}
+	assert.Equal(t, expected, kinds, "shouldn't record the dropped response")
+}
@@ -0,0 +1,186 @@
+package deck
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+)
+
+// HandlerFunc responds to a command whose parameters have already been validated
+type HandlerFunc func(params map[string]string) string
+
+// CommandSpec describes a single command: what it's called, what it takes, and what handles it
+type CommandSpec struct {
+	Name        string
+	Description string
+	Parameters  []protocol.Parameter
+	Handler     HandlerFunc // nil for commands handled at the connection level (ping, watchdog, quit)
+	Remote      bool        // true if the command changes the transport, and so is refused while remote is disabled
+}
+
+// Validate checks the given parameters against the spec, returning a failure response or "" if they're fine
+func (c *CommandSpec) Validate(params map[string]string) string {
+	return protocol.ValidateParameters(c.Parameters, params)
+}
+
+// Usage returns the command the way it's shown in help, e.g. "play: speed: {-1600...1600} loop: {true|false}"
+func (c *CommandSpec) Usage() string {
+	if len(c.Parameters) == 0 {
+		return c.Name
+	}
+	usage := c.Name + ":"
+	for _, param := range c.Parameters {
+		usage += " " + param.Usage()
+	}
+	return usage
+}
+
+// WatchdogParameters are the parameters of the watchdog command, which the Server handles itself
+var WatchdogParameters = []protocol.Parameter{
+	{Name: "period", Type: protocol.IntRange(0, math.MaxInt32)},
+}
+
+// Registry is the set of commands a Deck responds to
+type Registry struct {
+	commands []*CommandSpec // registration order, so help comes out in a sensible order
+	byName   map[string]*CommandSpec
+	remote   *RemoteFlags // nil means remote control is always allowed
+}
+
+// NewRegistry creates a Registry that already knows about help, commands, and the connection-level commands
+func NewRegistry() *Registry {
+	r := newRegistry()
+	r.Register(&CommandSpec{
+		Name:        "ping",
+		Description: "check device is responding",
+	})
+	r.Register(&CommandSpec{
+		Name:        "watchdog",
+		Description: "client connection timeout in seconds",
+		Parameters:  WatchdogParameters,
+	})
+	r.Register(&CommandSpec{
+		Name:        "quit",
+		Description: "disconnect ethernet control",
+	})
+	return r
+}
+
+// AdminPrefix starts the names of admin commands, which control the fake deck itself, when they're sent over the
+// HyperDeck protocol
+const AdminPrefix = "admin "
+
+// NewAdminRegistry creates a Registry for commands that control the fake deck itself rather than the HyperDeck
+// it's pretending to be. It only knows about help and commands to start with.
+func NewAdminRegistry() *Registry {
+	return newRegistry()
+}
+
+func newRegistry() *Registry {
+	r := &Registry{
+		commands: make([]*CommandSpec, 0),
+		byName:   make(map[string]*CommandSpec),
+	}
+	r.Register(&CommandSpec{
+		Name:        "help",
+		Description: "the command list",
+		Handler: func(params map[string]string) string {
+			return r.Help()
+		},
+	})
+	r.Register(&CommandSpec{
+		Name:        "commands",
+		Description: "the command list in XML format",
+		Handler: func(params map[string]string) string {
+			return r.Commands()
+		},
+	})
+	return r
+}
+
+// Register adds a command to the Registry, replacing any existing command of the same name
+func (r *Registry) Register(spec *CommandSpec) {
+	if _, ok := r.byName[spec.Name]; ok {
+		for idx, existing := range r.commands {
+			if existing.Name == spec.Name {
+				r.commands[idx] = spec
+			}
+		}
+	} else {
+		r.commands = append(r.commands, spec)
+	}
+	r.byName[spec.Name] = spec
+}
+
+// RequireRemote makes Remote commands fail with 111 remote control disabled whenever remote doesn't allow them
+func (r *Registry) RequireRemote(remote *RemoteFlags) {
+	r.remote = remote
+}
+
+// Lookup finds a command by name
+func (r *Registry) Lookup(name string) (*CommandSpec, bool) {
+	spec, ok := r.byName[name]
+	return spec, ok
+}
+
+// Keys returns the names of a command's parameters, or nil if there's no such command; it's a protocol.Grammar
+func (r *Registry) Keys(name string) []string {
+	spec, ok := r.Lookup(name)
+	if !ok || len(spec.Parameters) == 0 {
+		return nil
+	}
+	keys := make([]string, len(spec.Parameters))
+	for idx, param := range spec.Parameters {
+		keys[idx] = param.Name
+	}
+	return keys
+}
+
+// Process validates the command against its spec and runs its handler
+func (r *Registry) Process(cmd *protocol.Command) string {
+	spec, ok := r.Lookup(cmd.Name)
+	if !ok || spec.Handler == nil {
+		return protocol.ErrUnsupported
+	}
+	if res := spec.Validate(cmd.Parameters); res != "" {
+		return res
+	}
+	if spec.Remote && r.remote != nil && !r.remote.Allowed() {
+		return protocol.ErrRemoteControlDisabled
+	}
+	return spec.Handler(cmd.Parameters)
+}
+
+// Help generates the 201 help response
+func (r *Registry) Help() string {
+	width := 0
+	for _, spec := range r.commands {
+		if len(spec.Usage()) > width {
+			width = len(spec.Usage())
+		}
+	}
+
+	output := "201 help:\r\n"
+	for _, spec := range r.commands {
+		output += fmt.Sprintf("%-*v  %v\r\n", width, spec.Usage(), spec.Description)
+	}
+	return output
+}
+
+// Commands generates the 212 commands response
+func (r *Registry) Commands() string {
+	output := "212 commands:\r\n<commands>\r\n"
+	for _, spec := range r.commands {
+		if len(spec.Parameters) == 0 {
+			output += fmt.Sprintf("    <command name=\"%v\"/>\r\n", spec.Name)
+			continue
+		}
+		output += fmt.Sprintf("    <command name=\"%v\">\r\n", spec.Name)
+		for _, param := range spec.Parameters {
+			output += fmt.Sprintf("        <parameter name=\"%v\"/>\r\n", param.Name)
+		}
+		output += "    </command>\r\n"
+	}
+	return output + "</commands>\r\n"
+}
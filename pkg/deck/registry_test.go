@@ -0,0 +1,100 @@
+package deck
+
+import (
+	"testing"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func command(t *testing.T, str string) *protocol.Command {
+	cmd, err := protocol.CommandFromString(str)
+	require.NoError(t, err)
+	return cmd
+}
+
+func testRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(&CommandSpec{
+		Name:        "play",
+		Description: "play from current timecode",
+		Parameters: []protocol.Parameter{
+			{Name: "speed", Type: protocol.IntRange(-1600, 1600)},
+			{Name: "loop", Type: protocol.Bool},
+		},
+		Handler: func(params map[string]string) string {
+			return "200 ok"
+		},
+		Remote: true,
+	})
+	return r
+}
+
+func TestRegistryProcess(t *testing.T) {
+	r := testRegistry()
+
+	assert.Equal(t, "200 ok", r.Process(command(t, "play: speed: 200 loop: true")), "should run the handler")
+	assert.Equal(t, protocol.ErrUnsupportedParameter, r.Process(command(t, "play: bogus: 1")), "should reject unknown parameters")
+	assert.Equal(t, protocol.ErrInvalidValue, r.Process(command(t, "play: loop: maybe")), "should reject invalid values")
+	assert.Equal(t, protocol.ErrOutOfRange, r.Process(command(t, "play: speed: 5000")), "should reject out of range values")
+	assert.Equal(t, protocol.ErrUnsupported, r.Process(command(t, "record")), "should reject unknown commands")
+	assert.Equal(t, protocol.ErrUnsupported, r.Process(command(t, "ping")), "should not handle connection-level commands")
+}
+
+func TestRegistryRemote(t *testing.T) {
+	r := testRegistry()
+	remote := &RemoteFlags{Enabled: true}
+	r.RequireRemote(remote)
+
+	assert.Equal(t, "200 ok", r.Process(command(t, "play")), "should run the handler while remote is enabled")
+
+	remote.Enabled = false
+	assert.Equal(t, protocol.ErrRemoteControlDisabled, r.Process(command(t, "play")), "should refuse while remote is disabled")
+	assert.Equal(t, protocol.ErrUnsupportedParameter, r.Process(command(t, "play: bogus: 1")), "should still validate while remote is disabled")
+	assert.Contains(t, r.Process(command(t, "help")), "201 help:", "should still answer queries while remote is disabled")
+
+	remote.Override = true
+	assert.Equal(t, "200 ok", r.Process(command(t, "play")), "should run the handler while remote is overridden")
+}
+
+func TestRegistryCommands(t *testing.T) {
+	r := testRegistry()
+
+	assert.Equal(t, "212 commands:\r\n"+
+		"<commands>\r\n"+
+		"    <command name=\"help\"/>\r\n"+
+		"    <command name=\"commands\"/>\r\n"+
+		"    <command name=\"ping\"/>\r\n"+
+		"    <command name=\"watchdog\">\r\n"+
+		"        <parameter name=\"period\"/>\r\n"+
+		"    </command>\r\n"+
+		"    <command name=\"quit\"/>\r\n"+
+		"    <command name=\"play\">\r\n"+
+		"        <parameter name=\"speed\"/>\r\n"+
+		"        <parameter name=\"loop\"/>\r\n"+
+		"    </command>\r\n"+
+		"</commands>\r\n", r.Process(command(t, "commands")), "should list commands as XML")
+}
+
+func TestRegistryHelp(t *testing.T) {
+	r := testRegistry()
+
+	assert.Equal(t, "201 help:\r\n"+
+		"help                                            the command list\r\n"+
+		"commands                                        the command list in XML format\r\n"+
+		"ping                                            check device is responding\r\n"+
+		"watchdog: period: {0...2147483647}              client connection timeout in seconds\r\n"+
+		"quit                                            disconnect ethernet control\r\n"+
+		"play: speed: {-1600...1600} loop: {true|false}  play from current timecode\r\n", r.Process(command(t, "help")), "should list command usage")
+}
+
+func TestRegistryKeys(t *testing.T) {
+	r := testRegistry()
+	assert.Equal(t, []string{"speed", "loop"}, r.Keys("play"), "should list the parameters")
+	assert.Nil(t, r.Keys("bogus"), "should not know unknown commands")
+
+	cmd, err := protocol.ParseCommand("watchdog: period: 10", r.Keys)
+	require.NoError(t, err)
+	assert.Equal(t, "10", cmd.Parameters["period"], "should work as a grammar")
+}
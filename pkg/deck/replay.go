@@ -0,0 +1,194 @@
+package deck
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+)
+
+// ReplayOptions says how to replay a transcript, and how strictly to compare what comes back
+type ReplayOptions struct {
+	Timeout       time.Duration    // how long to wait for each response
+	Settle        time.Duration    // how long to wait for notifications after each response
+	Notifications bool             // compare notifications as well as responses
+	Ignore        []*regexp.Regexp // parts of messages that are expected to differ, like timecodes
+}
+
+// Mismatch is a difference between what a transcript says the deck said and what it says now
+type Mismatch struct {
+	Command  string // the command it followed; empty for before the first command
+	Kind     string // TranscriptResponse or TranscriptNotification
+	Expected string // empty if the deck said something it didn't before
+	Got      string // empty if the deck didn't say something it did before
+}
+
+func (m Mismatch) String() string {
+	after := "before any command"
+	if m.Command != "" {
+		after = fmt.Sprintf("after %q", m.Command)
+	}
+	return fmt.Sprintf("%v %v:\n  expected: %q\n  got:      %q", m.Kind, after, m.Expected, m.Got)
+}
+
+// replayStep is a command and everything the deck said after it, up to the next command
+type replayStep struct {
+	command       string
+	hasCommand    bool
+	response      *string
+	notifications []string
+}
+
+func splitSteps(entries []TranscriptEntry) []*replayStep {
+	step := &replayStep{}
+	steps := []*replayStep{step}
+	for _, entry := range entries {
+		switch entry.Kind {
+		case TranscriptCommand:
+			step = &replayStep{command: entry.Text, hasCommand: true}
+			steps = append(steps, step)
+		case TranscriptResponse:
+			text := entry.Text
+			step.response = &text
+		case TranscriptNotification:
+			step.notifications = append(step.notifications, entry.Text)
+		}
+	}
+	return steps
+}
+
+// mask makes a message comparable: line endings at the end don't matter, and neither do ignored parts or the order
+// of the lines after the first, since fields can come in any order
+func (o ReplayOptions) mask(msg string) string {
+	lines := strings.Split(strings.TrimRight(msg, "\r\n"), "\r\n")
+	sort.Strings(lines[1:])
+	msg = strings.Join(lines, "\r\n")
+	for _, re := range o.Ignore {
+		msg = re.ReplaceAllString(msg, "*")
+	}
+	return msg
+}
+
+// Replay sends the commands in a transcript to a deck over conn, and compares what comes back with what the
+// transcript says came back before. conn should be freshly connected, so the connection info is the first thing
+// read.
+func Replay(conn io.ReadWriter, entries []TranscriptEntry, opts ReplayOptions) ([]Mismatch, error) {
+	messages := make(chan string, 64)
+	go func() {
+		defer close(messages)
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := protocol.ReadMessage(r)
+			if err != nil {
+				return
+			}
+			messages <- msg
+		}
+	}()
+	send := func(command string) error {
+		_, err := io.WriteString(conn, command+"\r\n")
+		return err
+	}
+	return ReplayMessages(send, messages, entries, opts)
+}
+
+// ReplayMessages is Replay for when something else is handling the connection: send sends a command line, and
+// messages has every message read back, as protocol.ReadMessage returns them.
+func ReplayMessages(send func(command string) error, messages <-chan string, entries []TranscriptEntry, opts ReplayOptions) ([]Mismatch, error) {
+	mismatches := make([]Mismatch, 0)
+	for _, step := range splitSteps(entries) {
+		if step.hasCommand {
+			err := send(step.command)
+			if err != nil {
+				return mismatches, fmt.Errorf("error sending %q: %w", step.command, err)
+			}
+		}
+
+		var response *string
+		notifications := make([]string, 0)
+		unexpected := make([]string, 0)
+
+		// wait for the response, and as many notifications as there were before if they matter...
+		deadline := time.After(opts.Timeout)
+	waiting:
+		for (step.response != nil && response == nil) || (opts.Notifications && len(notifications) < len(step.notifications)) {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					break waiting
+				}
+				if protocol.IsAsync(msg) {
+					notifications = append(notifications, msg)
+				} else if response == nil {
+					response = &msg
+				} else {
+					unexpected = append(unexpected, msg)
+				}
+			case <-deadline:
+				break waiting
+			}
+		}
+		// ...then give anything else a chance to turn up
+		settled := time.After(opts.Settle)
+	settling:
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					break settling
+				}
+				if protocol.IsAsync(msg) {
+					notifications = append(notifications, msg)
+				} else {
+					unexpected = append(unexpected, msg)
+				}
+			case <-settled:
+				break settling
+			}
+		}
+
+		switch {
+		case step.response != nil && response == nil:
+			mismatches = append(mismatches, Mismatch{Command: step.command, Kind: TranscriptResponse, Expected: *step.response})
+		case step.response == nil && response != nil:
+			unexpected = append([]string{*response}, unexpected...)
+		case step.response != nil && opts.mask(*step.response) != opts.mask(*response):
+			mismatches = append(mismatches, Mismatch{Command: step.command, Kind: TranscriptResponse, Expected: *step.response, Got: *response})
+		}
+		for _, msg := range unexpected {
+			mismatches = append(mismatches, Mismatch{Command: step.command, Kind: TranscriptResponse, Got: msg})
+		}
+		if opts.Notifications {
+			mismatches = append(mismatches, opts.compareNotifications(step.command, step.notifications, notifications)...)
+		}
+	}
+	return mismatches, nil
+}
+
+// compareNotifications compares notifications regardless of order, since they're sent on timers
+func (o ReplayOptions) compareNotifications(command string, expected []string, got []string) []Mismatch {
+	mismatches := make([]Mismatch, 0)
+	remaining := append([]string{}, got...)
+	for _, want := range expected {
+		found := false
+		for i, msg := range remaining {
+			if o.mask(msg) == o.mask(want) {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			mismatches = append(mismatches, Mismatch{Command: command, Kind: TranscriptNotification, Expected: want})
+		}
+	}
+	for _, msg := range remaining {
+		mismatches = append(mismatches, Mismatch{Command: command, Kind: TranscriptNotification, Got: msg})
+	}
+	return mismatches
+}
@@ -0,0 +1,115 @@
+package deck
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestTranscriptRoundTrip(t *testing.T) {
+	buf := nopCloser{&bytes.Buffer{}}
+	tr := NewTranscript(buf)
+	require.NoError(t, tr.Record(TranscriptCommand, "ping"))
+	require.NoError(t, tr.Record(TranscriptResponse, "200 ok"))
+	require.NoError(t, tr.Close())
+
+	entries, err := ReadTranscript(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, TranscriptCommand, entries[0].Kind)
+	assert.Equal(t, "200 ok", entries[1].Text)
+
+	_, err = ReadTranscript(strings.NewReader(buf.String() + "{nope"))
+	assert.Error(t, err)
+}
+
+// fakeDeckConn answers like a deck on the other end of a pipe
+func fakeDeckConn(t *testing.T) net.Conn {
+	client, deck := net.Pipe()
+	go func() {
+		defer deck.Close()
+		deck.Write([]byte("500 connection info:\r\nprotocol version: 1.11\r\nmodel: Test\r\n\r\n"))
+		r := bufio.NewReader(deck)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.TrimRight(line, "\r\n") {
+			case "ping":
+				deck.Write([]byte("200 ok\r\n"))
+			case "transport info":
+				deck.Write([]byte("208 transport info:\r\nstatus: play\r\ntimecode: 00:00:01:00\r\n\r\n"))
+				deck.Write([]byte("508 transport info:\r\nstatus: play\r\n\r\n"))
+			case "quit":
+				return
+			default:
+				deck.Write([]byte("100 syntax error\r\n"))
+			}
+		}
+	}()
+	return client
+}
+
+func TestReplay(t *testing.T) {
+	transcript := `{"time":"2021-01-01T00:00:00Z","kind":"notification","text":"500 connection info:\r\nprotocol version: 1.11\r\nmodel: Test\r\n"}
+{"time":"2021-01-01T00:00:01Z","kind":"command","text":"ping"}
+{"time":"2021-01-01T00:00:01Z","kind":"response","text":"200 ok"}
+{"time":"2021-01-01T00:00:02Z","kind":"command","text":"transport info"}
+{"time":"2021-01-01T00:00:02Z","kind":"response","text":"208 transport info:\r\ntimecode: 00:00:05:00\r\nstatus: play\r\n"}
+{"time":"2021-01-01T00:00:02Z","kind":"notification","text":"508 transport info:\r\nstatus: play\r\n"}
+{"time":"2021-01-01T00:00:03Z","kind":"command","text":"stop"}
+{"time":"2021-01-01T00:00:03Z","kind":"response","text":"200 ok"}
+{"time":"2021-01-01T00:00:04Z","kind":"command","text":"quit"}
+`
+	entries, err := ReadTranscript(strings.NewReader(transcript))
+	require.NoError(t, err)
+
+	conn := fakeDeckConn(t)
+	defer conn.Close()
+	mismatches, err := Replay(conn, entries, ReplayOptions{
+		Timeout:       time.Second,
+		Settle:        10 * time.Millisecond,
+		Notifications: true,
+		Ignore:        []*regexp.Regexp{regexp.MustCompile(`timecode: .*`)},
+	})
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1, "should only find the stop mismatch, whatever order fields come in: %v", mismatches)
+	assert.Equal(t, Mismatch{Command: "stop", Kind: TranscriptResponse, Expected: "200 ok", Got: "100 syntax error"}, mismatches[0])
+
+	conn = fakeDeckConn(t)
+	defer conn.Close()
+	mismatches, err = Replay(conn, entries[:5], ReplayOptions{Timeout: time.Second})
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1, "should compare ignored parts when not told to ignore them")
+	assert.Contains(t, mismatches[0].String(), `after "transport info"`)
+}
+
+func TestReplayMissingNotification(t *testing.T) {
+	entries := []TranscriptEntry{
+		{Kind: TranscriptNotification, Text: "500 connection info:\r\nprotocol version: 1.11\r\nmodel: Test\r\n"},
+		{Kind: TranscriptCommand, Text: "ping"},
+		{Kind: TranscriptResponse, Text: "200 ok"},
+		{Kind: TranscriptNotification, Text: "510 remote info:\r\nenabled: true\r\n"},
+	}
+	conn := fakeDeckConn(t)
+	defer conn.Close()
+	mismatches, err := Replay(conn, entries, ReplayOptions{Timeout: 50 * time.Millisecond, Notifications: true})
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1)
+	assert.Equal(t, TranscriptNotification, mismatches[0].Kind)
+	assert.Equal(t, "", mismatches[0].Got)
+}
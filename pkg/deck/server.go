@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,18 +14,25 @@ import (
 
 	"github.com/rs/zerolog/log"
 
-	vlc "github.com/adrg/libvlc-go/v3"
 	"github.com/josh23french/fakedeck/pkg/protocol"
 )
 
 // Server represents a FakeDeck server, responding to clients and updating its state
 type Server struct {
 	deck     Deck
-	player   *vlc.Player
-	clientIP string // We can only ever serve a single client; this is where we keep track of who it is
+	grammar  protocol.Grammar // nil to parse every command without knowing its parameters
+	faults   *Faults          // nil to never misbehave on purpose
+	clock    Clock
+	stats    *Stats
+	watchers []func(msg string) // see everything that's notified, whether or not it's sent to the client
+	clientIP string             // We can only ever serve a single client; this is where we keep track of who it is
 	conn     net.Conn
+	addr     string       // where to listen, like ":9993"
+	listener net.Listener // set once Serve is listening
 	sync.RWMutex
-	quit chan interface{}
+	transcriptDir string      // where to record each session; empty to not record them
+	transcript    *Transcript // the current session's transcript, if it's being recorded
+	quit          chan interface{}
 }
 
 // NewServer constructs a new Server... duh
@@ -32,12 +41,82 @@ func NewServer(d Deck) *Server {
 		deck:     d,
 		clientIP: "",
 		conn:     nil,
+		addr:     ":9993",
+		clock:    RealClock{},
 		quit:     make(chan interface{}),
 	}
 }
 
-func (s *Server) SetPlayer(player *vlc.Player) {
-	s.player = player
+// SetAddr sets the address Serve listens on, instead of the protocol's usual ":9993"
+func (s *Server) SetAddr(addr string) {
+	s.addr = addr
+}
+
+// Addr returns the address the server is listening on, which is useful when it was told to pick a port; nil if it
+// isn't serving
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// SetGrammar tells the Server which parameters each command takes, so their values can have spaces in them
+func (s *Server) SetGrammar(grammar protocol.Grammar) {
+	s.grammar = grammar
+}
+
+// SetFaults makes the Server mangle responses and notifications the way faults say to
+func (s *Server) SetFaults(faults *Faults) {
+	s.faults = faults
+}
+
+// SetClock makes the Server time watchdogs and delayed notifications by clock. Responses are paced in real time
+// whatever the clock, so a fake one that's never advanced doesn't hold up the connection.
+func (s *Server) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+// SetStats makes the Server count connections and commands into stats
+func (s *Server) SetStats(stats *Stats) {
+	s.stats = stats
+}
+
+// SetTranscriptDir makes the Server record a transcript of each session into a file in dir
+func (s *Server) SetTranscriptDir(dir string) {
+	s.transcriptDir = dir
+}
+
+// openTranscript creates a transcript file for a new session with clientIP
+func (s *Server) openTranscript(clientIP string) *Transcript {
+	if s.transcriptDir == "" {
+		return nil
+	}
+	name := fmt.Sprintf("%v-%v.jsonl", s.clock.Now().Format("20060102-150405"), clientIP)
+	f, err := os.Create(filepath.Join(s.transcriptDir, name))
+	if err != nil {
+		log.Error().Err(err).Msg("could not create transcript")
+		return nil
+	}
+	log.Info().Msgf("recording transcript to %v", f.Name())
+	return NewTranscript(f)
+}
+
+// record adds to a session's transcript, if it has one
+func record(t *Transcript, kind string, text string) {
+	if t == nil {
+		return
+	}
+	err := t.Record(kind, text)
+	if err != nil {
+		log.Error().Err(err).Msg("error recording transcript")
+	}
+}
+
+// Watch calls fn with every asynchronous message, even the ones the client hasn't asked for. It's called on the
+// notifying goroutine, so it mustn't block.
+func (s *Server) Watch(fn func(msg string)) {
+	s.watchers = append(s.watchers, fn)
 }
 
 // Close stops the server
@@ -48,11 +127,12 @@ func (s *Server) Close() {
 
 // Serve starts a server
 func (s *Server) Serve() {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not start server")
+	}
+	s.listener = l
 	go func() {
-		l, err := net.Listen("tcp", ":9993")
-		if err != nil {
-			log.Fatal().Err(err).Msg("could not start server")
-		}
 		go func() {
 			<-s.quit
 			err := l.Close()
@@ -74,126 +154,218 @@ func (s *Server) Serve() {
 			// Handle the connection in a new goroutine.
 			// The loop then returns to accepting, so that
 			// multiple connections may be served concurrently.
-			go func(c net.Conn) {
-				clientIP := strings.SplitN(c.RemoteAddr().String(), ":", 2)[0]
-				if s.clientIP != "" && clientIP != s.clientIP {
-					log.Info().Msg("ClientIP isn't the one we are supposed to be talking to... closing.")
-					c.Write([]byte(protocol.ErrConnRejected + "\r\n"))
+			go s.serveConn(conn)
+		}
+	}()
+}
+
+// serveConn talks to a client until it goes away
+func (s *Server) serveConn(c net.Conn) {
+	clientIP := strings.SplitN(c.RemoteAddr().String(), ":", 2)[0]
+	if s.clientIP != "" && clientIP != s.clientIP {
+		log.Info().Msg("ClientIP isn't the one we are supposed to be talking to... closing.")
+		c.Write([]byte(protocol.ErrConnRejected + "\r\n"))
+		c.Close()
+		return
+	}
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	s.clientIP = clientIP
+	s.conn = c
+	transcript := s.openTranscript(clientIP)
+	s.Lock()
+	s.transcript = transcript // the previous session's closes its own as it ends
+	s.Unlock()
+	defer func() {
+		s.Lock()
+		defer s.Unlock()
+		if s.transcript == transcript {
+			s.transcript = nil
+		}
+		if transcript != nil {
+			transcript.Close()
+		}
+	}()
+	if s.stats != nil {
+		s.stats.Connected()
+	}
+
+	watchdogSet := false
+	var watchdog Timer
+	var watchdogDur time.Duration
+
+	reader := bufio.NewReader(c)
+	greeting := fmt.Sprintf("500 connection info:\r\nprotocol version: %v\r\nmodel: %v\r\n", s.deck.GetProtocol(), s.deck.GetModel())
+	record(transcript, TranscriptNotification, greeting)
+	c.Write([]byte(greeting + "\r\n"))
+
+	for {
+		res := "108 internal error"
+		req, err := protocol.ReadMessage(reader)
+		if err != nil {
+			if err == io.EOF {
+				log.Info().Msg("client hung up")
+				c.Close()
+				s.clientIP = "" // clear the client so another can connect
+				return
+			}
+			log.Error().Err(err).Msg("error reading from connection")
+			c.Close()
+			s.clientIP = "" // clear the client so another can connect
+			// s.Unlock()
+			return
+		}
+
+		if watchdogSet {
+			watchdog.Reset(watchdogDur)
+		}
+
+		log.Info().Msgf("got request: %q", req)
+		record(transcript, TranscriptCommand, req)
+		cmd, err := protocol.ParseCommand(req, s.grammar)
+
+		switch {
+		case err != nil:
+			log.Info().Err(err).Msg("couldn't parse request")
+			res = protocol.ErrSyntax
+		case cmd.Name == "ping":
+			// Protocol level doesn't need to be processed by the deck
+			res = "200 ok"
+			break
+		case cmd.Name == "watchdog":
+			periodStr, ok := cmd.Parameters["period"]
+			if !ok {
+				res = protocol.ErrSyntax
+				break
+			}
+			if res = protocol.ValidateParameters(WatchdogParameters, cmd.Parameters); res != "" {
+				break
+			}
+			period, _ := strconv.ParseInt(periodStr, 10, 0)
+			if watchdogSet {
+				// Stop any previous watchdog
+				watchdog.Stop()
+			}
+
+			watchdogDur = time.Duration(period) * time.Second
+			if period > 0 {
+				watchdog = s.clock.AfterFunc(watchdogDur, func() {
+					log.Info().Msgf("watchdog timeout for %v", c.RemoteAddr())
 					c.Close()
-					return
-				}
-				if s.conn != nil {
-					s.conn.Close()
-					s.conn = nil
-				}
-				s.clientIP = clientIP
-				s.conn = c
-
-				watchdogSet := false
-				var watchdog *time.Timer
-				var watchdogDur time.Duration
-
-				reader := bufio.NewReader(c)
-				c.Write([]byte(fmt.Sprintf("500 connection info:\r\nprotocol version: %v\r\nmodel: %v\r\n\r\n", s.deck.GetProtocol(), s.deck.GetModel())))
-
-				for {
-					res := "108 internal error"
-					req, err := reader.ReadString('\n')
-					if err != nil {
-						if err == io.EOF {
-							// s.Unlock()
-							continue
-						}
-						log.Error().Err(err).Msg("error reading from connection")
-						c.Close()
-						s.clientIP = "" // clear the client so another can connect
-						// s.Unlock()
-						return
-					}
-
-					if watchdogSet {
-						watchdog.Reset(watchdogDur)
-					}
-
-					req = strings.TrimRight(req, "\r\n")
-					cmd := protocol.CommandFromString(req)
-
-					log.Info().Msgf("got request: %v", req)
-					switch cmd.Name {
-					case "":
-						// empty command - ignore it
-						// s.Unlock()
-						continue
-					case "ping":
-						// Protocol level doesn't need to be processed by the deck
-						res = "200 ok"
-						break
-					case "watchdog":
-						periodStr, ok := cmd.Parameters["period"]
-						if !ok {
-							res = protocol.ErrSyntax
-							break
-						}
-						period, err := strconv.ParseInt(periodStr, 10, 0)
-						if err != nil {
-							res = protocol.ErrOutOfRange
-							break
-						}
-						if watchdogSet {
-							// Stop any previous watchdog
-							if !watchdog.Stop() {
-								<-watchdog.C
-							}
-						}
-
-						watchdogDur = time.Duration(period) * time.Second
-						if period > 0 {
-							watchdog = time.AfterFunc(watchdogDur, func() {
-								log.Info().Msgf("watchdog timeout for %v", c.RemoteAddr())
-								c.Close()
-								s.clientIP = "" // clear the client so another can connect
-							})
-							watchdogSet = true
-						} else {
-							watchdogSet = false
-						}
-						res = "200 ok"
-					case "quit": // Shut down this connection when we get the request to do so only.
-						log.Info().Msg("told to quit; closing connection")
-						c.Close()
-						s.clientIP = "" // clear the client so another can connect
-						return
-					default:
-						res = s.deck.ProcessCommand(cmd)
-					}
-
-					log.Info().Msgf("responding with: %v", res)
-
-					toWrite := []byte(res + "\r\n")
-					s.Lock()
-					written, err := c.Write(toWrite)
-					s.Unlock()
-					if err != nil {
-						log.Error().Err(err).Msg("error writing response")
-					}
-					if written != len(toWrite) {
-						log.Error().Err(err).Msg("full response not written")
-					}
-					log.Debug().Msgf("wrote %v bytes to %v", written, s.conn.RemoteAddr().String())
-					// give our async messages a little time to grab the lock if they need it... ?
-					time.Sleep(100 * time.Millisecond)
-				}
-			}(conn)
+					s.clientIP = "" // clear the client so another can connect
+				})
+				watchdogSet = true
+			} else {
+				watchdogSet = false
+			}
+			res = "200 ok"
+		case cmd.Name == "quit": // Shut down this connection when we get the request to do so only.
+			log.Info().Msg("told to quit; closing connection")
+			c.Close()
+			s.clientIP = "" // clear the client so another can connect
+			return
+		default:
+			res = s.process(cmd)
+		}
+
+		log.Info().Msgf("responding with: %v", res)
+		if s.stats != nil {
+			s.stats.Responded(res)
+		}
+
+		toWrite := []byte(res + "\r\n")
+		latency, fault, cut := s.faults.response(cmd, len(toWrite))
+		time.Sleep(latency)
+		switch fault {
+		case faultDrop:
+			log.Info().Msg("fault: dropping response")
+			continue // nothing's sent, so there's nothing to record
+		case faultTruncate:
+			log.Info().Msgf("fault: truncating response to %v bytes", cut)
+			toWrite = toWrite[:cut]
+		case faultDisconnect:
+			log.Info().Msgf("fault: disconnecting after %v bytes of response", cut)
+			s.writeResponse(c, transcript, toWrite[:cut])
+			c.Close()
+			s.clientIP = "" // clear the client so another can connect
+			return
+		}
+		written, err := s.writeResponse(c, transcript, toWrite)
+		if err != nil {
+			log.Error().Err(err).Msg("error writing response")
+		}
+		if written != len(toWrite) {
+			log.Error().Err(err).Msg("full response not written")
+		}
+		log.Debug().Msgf("wrote %v bytes to %v", written, c.RemoteAddr().String())
+		// give our async messages a little time to grab the lock if they need it... ?
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// writeResponse writes a response to c, recording as much of it as actually went out, which faults can cut short
+func (s *Server) writeResponse(c net.Conn, transcript *Transcript, b []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	written, err := c.Write(b)
+	if written > 0 {
+		record(transcript, TranscriptResponse, strings.TrimSuffix(string(b[:written]), "\r\n"))
+	}
+	return written, err
+}
+
+// process has the deck process a command, turning a panic into an internal error so one bad command can't take the
+// whole deck down
+func (s *Server) process(cmd *protocol.Command) (res string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Msgf("panic processing %q: %v", cmd.Name, r)
+			res = protocol.ErrInternal
 		}
 	}()
+	return s.deck.ProcessCommand(cmd)
+}
+
+// Notify passes an asynchronous message to the watchers, and sends it to the client if send is true (i.e. the
+// client has asked for that kind of notification)
+func (s *Server) Notify(msg string, send bool) {
+	for _, fn := range s.watchers {
+		fn(msg)
+	}
+	if send {
+		s.send(msg)
+	}
 }
 
+// AsyncSend notifies with a message the client always gets
 func (s *Server) AsyncSend(msg string) {
+	s.Notify(msg, true)
+}
+
+func (s *Server) send(msg string) {
+	delay, copies := s.faults.notification()
+	if delay > 0 {
+		s.clock.AfterFunc(delay, func() {
+			for i := 0; i < copies; i++ {
+				s.write(msg)
+			}
+		})
+		return
+	}
+	for i := 0; i < copies; i++ {
+		s.write(msg)
+	}
+}
+
+func (s *Server) write(msg string) {
 	if s.conn != nil {
 		log.Info().Msgf(`AsyncSending "%v"`, msg)
 		s.Lock()
 		defer s.Unlock()
 		log.Info().Msg(`AsyncSend got lock`)
+		record(s.transcript, TranscriptNotification, msg)
 		written, err := s.conn.Write([]byte(msg + "\r\n"))
 		if err != nil {
 			log.Error().Err(err).Msg("error writing async message")
@@ -0,0 +1,86 @@
+//go:build go1.18
+// +build go1.18
+
+package deck
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+)
+
+// fuzzDeck answers anything, but panics when asked to
+type fuzzDeck struct{}
+
+func (fuzzDeck) GetModel() string    { return "Fuzz" }
+func (fuzzDeck) GetProtocol() string { return "1.11" }
+func (fuzzDeck) PowerOn()            {}
+func (fuzzDeck) PowerOff()           {}
+
+func (fuzzDeck) ProcessCommand(cmd *protocol.Command) string {
+	if cmd.Name == "panic" {
+		panic("told to")
+	}
+	return protocol.ErrUnsupported
+}
+
+func FuzzServerSession(f *testing.F) {
+	for _, seed := range []string{
+		"ping",
+		"play:",
+		"a: b:",
+		"play:\r\nspeed: 50\r\n\r\nping",
+		"watchdog: period: nope",
+		"panic",
+		"\r\n   \r\n:",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		if strings.Count(input, "\n") > 10 {
+			t.Skip("every response takes a while, so keep sessions short")
+		}
+		client, conn := net.Pipe()
+		defer client.Close()
+
+		s := NewServer(fuzzDeck{})
+		done := make(chan interface{})
+		go func() {
+			s.serveConn(conn)
+			close(done)
+		}()
+		messages := make(chan string, 64)
+		go func() {
+			defer close(messages)
+			r := bufio.NewReader(client)
+			for {
+				msg, err := protocol.ReadMessage(r)
+				if err != nil {
+					return
+				}
+				messages <- msg
+			}
+		}()
+
+		// blank lines finish any multi-line command left open, then quit ends the session
+		client.Write([]byte(input + "\r\n\r\nquit\r\n"))
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("session didn't end")
+		}
+		client.Close()
+		for msg := range messages {
+			if len(msg) < 3 || msg[0] < '1' || msg[0] > '5' {
+				t.Fatalf("bad response %q", msg)
+			}
+			if msg == protocol.ErrInternal && !strings.Contains(input, "panic") {
+				t.Fatalf("internal error without a panic")
+			}
+		}
+	})
+}
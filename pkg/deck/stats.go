@@ -0,0 +1,105 @@
+package deck
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats are counters kept over the lifetime of a deck
+type Stats struct {
+	sync.Mutex
+	clock        Clock
+	poweredOn    time.Time     // zero until PowerOn
+	playTime     time.Duration // time spent playing, not counting the current stretch
+	playingSince time.Time     // zero unless playing
+	connections  uint64
+	commands     uint64
+	errors       uint64
+}
+
+// NewStats creates a new Stats... with everything at zero
+func NewStats() *Stats {
+	return &Stats{clock: RealClock{}}
+}
+
+// SetClock makes the Stats time things with clock
+func (s *Stats) SetClock(clock Clock) {
+	s.Lock()
+	defer s.Unlock()
+	s.clock = clock
+}
+
+// PowerOn starts the uptime clock
+func (s *Stats) PowerOn() {
+	s.Lock()
+	defer s.Unlock()
+	s.poweredOn = s.clock.Now()
+}
+
+// Uptime returns how long it's been since PowerOn
+func (s *Stats) Uptime() time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	if s.poweredOn.IsZero() {
+		return 0
+	}
+	return s.clock.Now().Sub(s.poweredOn)
+}
+
+// Playing records whether the deck is playing now, so play time can be totalled
+func (s *Stats) Playing(playing bool) {
+	s.Lock()
+	defer s.Unlock()
+	if playing && s.playingSince.IsZero() {
+		s.playingSince = s.clock.Now()
+	}
+	if !playing && !s.playingSince.IsZero() {
+		s.playTime += s.clock.Now().Sub(s.playingSince)
+		s.playingSince = time.Time{}
+	}
+}
+
+// PlayTime returns the total time spent playing, including right now
+func (s *Stats) PlayTime() time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	if s.playingSince.IsZero() {
+		return s.playTime
+	}
+	return s.playTime + s.clock.Now().Sub(s.playingSince)
+}
+
+// Connected counts a client connection
+func (s *Stats) Connected() {
+	s.Lock()
+	defer s.Unlock()
+	s.connections++
+}
+
+// Responded counts a command and, if the response was a failure (1xx), an error
+func (s *Stats) Responded(res string) {
+	s.Lock()
+	defer s.Unlock()
+	s.commands++
+	if strings.HasPrefix(res, "1") {
+		s.errors++
+	}
+}
+
+// Marshall turns the Stats into a slice of strings
+func (s *Stats) Marshall() []string {
+	uptime := s.Uptime()
+	playTime := s.PlayTime()
+
+	s.Lock()
+	defer s.Unlock()
+	lines := make([]string, 0)
+	lines = append(lines, fmt.Sprintf("uptime: %v", int64(uptime.Seconds())))
+	lines = append(lines, fmt.Sprintf("play time: %v", int64(playTime.Seconds())))
+	lines = append(lines, fmt.Sprintf("connections: %v", s.connections))
+	lines = append(lines, fmt.Sprintf("commands: %v", s.commands))
+	lines = append(lines, fmt.Sprintf("errors: %v", s.errors))
+	return lines
+}
@@ -0,0 +1,34 @@
+package deck
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/josh23french/fakedeck/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	stats := NewStats()
+	stats.SetClock(clock)
+	assert.Equal(t, time.Duration(0), stats.Uptime(), "should have no uptime before power on")
+
+	stats.PowerOn()
+	stats.Connected()
+	stats.Responded("200 ok")
+	stats.Responded(protocol.ErrOutOfRange)
+	stats.Responded("208 transport info:\r\nstatus: stopped\r\n")
+
+	stats.Playing(true)
+	clock.Advance(10 * time.Millisecond)
+	stats.Playing(false)
+	assert.Equal(t, 10*time.Millisecond, stats.PlayTime(), "should count time spent playing")
+	clock.Advance(10 * time.Millisecond)
+	assert.Equal(t, 10*time.Millisecond, stats.PlayTime(), "should not count time spent stopped")
+	assert.Equal(t, 20*time.Millisecond, stats.Uptime(), "should count time since power on")
+
+	joinedLines := strings.Join(stats.Marshall(), "\r\n") + "\r\n"
+	assert.Equal(t, "uptime: 0\r\nplay time: 0\r\nconnections: 1\r\ncommands: 3\r\nerrors: 1\r\n", joinedLines, "should marshall stats correctly")
+}
@@ -0,0 +1,73 @@
+package deck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Transcript entry kinds
+const (
+	TranscriptCommand      = "command"      // sent by the client
+	TranscriptResponse     = "response"     // the deck's response to the last command
+	TranscriptNotification = "notification" // sent by the deck on its own, including the connection info
+)
+
+// TranscriptEntry is one thing said during a session
+type TranscriptEntry struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	Text string    `json:"text"`
+}
+
+// Transcript records a session as JSON lines, one TranscriptEntry per line
+type Transcript struct {
+	sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+// NewTranscript creates a Transcript that writes to w
+func NewTranscript(w io.WriteCloser) *Transcript {
+	return &Transcript{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Record adds an entry to the transcript
+func (t *Transcript) Record(kind string, text string) error {
+	t.Lock()
+	defer t.Unlock()
+	return t.enc.Encode(TranscriptEntry{
+		Time: time.Now(),
+		Kind: kind,
+		Text: text,
+	})
+}
+
+// Close closes the transcript's writer
+func (t *Transcript) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	return t.w.Close()
+}
+
+// ReadTranscript reads back the entries of a transcript
+func ReadTranscript(r io.Reader) ([]TranscriptEntry, error) {
+	entries := make([]TranscriptEntry, 0)
+	dec := json.NewDecoder(r)
+	for {
+		var entry TranscriptEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, fmt.Errorf("error reading transcript entry %v: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+}
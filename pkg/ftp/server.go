@@ -0,0 +1,478 @@
+// Package ftp is a small FTP server for getting media on and off a deck's slots
+package ftp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Folders returns the top-level folders to serve, by name, and the directories they're in. It's asked every time
+// it's needed, since a slot's directory can change (e.g. when it's formatted).
+type Folders func() map[string]string
+
+// Server serves each folder as a top-level directory. Anyone can log in, and folders are flat: files can be
+// listed, downloaded, uploaded and deleted, but there are no subdirectories.
+type Server struct {
+	addr     string
+	folders  Folders
+	listener net.Listener
+	quit     chan interface{}
+}
+
+// NewServer constructs a new Server that will listen on addr, e.g. ":21"
+func NewServer(addr string, folders Folders) *Server {
+	return &Server{
+		addr:    addr,
+		folders: folders,
+		quit:    make(chan interface{}),
+	}
+}
+
+// Addr returns the address the Server is listening on; nil until it's serving
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Serve starts listening and serving clients in the background
+func (s *Server) Serve() error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("could not start ftp server: %w", err)
+	}
+	s.listener = l
+	go func() {
+		<-s.quit
+		err := l.Close()
+		if err != nil {
+			log.Error().Err(err).Msg("error closing ftp listener")
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if strings.Contains(err.Error(), "use of closed network connection") {
+					log.Info().Msg("ftp server closing...")
+					return
+				}
+				log.Warn().Err(err).Msg("error accepting ftp connection")
+				continue
+			}
+			go newSession(s, conn).serve()
+		}
+	}()
+	return nil
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	log.Info().Msg("closing the ftp server...")
+	close(s.quit)
+}
+
+// session is one client's control connection
+type session struct {
+	server  *Server
+	conn    net.Conn
+	reader  *bufio.Reader
+	cwd     string       // virtual working directory: "/" or "/{folder}"
+	passive net.Listener // listening for the data connection; nil unless PASV/EPSV came first
+	rename  string       // virtual path given to RNFR
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server: server,
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		cwd:    "/",
+	}
+}
+
+func (c *session) serve() {
+	defer c.conn.Close()
+	defer c.closePassive()
+	c.reply(220, "fakedeck ftp ready")
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				log.Warn().Err(err).Msg("error reading from ftp connection")
+			}
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg := line, ""
+		if idx := strings.Index(line, " "); idx >= 0 {
+			verb, arg = line[:idx], line[idx+1:]
+		}
+		verb = strings.ToUpper(verb)
+		log.Debug().Msgf("ftp command: %v %v", verb, arg)
+
+		switch verb {
+		case "USER":
+			c.reply(331, "any password will do")
+		case "PASS":
+			c.reply(230, "logged in")
+		case "SYST":
+			c.reply(215, "UNIX Type: L8")
+		case "FEAT":
+			fmt.Fprint(c.conn, "211-Features:\r\n EPSV\r\n PASV\r\n SIZE\r\n MDTM\r\n UTF8\r\n")
+			c.reply(211, "End")
+		case "OPTS":
+			c.reply(200, "ok")
+		case "NOOP":
+			c.reply(200, "ok")
+		case "TYPE", "MODE", "STRU":
+			c.reply(200, "ok") // everything is binary, streamed and unstructured anyway
+		case "PWD", "XPWD":
+			c.reply(257, strconv.Quote(c.cwd))
+		case "CWD", "XCWD":
+			c.changeDir(arg)
+		case "CDUP", "XCUP":
+			c.changeDir("..")
+		case "PASV":
+			c.enterPassive(false)
+		case "EPSV":
+			c.enterPassive(true)
+		case "LIST", "NLST":
+			c.list(arg, verb == "NLST")
+		case "RETR":
+			c.retrieve(arg)
+		case "STOR":
+			c.store(arg)
+		case "DELE":
+			c.delete(arg)
+		case "RNFR":
+			c.renameFrom(arg)
+		case "RNTO":
+			c.renameTo(arg)
+		case "SIZE":
+			c.size(arg)
+		case "MDTM":
+			c.modTime(arg)
+		case "MKD", "XMKD", "RMD", "XRMD":
+			c.reply(550, "folders can't be changed")
+		case "QUIT":
+			c.reply(221, "bye")
+			return
+		default:
+			c.reply(502, "command not implemented")
+		}
+	}
+}
+
+func (c *session) reply(code int, msg string) {
+	_, err := fmt.Fprintf(c.conn, "%v %v\r\n", code, msg)
+	if err != nil {
+		log.Warn().Err(err).Msg("error writing ftp reply")
+	}
+}
+
+// resolve turns a path relative to the working directory into the folder and file name it refers to; either can be
+// empty, for the root and for a folder respectively
+func (c *session) resolve(p string) (folder string, name string, err error) {
+	if !path.IsAbs(p) {
+		p = path.Join(c.cwd, p)
+	}
+	parts := strings.Split(strings.Trim(path.Clean(p), "/"), "/")
+	if parts[0] == "" {
+		return "", "", nil
+	}
+	if _, ok := c.server.folders()[parts[0]]; !ok {
+		return "", "", fmt.Errorf("no such folder: %v", parts[0])
+	}
+	switch len(parts) {
+	case 1:
+		return parts[0], "", nil
+	case 2:
+		if strings.HasPrefix(parts[1], ".") {
+			return "", "", fmt.Errorf("no such file: %v", parts[1])
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", fmt.Errorf("no such folder: %v", p)
+}
+
+// resolveFile is resolve for when the path has to be a file, returning where it really is
+func (c *session) resolveFile(p string) (string, error) {
+	folder, name, err := c.resolve(p)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", fmt.Errorf("not a file: %v", p)
+	}
+	return filepath.Join(c.server.folders()[folder], name), nil
+}
+
+func (c *session) changeDir(p string) {
+	folder, name, err := c.resolve(p)
+	if err != nil || name != "" {
+		c.reply(550, "no such folder")
+		return
+	}
+	c.cwd = "/" + folder
+	c.reply(250, "ok")
+}
+
+func (c *session) enterPassive(extended bool) {
+	c.closePassive()
+	host, _, err := net.SplitHostPort(c.conn.LocalAddr().String())
+	if err != nil {
+		c.reply(425, "can't open data connection")
+		return
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		log.Warn().Err(err).Msg("error listening for ftp data connection")
+		c.reply(425, "can't open data connection")
+		return
+	}
+	c.passive = l
+	port := l.Addr().(*net.TCPAddr).Port
+
+	if extended {
+		c.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%v|)", port))
+		return
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		c.closePassive()
+		c.reply(425, "use EPSV over IPv6")
+		return
+	}
+	c.reply(227, fmt.Sprintf("Entering Passive Mode (%v,%v,%v,%v,%v,%v)", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff))
+}
+
+func (c *session) closePassive() {
+	if c.passive != nil {
+		c.passive.Close()
+		c.passive = nil
+	}
+}
+
+// transfer accepts the data connection and hands it to fn, replying before and after
+func (c *session) transfer(fn func(data net.Conn) error) {
+	if c.passive == nil {
+		c.reply(425, "use PASV or EPSV first")
+		return
+	}
+	defer c.closePassive()
+
+	c.reply(150, "opening data connection")
+	data, err := c.passive.Accept()
+	if err != nil {
+		c.reply(425, "can't open data connection")
+		return
+	}
+	err = fn(data)
+	data.Close()
+	if err != nil {
+		log.Warn().Err(err).Msg("error transferring ftp data")
+		c.reply(426, "transfer aborted")
+		return
+	}
+	c.reply(226, "transfer complete")
+}
+
+func (c *session) list(arg string, namesOnly bool) {
+	// some clients send ls flags like -la; there's nothing for them to change
+	if strings.HasPrefix(arg, "-") {
+		arg = ""
+	}
+	folder, name, err := c.resolve(arg)
+	if err != nil || name != "" {
+		c.reply(550, "no such folder")
+		return
+	}
+
+	var entries []os.FileInfo
+	if folder == "" {
+		folders := c.server.folders()
+		for name, dir := range folders {
+			info, err := os.Stat(dir)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, folderInfo{info, name})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	} else {
+		files, err := ioutil.ReadDir(c.server.folders()[folder])
+		if err != nil {
+			c.reply(550, "can't read folder")
+			return
+		}
+		for _, file := range files {
+			if !file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+				entries = append(entries, file)
+			}
+		}
+	}
+
+	c.transfer(func(data net.Conn) error {
+		w := bufio.NewWriter(data)
+		for _, entry := range entries {
+			if namesOnly {
+				fmt.Fprintf(w, "%v\r\n", entry.Name())
+				continue
+			}
+			mode := "-rw-r--r--"
+			if entry.IsDir() {
+				mode = "drwxr-xr-x"
+			}
+			fmt.Fprintf(w, "%v 1 deck deck %12d %v %v\r\n", mode, entry.Size(), entry.ModTime().Format("Jan _2 15:04"), entry.Name())
+		}
+		return w.Flush()
+	})
+}
+
+// folderInfo is the FileInfo of a folder's directory, but with the folder's name
+type folderInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (f folderInfo) Name() string {
+	return f.name
+}
+
+func (c *session) retrieve(arg string) {
+	p, err := c.resolveFile(arg)
+	if err != nil {
+		c.reply(550, "no such file")
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		c.reply(550, "can't read file")
+		return
+	}
+	defer f.Close()
+	c.transfer(func(data net.Conn) error {
+		_, err := io.Copy(data, f)
+		return err
+	})
+}
+
+// store uploads to a hidden file and only renames it into place once it's complete, so the slot doesn't pick up
+// half a clip
+func (c *session) store(arg string) {
+	p, err := c.resolveFile(arg)
+	if err != nil {
+		c.reply(553, "can't store there")
+		return
+	}
+	partial := filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".part")
+	c.transfer(func(data net.Conn) error {
+		f, err := os.Create(partial)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, data)
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(partial, p)
+		}
+		if err != nil {
+			os.Remove(partial)
+		}
+		return err
+	})
+}
+
+func (c *session) delete(arg string) {
+	p, err := c.resolveFile(arg)
+	if err != nil {
+		c.reply(550, "no such file")
+		return
+	}
+	err = os.Remove(p)
+	if err != nil {
+		c.reply(550, "can't delete file")
+		return
+	}
+	c.reply(250, "deleted")
+}
+
+func (c *session) renameFrom(arg string) {
+	p, err := c.resolveFile(arg)
+	if err != nil {
+		c.reply(550, "no such file")
+		return
+	}
+	if _, err := os.Stat(p); err != nil {
+		c.reply(550, "no such file")
+		return
+	}
+	c.rename = p
+	c.reply(350, "ready for RNTO")
+}
+
+func (c *session) renameTo(arg string) {
+	from := c.rename
+	c.rename = ""
+	if from == "" {
+		c.reply(503, "use RNFR first")
+		return
+	}
+	p, err := c.resolveFile(arg)
+	if err != nil {
+		c.reply(553, "can't rename to there")
+		return
+	}
+	err = os.Rename(from, p)
+	if err != nil {
+		c.reply(553, "can't rename file")
+		return
+	}
+	c.reply(250, "renamed")
+}
+
+func (c *session) size(arg string) {
+	info, ok := c.stat(arg)
+	if ok {
+		c.reply(213, strconv.FormatInt(info.Size(), 10))
+	}
+}
+
+func (c *session) modTime(arg string) {
+	info, ok := c.stat(arg)
+	if ok {
+		c.reply(213, info.ModTime().UTC().Format("20060102150405"))
+	}
+}
+
+// stat is for SIZE and MDTM; it replies with the error if there is one
+func (c *session) stat(arg string) (os.FileInfo, bool) {
+	p, err := c.resolveFile(arg)
+	if err != nil {
+		c.reply(550, "no such file")
+		return nil, false
+	}
+	info, err := os.Stat(p)
+	if err != nil || info.IsDir() {
+		c.reply(550, "no such file")
+		return nil, false
+	}
+	return info, true
+}
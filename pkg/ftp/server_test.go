@@ -0,0 +1,102 @@
+package ftp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dial logs in to s
+func dial(t *testing.T, s *Server) *textproto.Conn {
+	conn, err := textproto.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, _, err = conn.ReadResponse(220)
+	require.NoError(t, err)
+	cmd(t, conn, 331, "USER anonymous")
+	cmd(t, conn, 230, "PASS anonymous")
+	return conn
+}
+
+// cmd sends a command and checks the reply code
+func cmd(t *testing.T, conn *textproto.Conn, code int, format string, args ...interface{}) string {
+	_, err := conn.Cmd(format, args...)
+	require.NoError(t, err)
+	_, msg, err := conn.ReadResponse(code)
+	require.NoError(t, err, format)
+	return msg
+}
+
+// data opens a passive data connection and runs a command over it
+func data(t *testing.T, conn *textproto.Conn, fn func(net.Conn), format string, args ...interface{}) {
+	msg := cmd(t, conn, 229, "EPSV")
+	var port int
+	_, err := fmt.Sscanf(msg, "Entering Extended Passive Mode (|||%d|)", &port)
+	require.NoError(t, err)
+	d, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%v", port))
+	require.NoError(t, err)
+	cmd(t, conn, 150, format, args...)
+	fn(d)
+	d.Close()
+	_, _, err = conn.ReadResponse(226)
+	require.NoError(t, err)
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "existing.mov"), []byte("old"), 0644))
+
+	s := NewServer("127.0.0.1:0", func() map[string]string {
+		return map[string]string{"1": dir}
+	})
+	require.NoError(t, s.Serve())
+	defer s.Close()
+
+	conn := dial(t, s)
+	defer conn.Close()
+
+	data(t, conn, func(d net.Conn) {
+		listing, _ := ioutil.ReadAll(d)
+		assert.Equal(t, "1\r\n", string(listing), "should list folders at the top level")
+	}, "NLST")
+
+	cmd(t, conn, 250, "CWD 1")
+	assert.Equal(t, `"/1"`, cmd(t, conn, 257, "PWD"), "should be in the folder")
+	cmd(t, conn, 550, "CWD nope")
+
+	data(t, conn, func(d net.Conn) {
+		d.Write([]byte("new clip"))
+	}, "STOR new.mov")
+	uploaded, err := ioutil.ReadFile(filepath.Join(dir, "new.mov"))
+	require.NoError(t, err)
+	assert.Equal(t, "new clip", string(uploaded), "should upload into the folder's directory")
+	_, err = os.Stat(filepath.Join(dir, ".new.mov.part"))
+	assert.True(t, os.IsNotExist(err), "should not leave the partial upload behind")
+
+	data(t, conn, func(d net.Conn) {
+		listing, _ := ioutil.ReadAll(d)
+		assert.Equal(t, "existing.mov\r\nnew.mov\r\n", string(listing), "should list files in the folder")
+	}, "NLST")
+
+	data(t, conn, func(d net.Conn) {
+		contents, _ := ioutil.ReadAll(d)
+		assert.Equal(t, "old", string(contents), "should download from the folder")
+	}, "RETR /1/existing.mov")
+
+	assert.Equal(t, "3", cmd(t, conn, 213, "SIZE existing.mov"), "should report file size")
+	cmd(t, conn, 250, "DELE existing.mov")
+	_, err = os.Stat(filepath.Join(dir, "existing.mov"))
+	assert.True(t, os.IsNotExist(err), "should delete from the folder's directory")
+
+	cmd(t, conn, 550, "RETR ../../etc/passwd")
+	cmd(t, conn, 550, "MKD sub")
+	cmd(t, conn, 221, "QUIT")
+}
@@ -0,0 +1,225 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// DNS record types and classes we deal in
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN         = 1
+	classCacheFlush = 0x8000 // top bit of the class in a response: this record replaces any others with its name
+	classUnicast    = 0x8000 // top bit of the class in a question: answer by unicast
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+// name is a domain name as its labels, since instance names can have dots in them
+type name []string
+
+func parseName(s string) name {
+	return strings.Split(strings.TrimSuffix(s, "."), ".")
+}
+
+// Equal compares names the DNS way: ignoring case
+func (n name) Equal(other name) bool {
+	if len(n) != len(other) {
+		return false
+	}
+	for i := range n {
+		if !strings.EqualFold(n[i], other[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n name) String() string {
+	return strings.Join(n, ".") + "."
+}
+
+func (n name) encode() []byte {
+	b := make([]byte, 0)
+	for _, label := range n {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// question is a question from a query
+type question struct {
+	name    name
+	qtype   uint16
+	unicast bool // the asker wants a unicast response
+}
+
+// record is a resource record to go in a response
+type record struct {
+	name       name
+	rtype      uint16
+	cacheFlush bool
+	ttl        uint32
+	data       []byte // encoded already
+}
+
+func ptrRecord(n name, ttl uint32, target name) record {
+	return record{name: n, rtype: typePTR, ttl: ttl, data: target.encode()}
+}
+
+func srvRecord(n name, ttl uint32, port int, target name) record {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[4:], uint16(port)) // priority and weight are 0
+	return record{name: n, rtype: typeSRV, cacheFlush: true, ttl: ttl, data: append(data, target.encode()...)}
+}
+
+func txtRecord(n name, ttl uint32, text []string) record {
+	data := make([]byte, 0)
+	for _, s := range text {
+		if len(s) > 255 {
+			s = s[:255]
+		}
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	if len(data) == 0 {
+		data = []byte{0} // a TXT record can't be empty
+	}
+	return record{name: n, rtype: typeTXT, cacheFlush: true, ttl: ttl, data: data}
+}
+
+func aRecord(n name, ttl uint32, ip []byte) record {
+	return record{name: n, rtype: typeA, cacheFlush: true, ttl: ttl, data: ip}
+}
+
+func (r record) encode() []byte {
+	b := r.name.encode()
+	class := uint16(classIN)
+	if r.cacheFlush {
+		class |= classCacheFlush
+	}
+	fixed := make([]byte, 10)
+	binary.BigEndian.PutUint16(fixed[0:], r.rtype)
+	binary.BigEndian.PutUint16(fixed[2:], class)
+	binary.BigEndian.PutUint32(fixed[4:], r.ttl)
+	binary.BigEndian.PutUint16(fixed[8:], uint16(len(r.data)))
+	b = append(b, fixed...)
+	return append(b, r.data...)
+}
+
+// message is a DNS message with just the parts mDNS needs
+type message struct {
+	id          uint16
+	response    bool
+	questions   []question
+	answers     []record
+	additionals []record
+}
+
+func (m *message) encode() []byte {
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint16(header[0:], m.id)
+	if m.response {
+		binary.BigEndian.PutUint16(header[2:], 0x8400) // response, authoritative
+	}
+	binary.BigEndian.PutUint16(header[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(header[6:], uint16(len(m.answers)))
+	binary.BigEndian.PutUint16(header[10:], uint16(len(m.additionals)))
+
+	b := header
+	for _, q := range m.questions {
+		b = append(b, q.name.encode()...)
+		fixed := make([]byte, 4)
+		binary.BigEndian.PutUint16(fixed[0:], q.qtype)
+		class := uint16(classIN)
+		if q.unicast {
+			class |= classUnicast
+		}
+		binary.BigEndian.PutUint16(fixed[2:], class)
+		b = append(b, fixed...)
+	}
+	for _, r := range m.answers {
+		b = append(b, r.encode()...)
+	}
+	for _, r := range m.additionals {
+		b = append(b, r.encode()...)
+	}
+	return b
+}
+
+// parseMessage parses the header and questions of a message; that's all a responder needs to look at
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < 12 {
+		return nil, errMalformed
+	}
+	m := &message{
+		id:       binary.BigEndian.Uint16(b[0:]),
+		response: b[2]&0x80 != 0,
+	}
+	count := int(binary.BigEndian.Uint16(b[4:]))
+	offset := 12
+	for i := 0; i < count; i++ {
+		n, next, err := readName(b, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(b) {
+			return nil, errMalformed
+		}
+		class := binary.BigEndian.Uint16(b[next+2:])
+		m.questions = append(m.questions, question{
+			name:    n,
+			qtype:   binary.BigEndian.Uint16(b[next:]),
+			unicast: class&classUnicast != 0,
+		})
+		offset = next + 4
+	}
+	return m, nil
+}
+
+// readName reads a possibly-compressed name at offset, returning it and the offset just after it
+func readName(b []byte, offset int) (name, int, error) {
+	n := make(name, 0)
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(b) {
+			return nil, 0, errMalformed
+		}
+		length := int(b[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return n, next, nil
+		case length&0xc0 == 0xc0:
+			if offset+2 > len(b) {
+				return nil, 0, errMalformed
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			jumps++
+			if jumps > 16 {
+				return nil, 0, errMalformed // pointer loop
+			}
+			offset = int(binary.BigEndian.Uint16(b[offset:]) & 0x3fff)
+		default:
+			if offset+1+length > len(b) {
+				return nil, 0, errMalformed
+			}
+			n = append(n, string(b[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
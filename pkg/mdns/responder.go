@@ -0,0 +1,262 @@
+// Package mdns is a multicast DNS responder that advertises DNS-SD services, so the deck can be found on the LAN
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TTLs as recommended by RFC 6762: records about the host are short-lived, others can be kept longer
+const (
+	hostTTL  = 120
+	otherTTL = 4500
+)
+
+var (
+	multicastAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	servicesName  = parseName("_services._dns-sd._udp.local")
+)
+
+// Service is a DNS-SD service instance to advertise
+type Service struct {
+	Instance string   // e.g. "fakedeck"; can have spaces and dots in it
+	Type     string   // e.g. "_hyperdeck_ctrl._tcp"
+	Port     int      // port the service is on
+	Text     []string // TXT record strings, like "key=value"
+}
+
+func (s Service) typeName() name {
+	return parseName(s.Type + ".local")
+}
+
+func (s Service) instanceName() name {
+	return append(name{s.Instance}, s.typeName()...)
+}
+
+// Responder answers mDNS queries for its services and its host name
+type Responder struct {
+	host     name
+	ips      []net.IP
+	services []Service
+	conn     *net.UDPConn
+	quit     chan interface{}
+	done     chan interface{}
+}
+
+// NewResponder creates a Responder for services on host (a name under .local, without the .local) reachable at ips
+func NewResponder(host string, ips []net.IP, services ...Service) *Responder {
+	return &Responder{
+		host:     parseName(host + ".local"),
+		ips:      ips,
+		services: services,
+		quit:     make(chan interface{}),
+		done:     make(chan interface{}),
+	}
+}
+
+// HostName turns a deck name into something usable as a host name
+func HostName(deckName string) string {
+	host := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, deckName)
+	for strings.Contains(host, "--") {
+		host = strings.Replace(host, "--", "-", -1)
+	}
+	host = strings.Trim(host, "-")
+	if host == "" {
+		return "fakedeck"
+	}
+	return host
+}
+
+// UniqueNames returns a host name and service instance name for a deck that won't clash with other decks advertising
+// the same name, as this doesn't probe for conflicts. Both get the end of uniqueID added, the way real decks use the
+// end of their MAC address.
+func UniqueNames(deckName, uniqueID string) (host, instance string) {
+	suffix := HostName(uniqueID)
+	if len(suffix) > 6 {
+		suffix = suffix[len(suffix)-6:]
+	}
+	return HostName(deckName + "-" + suffix), fmt.Sprintf("%v (%v)", deckName, suffix)
+}
+
+// LocalIPs returns the machine's non-loopback IPv4 addresses
+func LocalIPs() []net.IP {
+	ips := make([]net.IP, 0)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP.To4())
+		}
+	}
+	return ips
+}
+
+// Start joins the mDNS group, announces the services and starts answering queries
+func (r *Responder) Start() error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, multicastAddr)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	go r.serve()
+	go r.announce()
+	return nil
+}
+
+// Close withdraws the services (with a goodbye: the records again, with a TTL of 0) and stops answering
+func (r *Responder) Close() {
+	close(r.quit)
+	if r.conn == nil {
+		return
+	}
+	goodbye := r.announcement()
+	for i := range goodbye.answers {
+		goodbye.answers[i].ttl = 0
+	}
+	r.send(goodbye, multicastAddr)
+	r.conn.Close()
+	<-r.done
+}
+
+// announce sends the announcement twice, a second apart, as RFC 6762 says to
+func (r *Responder) announce() {
+	for i := 0; i < 2; i++ {
+		r.send(r.announcement(), multicastAddr)
+		select {
+		case <-r.quit:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// announcement is every record we're responsible for
+func (r *Responder) announcement() *message {
+	m := &message{response: true}
+	for _, s := range r.services {
+		m.answers = append(m.answers, ptrRecord(s.typeName(), otherTTL, s.instanceName()))
+		m.answers = append(m.answers, r.instanceRecords(s)...)
+	}
+	m.answers = append(m.answers, r.hostRecords()...)
+	return m
+}
+
+func (r *Responder) instanceRecords(s Service) []record {
+	return []record{
+		srvRecord(s.instanceName(), hostTTL, s.Port, r.host),
+		txtRecord(s.instanceName(), otherTTL, s.Text),
+	}
+}
+
+func (r *Responder) hostRecords() []record {
+	records := make([]record, 0)
+	for _, ip := range r.ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			records = append(records, aRecord(r.host, hostTTL, ip4))
+		}
+	}
+	return records
+}
+
+func (r *Responder) serve() {
+	defer close(r.done)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-r.quit:
+				return
+			default:
+			}
+			log.Warn().Err(err).Msg("error reading mdns query")
+			continue
+		}
+		query, err := parseMessage(buf[:n])
+		if err != nil || query.response {
+			continue
+		}
+
+		res := r.respond(query)
+		if len(res.answers) == 0 {
+			continue
+		}
+		legacy := from.Port != multicastAddr.Port
+		unicast := legacy
+		for _, q := range query.questions {
+			unicast = unicast || q.unicast
+		}
+		if legacy {
+			// a plain DNS resolver asking; it needs the ID and questions back, and doesn't know about cache flush
+			res.id = query.id
+			res.questions = query.questions
+			for i := range res.answers {
+				res.answers[i].cacheFlush = false
+			}
+			for i := range res.additionals {
+				res.additionals[i].cacheFlush = false
+			}
+		}
+		if unicast {
+			r.send(res, from)
+		} else {
+			r.send(res, multicastAddr)
+		}
+	}
+}
+
+// respond works out the answers to a query, plus the additional records that'll save the asker another query
+func (r *Responder) respond(query *message) *message {
+	res := &message{response: true}
+	matches := func(q question, n name, rtype uint16) bool {
+		return q.name.Equal(n) && (q.qtype == rtype || q.qtype == typeANY)
+	}
+
+	for _, q := range query.questions {
+		if matches(q, servicesName, typePTR) {
+			for _, s := range r.services {
+				res.answers = append(res.answers, ptrRecord(servicesName, otherTTL, s.typeName()))
+			}
+		}
+		for _, s := range r.services {
+			if matches(q, s.typeName(), typePTR) {
+				res.answers = append(res.answers, ptrRecord(s.typeName(), otherTTL, s.instanceName()))
+				res.additionals = append(res.additionals, r.instanceRecords(s)...)
+				res.additionals = append(res.additionals, r.hostRecords()...)
+			}
+			instance := r.instanceRecords(s)
+			if matches(q, s.instanceName(), typeSRV) {
+				res.answers = append(res.answers, instance[0])
+				res.additionals = append(res.additionals, r.hostRecords()...)
+			}
+			if matches(q, s.instanceName(), typeTXT) {
+				res.answers = append(res.answers, instance[1])
+			}
+		}
+		if matches(q, r.host, typeA) {
+			res.answers = append(res.answers, r.hostRecords()...)
+		}
+	}
+	return res
+}
+
+func (r *Responder) send(m *message, to *net.UDPAddr) {
+	_, err := r.conn.WriteToUDP(m.encode(), to)
+	if err != nil {
+		log.Warn().Err(err).Msgf("error sending mdns response to %v", to)
+	}
+}
@@ -0,0 +1,88 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testResponder() *Responder {
+	return NewResponder("fakedeck", []net.IP{net.IPv4(192, 168, 1, 20)}, Service{
+		Instance: "Fake Deck 1.0",
+		Type:     "_hyperdeck_ctrl._tcp",
+		Port:     9993,
+		Text:     []string{"name=Fake Deck 1.0", "unique id=0123456789ab"},
+	})
+}
+
+// query encodes and parses a query, like it came off the network
+func query(t *testing.T, n string, qtype uint16) *message {
+	q := &message{id: 42, questions: []question{{name: parseName(n), qtype: qtype}}}
+	parsed, err := parseMessage(q.encode())
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestParseMessage(t *testing.T) {
+	q := query(t, "_hyperdeck_ctrl._tcp.local", typePTR)
+	assert.Equal(t, uint16(42), q.id)
+	assert.False(t, q.response)
+	assert.Equal(t, "_hyperdeck_ctrl._tcp.local.", q.questions[0].name.String(), "should parse the name")
+
+	// the same question, but with the name compressed into a pointer to "_tcp.local" in an earlier name
+	b := []byte{0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0}
+	b = append(b, parseName("_blackmagic._tcp.local").encode()...)
+	b = append(b, 0, typePTR, 0, classIN)
+	b = append(b, 15)
+	b = append(b, "_hyperdeck_ctrl"...)
+	b = append(b, 0xc0, 12+12) // _tcp starts after the 12 byte header and "_blackmagic" (1+11 bytes)
+	b = append(b, 0, typePTR, 0x80, classIN)
+	m, err := parseMessage(b)
+	require.NoError(t, err)
+	assert.True(t, m.questions[1].name.Equal(parseName("_HYPERDECK_CTRL._tcp.local")), "should follow compression pointers")
+	assert.True(t, m.questions[1].unicast, "should see the unicast bit")
+
+	_, err = parseMessage([]byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 12})
+	assert.Error(t, err, "should not loop forever on pointer loops")
+}
+
+func TestRespond(t *testing.T) {
+	r := testResponder()
+
+	res := r.respond(query(t, "_hyperdeck_ctrl._tcp.local", typePTR))
+	require.Len(t, res.answers, 1)
+	assert.Equal(t, uint16(typePTR), res.answers[0].rtype)
+	assert.Equal(t, append([]byte{13}, append([]byte("Fake Deck 1.0"), parseName("_hyperdeck_ctrl._tcp.local").encode()...)...), res.answers[0].data, "should point at the instance, dots and all")
+	require.Len(t, res.additionals, 3, "should include the SRV, TXT and A records")
+	assert.Equal(t, uint16(typeSRV), res.additionals[0].rtype)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0x27, 0x09}, res.additionals[0].data[:6], "should have the port in the SRV")
+	assert.Equal(t, uint16(typeTXT), res.additionals[1].rtype)
+	assert.Equal(t, []byte{192, 168, 1, 20}, res.additionals[2].data)
+
+	res = r.respond(query(t, "_services._dns-sd._udp.local", typePTR))
+	require.Len(t, res.answers, 1, "should list service types")
+
+	res = r.respond(query(t, "FAKEDECK.local", typeANY))
+	require.Len(t, res.answers, 1, "should answer for the host name")
+	assert.Equal(t, uint16(typeA), res.answers[0].rtype)
+
+	res = r.respond(query(t, "_airplay._tcp.local", typePTR))
+	assert.Len(t, res.answers, 0, "should ignore other services")
+}
+
+func TestHostName(t *testing.T) {
+	assert.Equal(t, "fake-deck-1", HostName("Fake Deck #1"))
+	assert.Equal(t, "fakedeck", HostName("???"))
+}
+
+func TestUniqueNames(t *testing.T) {
+	host, instance := UniqueNames("Fake Deck", "7c2e0d0a1b2c")
+	assert.Equal(t, "fake-deck-0a1b2c", host)
+	assert.Equal(t, "Fake Deck (0a1b2c)", instance)
+
+	other, otherInstance := UniqueNames("Fake Deck", "7c2e0d0a1b2d")
+	assert.NotEqual(t, host, other, "decks with the same name should get different host names")
+	assert.NotEqual(t, instance, otherInstance, "decks with the same name should get different instance names")
+}
@@ -0,0 +1,185 @@
+// Package mpv controls an mpv player over its JSON IPC socket (--input-ipc-server): commands, properties and events
+package mpv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Timeout is how long to wait for mpv to answer a command
+var Timeout = 5 * time.Second
+
+// ErrClosed is returned for commands that can't be answered because the connection to mpv has gone
+var ErrClosed = errors.New("mpv connection closed")
+
+// ErrTimeout is returned for commands mpv doesn't answer in time
+var ErrTimeout = errors.New("timed out waiting for mpv")
+
+// Event is something mpv tells us about without being asked, like a file finishing loading or an observed property
+// changing
+type Event struct {
+	Event  string          `json:"event"`
+	ID     int             `json:"id"`     // observer ID, for property-change
+	Name   string          `json:"name"`   // property name, for property-change
+	Data   json.RawMessage `json:"data"`   // property value, for property-change
+	Reason string          `json:"reason"` // why, for end-file
+}
+
+// message is anything mpv sends: a response to a command, with its result in Data, or an event
+type message struct {
+	Event
+	RequestID int    `json:"request_id"`
+	Error     string `json:"error"`
+}
+
+// request is a command sent to mpv
+type request struct {
+	Command   []interface{} `json:"command"`
+	RequestID int           `json:"request_id"`
+}
+
+// Client talks to mpv over its IPC socket
+type Client struct {
+	sync.Mutex // guards writing to conn, and pending
+	conn       net.Conn
+	nextID     int
+	pending    map[int]chan message
+	events     chan Event
+	closed     bool
+}
+
+// Dial connects to mpv's IPC socket
+func Dial(socket string) (*Client, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient talks to mpv over conn
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		pending: make(map[int]chan message),
+		events:  make(chan Event, 64),
+	}
+	go c.read()
+	return c
+}
+
+// read hands responses to whoever's waiting for them, and events to Events, until the connection goes
+func (c *Client) read() {
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var msg message
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			log.Warn().Err(err).Msgf("couldn't parse message from mpv: %q", scanner.Text())
+			continue
+		}
+		if msg.Event.Event != "" {
+			select {
+			case c.events <- msg.Event:
+			default:
+				log.Warn().Msgf("dropping mpv %v event; nothing's keeping up with them", msg.Event.Event)
+			}
+			continue
+		}
+		c.Lock()
+		ch, ok := c.pending[msg.RequestID]
+		delete(c.pending, msg.RequestID)
+		c.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	close(c.events)
+}
+
+// Command runs a command, like Command("seek", 10, "absolute"), returning its result
+func (c *Client) Command(args ...interface{}) (json.RawMessage, error) {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return nil, ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan message, 1)
+	c.pending[id] = ch
+	line, err := json.Marshal(request{Command: args, RequestID: id})
+	if err == nil {
+		_, err = c.conn.Write(append(line, '\n'))
+	}
+	if err != nil {
+		delete(c.pending, id)
+		c.Unlock()
+		return nil, fmt.Errorf("error sending mpv command: %w", err)
+	}
+	c.Unlock()
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return nil, ErrClosed
+		}
+		if msg.Error != "success" {
+			return nil, fmt.Errorf("mpv %v: %v", args[0], msg.Error)
+		}
+		return msg.Data, nil
+	case <-time.After(Timeout):
+		c.Lock()
+		delete(c.pending, id)
+		c.Unlock()
+		return nil, ErrTimeout
+	}
+}
+
+// GetProperty reads a property into value, which should be a pointer to something it unmarshals into
+func (c *Client) GetProperty(name string, value interface{}) error {
+	data, err := c.Command("get_property", name)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// SetProperty sets a property
+func (c *Client) SetProperty(name string, value interface{}) error {
+	_, err := c.Command("set_property", name, value)
+	return err
+}
+
+// ObserveProperty sends a property-change event with id each time the property changes
+func (c *Client) ObserveProperty(id int, name string) error {
+	_, err := c.Command("observe_property", id, name)
+	return err
+}
+
+// Events returns the events mpv sends, which is closed when the connection goes. Events are dropped if they aren't
+// received quickly enough.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Close closes the connection to mpv, leaving mpv running
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
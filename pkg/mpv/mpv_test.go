@@ -0,0 +1,162 @@
+package mpv
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMPV answers commands on the other end of a pipe like mpv would, with reply deciding the answer to each, which
+// can take as long as it likes without holding up the others
+type fakeMPV struct {
+	conn     net.Conn
+	commands chan []interface{}
+}
+
+func newFakeMPV(t *testing.T, reply func(command []interface{}) (interface{}, string)) (*Client, *fakeMPV) {
+	client, server := net.Pipe()
+	f := &fakeMPV{
+		conn:     server,
+		commands: make(chan []interface{}, 16),
+	}
+	go func() {
+		scanner := bufio.NewScanner(server)
+		for scanner.Scan() {
+			var req request
+			if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+				t.Errorf("bad request %q: %v", scanner.Text(), err)
+				return
+			}
+			f.commands <- req.Command
+			go func(req request) {
+				data, errString := reply(req.Command)
+				f.send(map[string]interface{}{"request_id": req.RequestID, "error": errString, "data": data})
+			}(req)
+		}
+	}()
+	c := NewClient(client)
+	t.Cleanup(func() {
+		c.Close()
+		server.Close()
+	})
+	return c, f
+}
+
+func (f *fakeMPV) send(msg interface{}) {
+	line, _ := json.Marshal(msg)
+	f.conn.Write(append(line, '\n'))
+}
+
+func TestCommand(t *testing.T) {
+	c, f := newFakeMPV(t, func(command []interface{}) (interface{}, string) {
+		if command[0] == "get_property" && command[1] == "time-pos" {
+			return 12.5, "success"
+		}
+		if command[0] == "seek" {
+			return nil, "success"
+		}
+		return nil, "property not found"
+	})
+
+	_, err := c.Command("seek", 10, "absolute+exact")
+	require.NoError(t, err, "seek should succeed")
+	assert.Equal(t, []interface{}{"seek", float64(10), "absolute+exact"}, <-f.commands, "should send the command")
+
+	var pos float64
+	require.NoError(t, c.GetProperty("time-pos", &pos), "getting time-pos should succeed")
+	assert.Equal(t, 12.5, pos, "should unmarshal the property")
+	<-f.commands
+
+	err = c.SetProperty("nonsense", true)
+	assert.EqualError(t, err, "mpv set_property: property not found", "should return mpv's error")
+}
+
+func TestCommandsInFlight(t *testing.T) {
+	// answers arrive out of order, and should still find their way back
+	release := make(chan interface{})
+	c, _ := newFakeMPV(t, func(command []interface{}) (interface{}, string) {
+		if command[1] == "slow" {
+			<-release
+		}
+		return command[1], "success"
+	})
+
+	results := make(chan string, 2)
+	for _, name := range []string{"slow", "fast"} {
+		go func(name string) {
+			var value string
+			err := c.GetProperty(name, &value)
+			if err != nil {
+				value = err.Error()
+			}
+			results <- value
+		}(name)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "fast", <-results, "the fast command shouldn't wait for the slow one")
+	close(release)
+	assert.Equal(t, "slow", <-results, "the slow command should get its own answer")
+}
+
+func TestEvents(t *testing.T) {
+	c, f := newFakeMPV(t, func(command []interface{}) (interface{}, string) {
+		return nil, "success"
+	})
+
+	require.NoError(t, c.ObserveProperty(1, "eof-reached"))
+	assert.Equal(t, []interface{}{"observe_property", float64(1), "eof-reached"}, <-f.commands)
+
+	f.send(map[string]interface{}{"event": "file-loaded"})
+	f.send(map[string]interface{}{"event": "property-change", "id": 1, "name": "eof-reached", "data": true})
+
+	event := <-c.Events()
+	assert.Equal(t, "file-loaded", event.Event)
+	event = <-c.Events()
+	assert.Equal(t, "property-change", event.Event)
+	assert.Equal(t, 1, event.ID)
+	assert.Equal(t, "eof-reached", event.Name)
+	assert.Equal(t, "true", string(event.Data))
+}
+
+func TestClosed(t *testing.T) {
+	c, f := newFakeMPV(t, func(command []interface{}) (interface{}, string) {
+		select {} // never answers
+	})
+
+	errs := make(chan error)
+	go func() {
+		_, err := c.Command("quit")
+		errs <- err
+	}()
+	<-f.commands
+	f.conn.Close()
+
+	select {
+	case err := <-errs:
+		assert.Equal(t, ErrClosed, err, "waiting commands should fail when the connection goes")
+	case <-time.After(time.Second):
+		t.Fatal("command still waiting after the connection closed")
+	}
+	_, ok := <-c.Events()
+	assert.False(t, ok, "events should be closed")
+	_, err := c.Command("quit")
+	assert.Equal(t, ErrClosed, err, "new commands should fail once the connection's gone")
+}
+
+func TestTimeout(t *testing.T) {
+	old := Timeout
+	Timeout = 50 * time.Millisecond
+	defer func() { Timeout = old }()
+
+	c, _ := newFakeMPV(t, func(command []interface{}) (interface{}, string) {
+		select {}
+	})
+	_, err := c.Command("quit")
+	assert.Equal(t, ErrTimeout, err, fmt.Sprintf("should give up after %v", Timeout))
+}
@@ -0,0 +1,69 @@
+//go:build go1.18
+// +build go1.18
+
+package protocol
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func FuzzCommandFromString(f *testing.F) {
+	for _, seed := range []string{
+		"play",
+		"play:",
+		"play: speed: 200 loop: true single clip: true",
+		"play:\r\nspeed: 50\r\n",
+		"goto: timecode: 00:00:01:00",
+		"a: b:",
+		": :",
+		"clips add: name: Clip.mov",
+		"clips add: in: 00:00:01:00 name: My Clip.mov",
+		"record:\r\nname: Show Open\r\n",
+		"play: single clip: true speed: -50",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, str string) {
+		cmd, err := CommandFromString(str)
+		if err == nil {
+			for key, value := range cmd.Parameters {
+				if strings.IndexFunc(value, unicode.IsSpace) >= 0 {
+					t.Fatalf("parameter %q has spaces without a grammar: %q", key, value)
+				}
+			}
+			checkCommand(t, str, cmd, nil)
+		}
+
+		cmd, err = ParseCommand(str, testGrammar)
+		if err == nil {
+			checkCommand(t, str, cmd, testGrammar)
+		}
+	})
+}
+
+// checkCommand checks a parsed command looks sane, and comes back the same from the multi-line form
+func checkCommand(t *testing.T, str string, cmd *Command, grammar Grammar) {
+	if cmd.Name == "" || strings.TrimSpace(cmd.Name) != cmd.Name {
+		t.Fatalf("bad name %q", cmd.Name)
+	}
+	for key, value := range cmd.Parameters {
+		if key == "" || value == "" || strings.TrimSpace(value) != value || strings.ContainsAny(value, "\r\n") {
+			t.Fatalf("bad parameter %q: %q", key, value)
+		}
+	}
+
+	again, err := ParseCommand(cmd.Marshall(), grammar)
+	if err != nil {
+		t.Fatalf("couldn't parse %q again: %v", cmd.Marshall(), err)
+	}
+	if again.Name != cmd.Name || len(again.Parameters) != len(cmd.Parameters) {
+		t.Fatalf("%q parsed as %v, then %v", str, cmd, again)
+	}
+	for key, value := range cmd.Parameters {
+		if again.Parameters[key] != value {
+			t.Fatalf("%q parsed as %v, then %v", str, cmd, again)
+		}
+	}
+}
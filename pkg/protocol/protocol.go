@@ -1,7 +1,10 @@
 package protocol
 
 import (
+	"errors"
+	"fmt"
 	"strings"
+	"unicode"
 )
 
 // Failure response codes (100-199)
@@ -45,29 +48,41 @@ type Command struct {
 	Parameters map[string]string
 }
 
-// CommandFromString creates a command from a string... just like it says
-func CommandFromString(cmd string) *Command {
+// ErrMalformed is returned when a command can't be parsed, which the deck answers with ErrSyntax
+var ErrMalformed = errors.New("malformed command")
+
+// Grammar returns the names of the parameters a command takes, which lets their values contain spaces and colons;
+// nil if it doesn't know the command
+type Grammar func(name string) []string
+
+// CommandFromString creates a command from a string... just like it says. It doesn't know any commands, so each
+// value runs up to the next whitespace; use ParseCommand to allow spaces.
+func CommandFromString(cmd string) (*Command, error) {
+	return ParseCommand(cmd, nil)
+}
+
+// ParseCommand creates a command from a string, which is either a single line like "play: speed: 200 loop: true", or
+// multi-line with parameters on the lines after the name. The values of parameters the grammar knows run up to the
+// next known parameter or the end of the line, so "record: name: Show Open" works; any others run up to the next
+// whitespace.
+func ParseCommand(cmd string, grammar Grammar) (*Command, error) {
 	parts := strings.SplitN(cmd, ":", 2)
-	name := parts[0]
+	name := strings.Join(strings.Fields(parts[0]), " ")
+	if name == "" {
+		return nil, fmt.Errorf("%w: no command name", ErrMalformed)
+	}
 	params := make(map[string]string)
 
 	if len(parts) == 2 {
-		remainder := parts[1][1:]
-		for {
-			splitAtColon := strings.SplitN(remainder, ":", 2)
-			if len(splitAtColon) == 1 {
-				// No colon... stop parsing params
-				break
-			}
-			key := strings.TrimSpace(splitAtColon[0])
-			splitAtSpace := strings.SplitN(splitAtColon[1][1:], " ", 2)
-
-			val := strings.TrimSpace(splitAtSpace[0])
-			params[key] = val
-
-			remainder = ""
-			if len(splitAtSpace) == 2 {
-				remainder = splitAtSpace[1]
+		var known []string
+		if grammar != nil {
+			known = grammar(name)
+		}
+		lines := strings.FieldsFunc(parts[1], func(r rune) bool { return r == '\r' || r == '\n' })
+		for _, line := range lines {
+			err := parseParameters(strings.TrimSpace(line), known, params)
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
@@ -75,7 +90,67 @@ func CommandFromString(cmd string) *Command {
 	return &Command{
 		Name:       name,
 		Parameters: params,
+	}, nil
+}
+
+// parseParameters parses a line of "key: value" pairs into params
+func parseParameters(line string, known []string, params map[string]string) error {
+	for line != "" {
+		key, rest, isKnown := knownKey(line, known)
+		if !isKnown {
+			colon := strings.Index(line, ":")
+			if colon < 0 {
+				return fmt.Errorf("%w: %q isn't a parameter", ErrMalformed, line)
+			}
+			key = strings.Join(strings.Fields(line[:colon]), " ")
+			if key == "" {
+				return fmt.Errorf("%w: parameter with no name", ErrMalformed)
+			}
+			rest = line[colon+1:]
+		}
+
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		end := len(rest)
+		if isKnown {
+			end = nextKey(rest, known)
+		} else if space := strings.IndexFunc(rest, unicode.IsSpace); space >= 0 {
+			end = space
+		}
+		value := strings.TrimSpace(rest[:end])
+		if value == "" {
+			return fmt.Errorf("%w: %q has no value", ErrMalformed, key)
+		}
+		params[key] = value
+		line = strings.TrimSpace(rest[end:])
+	}
+	return nil
+}
+
+// knownKey returns the longest known key that line starts with, followed by a colon, and what comes after the colon
+func knownKey(line string, known []string) (string, string, bool) {
+	best := ""
+	for _, key := range known {
+		if len(key) > len(best) && strings.HasPrefix(line, key+":") {
+			best = key
+		}
+	}
+	if best == "" {
+		return "", "", false
+	}
+	return best, line[len(best)+1:], true
+}
+
+// nextKey returns where the next known key starts in s, at the start or after a space, or len(s) if there isn't one
+func nextKey(s string, known []string) int {
+	for i := 0; i < len(s); i++ {
+		if i > 0 && s[i-1] != ' ' && s[i-1] != '\t' {
+			continue
+		}
+		if _, _, ok := knownKey(s[i:], known); ok {
+			return i
+		}
 	}
+	return len(s)
 }
 
 func (c *Command) Marshall() string {
@@ -1,16 +1,24 @@
 package protocol
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func mustParse(t *testing.T, str string) *Command {
+	cmd, err := CommandFromString(str)
+	require.NoError(t, err, "should parse %q", str)
+	return cmd
+}
+
 func TestCommand(t *testing.T) {
 	assert.Equal(t, &Command{
 		Name:       "play",
 		Parameters: make(map[string]string),
-	}, CommandFromString("play"), "")
+	}, mustParse(t, "play"), "")
 }
 
 func TestCommandWithParams(t *testing.T) {
@@ -21,7 +29,7 @@ func TestCommandWithParams(t *testing.T) {
 			"loop":        "true",
 			"single clip": "true",
 		},
-	}, CommandFromString("play: speed: 200 loop: true single clip: true"), "it should parse a command string correctly")
+	}, mustParse(t, "play: speed: 200 loop: true single clip: true"), "it should parse a command string correctly")
 
 	assert.Equal(t, &Command{
 		Name: "play",
@@ -30,20 +38,88 @@ func TestCommandWithParams(t *testing.T) {
 			"loop":        "true",
 			"single clip": "true",
 		},
-	}, CommandFromString("play: speed: 200 single clip: true loop: true"), "it should parse a command string correctly")
+	}, mustParse(t, "play: speed: 200 single clip: true loop: true"), "it should parse a command string correctly")
 
 	assert.Equal(t, &Command{
 		Name: "play",
 		Parameters: map[string]string{
 			"speed": "200",
 		},
-	}, CommandFromString("play: speed: 200"), "it should parse a command string correctly")
+	}, mustParse(t, "play: speed: 200"), "it should parse a command string correctly")
 
-	// This is insufficient! We need to test this a layer higher at the Server... it needs to pass the newlines through
 	assert.Equal(t, &Command{
 		Name: "play",
 		Parameters: map[string]string{
 			"speed": "50",
 		},
-	}, CommandFromString("play:    \r\n       speed:     50"), "it should parse a command string correctly")
+	}, mustParse(t, "play:    \r\n       speed:     50"), "it should parse a command string correctly")
+}
+
+func TestCommandMultiLine(t *testing.T) {
+	assert.Equal(t, &Command{
+		Name: "play",
+		Parameters: map[string]string{
+			"speed":       "50",
+			"single clip": "true",
+		},
+	}, mustParse(t, "play:\r\nspeed: 50\r\nsingle clip: true\r\n"), "it should parse a multi-line command")
+
+	assert.Equal(t, &Command{
+		Name:       "play",
+		Parameters: make(map[string]string),
+	}, mustParse(t, "play:\r\n"), "it should parse a multi-line command with no parameters")
+}
+
+func TestCommandMalformed(t *testing.T) {
+	for _, str := range []string{"", "   ", ":", ": speed: 50", "play: speed:", "a: b:", "play: speed", "play: : 50", "play: speed: 50 loop"} {
+		_, err := CommandFromString(str)
+		assert.True(t, errors.Is(err, ErrMalformed), "should not parse %q", str)
+	}
+}
+
+func testGrammar(name string) []string {
+	switch name {
+	case "record":
+		return []string{"name"}
+	case "clips add":
+		return []string{"clip id", "in", "out", "name"}
+	case "play":
+		return []string{"speed", "loop", "single clip"}
+	}
+	return nil
+}
+
+func TestParseCommand(t *testing.T) {
+	cmd, err := ParseCommand("record: name: Show Open", testGrammar)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "Show Open"}, cmd.Parameters, "should keep spaces in values")
+
+	cmd, err = ParseCommand("clips add: in: 00:00:01:00 out: 00:00:05:00 name: 12:30 News – Café.mov", testGrammar)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"in":   "00:00:01:00",
+		"out":  "00:00:05:00",
+		"name": "12:30 News – Café.mov",
+	}, cmd.Parameters, "should find values by the keys around them, even with colons and Unicode")
+
+	cmd, err = ParseCommand("clips add:\r\nclip id: 2\r\nname: My Clip.mov\r\n", testGrammar)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"clip id": "2", "name": "My Clip.mov"}, cmd.Parameters, "should take values to the end of the line")
+
+	cmd, err = ParseCommand("play: single clip: true speed: 50", testGrammar)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"single clip": "true", "speed": "50"}, cmd.Parameters, "should handle keys with spaces")
+
+	cmd, err = ParseCommand("play: bogus: 1 speed: 50", testGrammar)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"bogus": "1", "speed": "50"}, cmd.Parameters, "should still parse unknown keys, for validation to reject")
+
+	_, err = ParseCommand("record: name: speed: 50", testGrammar)
+	assert.NoError(t, err, "should only split at the command's own keys")
+
+	_, err = ParseCommand("clips add: name: in: 00:00:01:00", testGrammar)
+	assert.True(t, errors.Is(err, ErrMalformed), "should not take the next key as a value")
+
+	_, err = ParseCommand("record: name: My Clip", nil)
+	assert.True(t, errors.Is(err, ErrMalformed), "should not allow spaces without a grammar")
 }
@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"bufio"
+	"strings"
+)
+
+// ReadMessage reads one command, response or asynchronous message off the wire: either a single line like "200 ok",
+// or, if the first line ends in a colon, every line up to a blank one. Multi-line messages keep their line endings, so
+// they look the same as what the deck's ProcessCommand returned; single lines don't have any.
+func ReadMessage(r *bufio.Reader) (string, error) {
+	var first string
+	for strings.TrimSpace(first) == "" {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		first = strings.TrimRight(line, "\r\n")
+	}
+	if !strings.HasSuffix(first, ":") {
+		return first, nil
+	}
+
+	msg := first + "\r\n"
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return msg, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return msg, nil
+		}
+		msg += line + "\r\n"
+	}
+}
+
+// IsAsync returns whether a message read with ReadMessage is an asynchronous one (5xx) rather than a response
+func IsAsync(msg string) bool {
+	return strings.HasPrefix(msg, "5")
+}
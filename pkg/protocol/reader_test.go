@@ -0,0 +1,33 @@
+package protocol
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadMessage(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("500 connection info:\r\nprotocol version: 1.11\r\nmodel: VLCDeck\r\n\r\n" +
+		"200 ok\r\n\r\n" +
+		"215 playrange info:\r\n\r\n"))
+
+	msg, err := ReadMessage(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "500 connection info:\r\nprotocol version: 1.11\r\nmodel: VLCDeck\r\n", msg, "should read multi-line messages up to the blank line")
+	assert.True(t, IsAsync(msg))
+
+	msg, err = ReadMessage(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "200 ok", msg, "should read single-line messages")
+	assert.False(t, IsAsync(msg))
+
+	msg, err = ReadMessage(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "215 playrange info:\r\n", msg, "should skip stray blank lines and read empty multi-line messages")
+
+	_, err = ReadMessage(r)
+	assert.Equal(t, io.EOF, err)
+}
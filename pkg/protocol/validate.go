@@ -0,0 +1,205 @@
+package protocol
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ValueType describes what values a parameter accepts
+type ValueType interface {
+	Validate(val string) string // returns the failure response for val, or "" if it's acceptable
+	Usage() string              // what goes between the braces in help, e.g. "true|false"
+}
+
+// Parameter describes a parameter a command accepts
+type Parameter struct {
+	Name string    // e.g. "single clip"
+	Type ValueType // nil accepts any value
+}
+
+// Usage returns the parameter the way it's shown in help, e.g. "loop: {true|false}"
+func (p Parameter) Usage() string {
+	if p.Type == nil {
+		return fmt.Sprintf("%v: {%v}", p.Name, p.Name)
+	}
+	return fmt.Sprintf("%v: {%v}", p.Name, p.Type.Usage())
+}
+
+// ValidateParameters checks params against the known parameters of a command, returning the failure response
+// a HyperDeck would give, or "" if they're all acceptable
+func ValidateParameters(known []Parameter, params map[string]string) string {
+	for name, val := range params {
+		var param *Parameter
+		for idx := range known {
+			if known[idx].Name == name {
+				param = &known[idx]
+				break
+			}
+		}
+		if param == nil {
+			return ErrUnsupportedParameter
+		}
+		if param.Type == nil {
+			continue
+		}
+		if res := param.Type.Validate(val); res != "" {
+			return res
+		}
+	}
+	return ""
+}
+
+type boolType struct{}
+
+func (boolType) Validate(val string) string {
+	if val != "true" && val != "false" {
+		return ErrInvalidValue
+	}
+	return ""
+}
+
+func (boolType) Usage() string {
+	return "true|false"
+}
+
+// Bool accepts true or false
+var Bool ValueType = boolType{}
+
+type enumType []string
+
+func (e enumType) Validate(val string) string {
+	for _, v := range e {
+		if v == val {
+			return ""
+		}
+	}
+	return ErrInvalidValue
+}
+
+func (e enumType) Usage() string {
+	return strings.Join(e, "|")
+}
+
+// Enum accepts exactly one of the given values
+func Enum(values ...string) ValueType {
+	return enumType(values)
+}
+
+type filesystemType struct{}
+
+func (filesystemType) Validate(val string) string {
+	if val != "exFAT" && val != "HFS+" {
+		return ErrInvalidFormat
+	}
+	return ""
+}
+
+func (filesystemType) Usage() string {
+	return "exFAT|HFS+"
+}
+
+// Filesystem accepts a filesystem a disk can be formatted with; anything else is an invalid format
+var Filesystem ValueType = filesystemType{}
+
+type intRangeType struct {
+	min int64
+	max int64
+}
+
+func (r intRangeType) Validate(val string) string {
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return ErrInvalidValue
+	}
+	if n < r.min || n > r.max {
+		return ErrOutOfRange
+	}
+	return ""
+}
+
+func (r intRangeType) Usage() string {
+	return fmt.Sprintf("%v...%v", r.min, r.max)
+}
+
+// IntRange accepts integers from min to max, inclusive. Anything that isn't an integer is an invalid value, and
+// integers outside the range are out of range.
+func IntRange(min, max int64) ValueType {
+	return intRangeType{min: min, max: max}
+}
+
+type relativeType struct {
+	max uint64
+}
+
+func (r relativeType) Validate(val string) string {
+	if len(val) < 2 || (val[0] != '+' && val[0] != '-') {
+		return ErrInvalidValue
+	}
+	n, err := strconv.ParseUint(val[1:], 10, 64)
+	if err != nil {
+		return ErrInvalidValue
+	}
+	if n > r.max {
+		return ErrOutOfRange
+	}
+	return ""
+}
+
+func (r relativeType) Usage() string {
+	return "+n|-n"
+}
+
+// Relative accepts an offset like +3 or -1, up to max in either direction
+func Relative(max uint64) ValueType {
+	return relativeType{max: max}
+}
+
+var timecodeRegexp = regexp.MustCompile(`^\d{2}:[0-5]\d:[0-5]\d[:;]\d{2}$`)
+
+type timecodeType struct{}
+
+func (timecodeType) Validate(val string) string {
+	if !timecodeRegexp.MatchString(val) {
+		return ErrInvalidValue
+	}
+	return ""
+}
+
+func (timecodeType) Usage() string {
+	return "timecode"
+}
+
+// Timecode accepts a timecode in the form hh:mm:ss:ff (or hh:mm:ss;ff for drop-frame)
+var Timecode ValueType = timecodeType{}
+
+type anyOfType []ValueType
+
+func (a anyOfType) Validate(val string) string {
+	res := ErrInvalidValue
+	for idx, t := range a {
+		r := t.Validate(val)
+		if r == "" {
+			return ""
+		}
+		if idx == 0 || r == ErrOutOfRange {
+			// out of range means it was the right kind of value, which is the more useful answer
+			res = r
+		}
+	}
+	return res
+}
+
+func (a anyOfType) Usage() string {
+	usages := make([]string, 0, len(a))
+	for _, t := range a {
+		usages = append(usages, t.Usage())
+	}
+	return strings.Join(usages, "|")
+}
+
+// AnyOf accepts a value that any one of the given types accepts
+func AnyOf(types ...ValueType) ValueType {
+	return anyOfType(types)
+}
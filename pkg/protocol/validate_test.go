@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueTypes(t *testing.T) {
+	tests := []struct {
+		valueType ValueType
+		val       string
+		res       string
+	}{
+		{Bool, "true", ""},
+		{Bool, "false", ""},
+		{Bool, "yes", ErrInvalidValue},
+		{Bool, "", ErrInvalidValue},
+		{Enum("exFAT", "HFS+"), "HFS+", ""},
+		{Enum("exFAT", "HFS+"), "NTFS", ErrInvalidValue},
+		{Filesystem, "exFAT", ""},
+		{Filesystem, "NTFS", ErrInvalidFormat},
+		{IntRange(-1600, 1600), "-1600", ""},
+		{IntRange(-1600, 1600), "200", ""},
+		{IntRange(-1600, 1600), "1601", ErrOutOfRange},
+		{IntRange(-1600, 1600), "fast", ErrInvalidValue},
+		{IntRange(-1600, 1600), "2.5", ErrInvalidValue},
+		{Relative(10), "+3", ""},
+		{Relative(10), "-10", ""},
+		{Relative(10), "+11", ErrOutOfRange},
+		{Relative(10), "3", ErrInvalidValue},
+		{Relative(10), "+", ErrInvalidValue},
+		{Timecode, "00:01:02:03", ""},
+		{Timecode, "00:01:02;03", ""},
+		{Timecode, "00:61:02:03", ErrInvalidValue},
+		{Timecode, "1:2:3:4", ErrInvalidValue},
+		{AnyOf(IntRange(1, 10), Relative(10)), "5", ""},
+		{AnyOf(IntRange(1, 10), Relative(10)), "+5", ""},
+		{AnyOf(IntRange(1, 10), Relative(10)), "11", ErrOutOfRange},
+		{AnyOf(IntRange(1, 10), Relative(10)), "five", ErrInvalidValue},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.res, test.valueType.Validate(test.val), "validating %q as %v", test.val, test.valueType.Usage())
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	known := []Parameter{
+		{Name: "speed", Type: IntRange(-1600, 1600)},
+		{Name: "single clip", Type: Bool},
+		{Name: "name"},
+	}
+
+	assert.Equal(t, "", ValidateParameters(known, map[string]string{"speed": "200", "single clip": "true", "name": "anything"}), "should accept valid parameters")
+	assert.Equal(t, ErrUnsupportedParameter, ValidateParameters(known, map[string]string{"singleClip": "true"}), "should reject unknown parameters")
+	assert.Equal(t, ErrInvalidValue, ValidateParameters(known, map[string]string{"single clip": "1"}), "should reject invalid values")
+	assert.Equal(t, ErrOutOfRange, ValidateParameters(known, map[string]string{"speed": "-2000"}), "should reject out of range values")
+}
@@ -0,0 +1,258 @@
+// Package websocket is just enough of RFC 6455 to push events to browsers: text messages, pings and closing
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyGUID is appended to the client's key to make the accept key, as per the RFC
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxMessageSize is the most a client can send in one message; nobody needs to send us anything big
+const MaxMessageSize = 1 << 20
+
+// Opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes
+const (
+	CloseNormal        = 1000
+	CloseProtocolError = 1002
+	CloseTooBig        = 1009
+)
+
+// ErrProtocol is returned when the client breaks the rules
+var ErrProtocol = errors.New("websocket protocol error")
+
+// Conn is a server-side WebSocket connection. Reads have to happen on one goroutine, but anything can write.
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writeLock sync.Mutex
+}
+
+// AcceptKey works out the Sec-WebSocket-Accept header for a client's Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + keyGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains checks whether a comma-separated header has token in it, ignoring case
+func headerContains(h http.Header, name string, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrade takes over an HTTP request that's asking to be a WebSocket. If it can't, it replies with an error
+// itself, so the handler just has to return.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a websocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("unsupported websocket version %v", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't upgrade this connection", http.StatusInternalServerError)
+		return nil, fmt.Errorf("response can't be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("error hijacking connection: %w", err)
+	}
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n", AcceptKey(key))
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error completing handshake: %w", err)
+	}
+	return &Conn{
+		conn:   conn,
+		reader: rw.Reader,
+	}, nil
+}
+
+// RemoteAddr returns the client's address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetWriteDeadline makes writes that haven't finished by t fail, as net.Conn's does
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage returns the next text or binary message, answering pings along the way. It returns io.EOF once the
+// client has closed the connection.
+func (c *Conn) ReadMessage() (string, error) {
+	message := make([]byte, 0)
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			if err == ErrProtocol {
+				c.closeWith(CloseProtocolError)
+			}
+			return "", err
+		}
+
+		switch opcode {
+		case opPing:
+			err = c.writeFrame(opPong, payload)
+			if err != nil {
+				return "", err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.closeWith(CloseNormal)
+			return "", io.EOF
+		case opText, opBinary:
+			if started {
+				c.closeWith(CloseProtocolError)
+				return "", ErrProtocol
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				c.closeWith(CloseProtocolError)
+				return "", ErrProtocol
+			}
+		default:
+			c.closeWith(CloseProtocolError)
+			return "", ErrProtocol
+		}
+
+		if len(message)+len(payload) > MaxMessageSize {
+			c.closeWith(CloseTooBig)
+			return "", fmt.Errorf("message bigger than %v bytes", MaxMessageSize)
+		}
+		message = append(message, payload...)
+		if fin {
+			return string(message), nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	_, err = io.ReadFull(c.reader, header)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, ErrProtocol // no extensions were negotiated
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, ErrProtocol // clients always have to mask
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(c.reader, ext)
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		_, err = io.ReadFull(c.reader, ext)
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if length > MaxMessageSize {
+		c.closeWith(CloseTooBig)
+		return false, 0, nil, fmt.Errorf("frame bigger than %v bytes", MaxMessageSize)
+	}
+
+	mask := make([]byte, 4)
+	_, err = io.ReadFull(c.reader, mask)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends a text message
+func (c *Conn) WriteMessage(msg string) error {
+	return c.writeFrame(opText, []byte(msg))
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// closeWith sends a close frame with status, and closes the connection
+func (c *Conn) closeWith(status uint16) {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, status)
+	c.writeFrame(opClose, payload)
+	c.conn.Close()
+}
+
+// Close closes the connection normally
+func (c *Conn) Close() error {
+	c.closeWith(CloseNormal)
+	return nil
+}
@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// the example from RFC 6455
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "should make the accept key")
+}
+
+// writeClientFrame writes a masked frame, like a browser would
+func writeClientFrame(t *testing.T, w io.Writer, fin bool, opcode byte, payload string) {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{first, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i := range payload {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	require.NoError(t, err)
+}
+
+// readServerFrame reads an unmasked frame from the server
+func readServerFrame(t *testing.T, r io.Reader) (byte, string) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(r, header)
+	require.NoError(t, err)
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(r, ext)
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	return header[0] & 0x0f, string(payload)
+}
+
+func TestConn(t *testing.T) {
+	done := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			done <- err
+			return
+		}
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			conn.WriteMessage(strings.ToUpper(msg))
+		}
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "should refuse plain requests")
+	assert.Error(t, <-done)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: fakedeck\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode, "should switch protocols")
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"), "should accept the key")
+
+	writeClientFrame(t, conn, true, opText, "hello")
+	opcode, payload := readServerFrame(t, reader)
+	assert.Equal(t, byte(opText), opcode)
+	assert.Equal(t, "HELLO", payload, "should read and write messages")
+
+	writeClientFrame(t, conn, false, opText, "frag")
+	writeClientFrame(t, conn, true, opPing, "are you there")
+	writeClientFrame(t, conn, true, opContinuation, "mented")
+	opcode, payload = readServerFrame(t, reader)
+	assert.Equal(t, byte(opPong), opcode, "should answer pings, even mid-message")
+	assert.Equal(t, "are you there", payload)
+	_, payload = readServerFrame(t, reader)
+	assert.Equal(t, "FRAGMENTED", payload, "should put fragmented messages back together")
+
+	writeClientFrame(t, conn, true, opClose, "")
+	opcode, _ = readServerFrame(t, reader)
+	assert.Equal(t, byte(opClose), opcode, "should close when asked")
+	assert.Equal(t, io.EOF, <-done)
+}